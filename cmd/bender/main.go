@@ -2,17 +2,29 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/adamhassel/bender/internal/config"
+	"github.com/adamhassel/bender/internal/factoids"
+	"github.com/adamhassel/bender/internal/lib/commands"
+	"github.com/adamhassel/bender/internal/lib/control"
 	"github.com/adamhassel/bender/internal/lib/irc"
 	"github.com/adamhassel/bender/internal/lib/plugins"
+	"github.com/adamhassel/bender/internal/lib/scheduler"
+	"github.com/adamhassel/bender/internal/lib/web"
 )
 
 // TODO: accept command line arg to specify config file
 const defaultConffile = "conf/conf.yml"
 
 func main() {
+	commandsMD := flag.String("commands-md", "", "write the command catalog as Markdown to this file (\"-\" for stdout) and exit")
+	flag.Parse()
+
 	var c config.Config
 	err := config.ParseConfFile(defaultConffile, &c)
 
@@ -22,13 +34,62 @@ func main() {
 
 	setServerIdentity(&c)
 	config.InitLogger(&c)
-	ctx := c.Context(context.Background())
+	control.Handle("reload", "", "Re-read the configuration file. Servers, channels, identities, announcements and "+
+		"plugins need a restart", func(context.Context, []string) (any, error) {
+		return nil, reload(&c)
+	})
+	// SIGINT or SIGTERM shuts the bot down. A second one kills it.
+	ctx, stop := signal.NotifyContext(c.Context(context.Background()), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		log.Println("shutting down")
+		stop()
+	}()
 	if err := plugins.LoadPlugins(c.Plugins); err != nil {
 		log.Println(err)
 	}
+	if *commandsMD != "" {
+		if err := writeCommands(*commandsMD, c.CommandChars("", "")[0]); err != nil {
+			log.Fatalf("error writing command catalog: %s", err)
+		}
+		return
+	}
+	go func() {
+		if err := web.Serve(ctx); err != nil {
+			log.Printf("error running web server: %s", err)
+		}
+	}()
+	go func() {
+		if err := control.Serve(ctx); err != nil {
+			log.Printf("error running control socket: %s", err)
+		}
+	}()
 	if err := irc.InitBot(ctx); err != nil {
 		log.Printf("error initializing bot: %s", err)
 	}
+	shutdown()
+}
+
+// reload re-reads the configuration file, and hands it to the running bot
+func reload(c *config.Config) error {
+	var nc config.Config
+	if err := config.ParseConfFile(defaultConffile, &nc); err != nil {
+		return err
+	}
+	setServerIdentity(&nc)
+	nc.Main.LogWriter = c.Main.LogWriter
+	irc.Reload(nc)
+	return nil
+}
+
+// shutdown stops scheduled jobs, and has plugins and the factoid database save what they haven't yet
+func shutdown() {
+	scheduler.Stop()
+	plugins.Shutdown()
+	if err := factoids.Sync(); err != nil {
+		log.Printf("error saving factoids: %s", err)
+	}
 }
 
 func setServerIdentity(c *config.Config) {
@@ -46,3 +107,16 @@ func setServerIdentity(c *config.Config) {
 		c.Servers[server] = sconf
 	}
 }
+
+// writeCommands writes the command catalog as Markdown to filename, or stdout if filename is "-"
+func writeCommands(filename, prefix string) error {
+	if filename == "-" {
+		return commands.Markdown(os.Stdout, prefix)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return commands.Markdown(f, prefix)
+}
@@ -0,0 +1,82 @@
+// benderctl runs commands on a running bot through its control socket, e.g.
+//
+//	benderctl say IRCNet '#mychannel' hello
+//	benderctl factoid get bender
+//	benderctl help
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/adamhassel/bender/internal/config"
+	"github.com/adamhassel/bender/internal/lib/control"
+)
+
+const defaultConffile = "conf/conf.yml"
+
+func main() {
+	socket := flag.String("socket", "", "the bot's control socket. Read from the configuration file if empty")
+	conffile := flag.String("config", defaultConffile, "the bot's configuration file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <command> [args...]\n\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\nRun \"%s help\" to list the commands.\n", os.Args[0])
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	path := *socket
+	if path == "" {
+		var c config.Config
+		if err := config.ParseConfFile(*conffile, &c); err != nil {
+			fatal(err)
+		}
+		if path = c.Main.Control; path == "" {
+			fatal(fmt.Errorf("no control socket in %s", *conffile))
+		}
+	}
+	result, err := control.Call(path, control.Request{Command: flag.Arg(0), Args: flag.Args()[1:]})
+	if err != nil {
+		fatal(err)
+	}
+	if err := print(result); err != nil {
+		fatal(err)
+	}
+}
+
+// print writes result to stdout: strings as they are, the command list as a table, and anything else as indented JSON
+func print(result json.RawMessage) error {
+	if len(result) == 0 || string(result) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(result, &s); err == nil {
+		fmt.Println(s)
+		return nil
+	}
+	var commands []control.Command
+	if flag.Arg(0) == "help" && json.Unmarshal(result, &commands) == nil {
+		for _, c := range commands {
+			fmt.Printf("%-8s %-40s %s\n", c.Name, c.Usage, c.Description)
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, result, "", "  "); err != nil {
+		return err
+	}
+	fmt.Println(buf.String())
+	return nil
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "benderctl: %s\n", err)
+	os.Exit(1)
+}
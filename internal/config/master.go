@@ -6,16 +6,41 @@ import (
 	"io"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
+
+	"github.com/adamhassel/bender/internal/helpers"
 )
 
 type Main struct {
-	Logfile     string    `yaml:"logfile"`
-	LogLevel    string    `yaml:"loglevel"`
-	LogWriter   io.Writer `yaml:"-"`
-	CommandChar string    `yaml:"commandchar"`
+	Logfile   string    `yaml:"logfile"`
+	LogLevel  string    `yaml:"loglevel"`
+	LogWriter io.Writer `yaml:"-"`
+	// CommandChar is the command prefix. Deprecated: use CommandChars, which takes precedence if set
+	CommandChar string `yaml:"commandchar"`
+	// CommandChars are the prefixes that make a message a command
+	CommandChars []string `yaml:"commandchars"`
+	// HTTP configures the built-in web server. It's off unless Listen is set
+	HTTP HTTP `yaml:"http"`
+	// Timezone is the time zone of users who haven't set their own, e.g. "Europe/Copenhagen". Local time if empty.
+	Timezone string `yaml:"timezone"`
+	// QuitMessage is sent with QUIT when the bot shuts down
+	QuitMessage string `yaml:"quitmessage"`
+	// Control is the path of the UNIX socket benderctl talks to. The control API is off if it's empty.
+	Control string `yaml:"control"`
+}
+
+// HTTP holds the settings of the built-in web server. Requests must carry Token, either as a bearer token or a
+// "token" query parameter, or log in as one of Users with basic auth.
+type HTTP struct {
+	// Listen is the address to listen on, e.g. ":8080" or "localhost:8080"
+	Listen string `yaml:"listen"`
+	Token  string `yaml:"token"`
+	// Users maps user names to passwords
+	Users map[string]string `yaml:"users"`
 }
 
 type Identity struct {
@@ -24,14 +49,91 @@ type Identity struct {
 	Modestring string `yaml:"modestring"`
 }
 
+// Toggles holds lists of commands and plugins to allow or deny. An entry is the name of a command (built-in or from a
+// plugin), the name of a command group (e.g. "factoids"), or the name of a plugin, which covers all commands and
+// matchers in that plugin. "*" matches anything. If Allow is non-empty, anything not in it is denied.
+type Toggles struct {
+	Allow []string `yaml:"allow,omitempty"`
+	Deny  []string `yaml:"deny,omitempty"`
+}
+
+// ChannelOpts holds per-channel settings. Anything set here overrides the server settings for the channel
+type ChannelOpts struct {
+	Toggles      `yaml:",inline"`
+	CommandChars []string `yaml:"commandchars"`
+	Beatme       *Beatme  `yaml:"beatme"`
+	// Ignore is who to ignore in the channel, on top of the server's Ignore
+	Ignore []string `yaml:"ignore"`
+}
+
+// Beatme configures the beatme game. Empty settings get the defaults of the original game: fridays in Los Angeles or
+// Copenhagen, with Danish kick messages.
+type Beatme struct {
+	// Days are the days of the week, e.g. "friday", when a random channel member is kicked. Any other day, whoever
+	// asked is kicked.
+	Days []string `yaml:"days"`
+	// Timezones are where it must be one of Days, e.g. "Europe/Copenhagen". Any of them will do.
+	Timezones []string `yaml:"timezones"`
+	// Messages are kick messages for when the player doesn't give one. One is picked at random.
+	Messages []string `yaml:"messages"`
+	// WrongDay are kick messages for players who ask on the wrong day
+	WrongDay []string `yaml:"wrongday"`
+	// Cooldown is how long to wait between games in a channel, e.g. "10m"
+	Cooldown string `yaml:"cooldown"`
+	// Protected are nicks or nick!user@host masks, wildcards allowed, that are never kicked
+	Protected []string `yaml:"protected"`
+	// DryRun announces who would have been kicked, instead of kicking
+	DryRun bool `yaml:"dryrun"`
+}
+
 type ServerOpts struct {
+	// Network is the name of the IRC network. The server name is used if it's empty.
+	Network            string   `yaml:"network"`
 	Port               int      `yaml:"port"`
 	SSL                bool     `yaml:"ssl"`
 	SkipInsecureVerify bool     `yaml:"sslskipverify"`
 	Password           string   `yaml:"password"`
 	Channels           []string `yaml:"channels"`
-	Ignore             []string `yaml:"ignore"`
-	Identity           Identity `yaml:"identity"`
+	// Ignore is who to ignore: nicks or nick!user@host masks with wildcards, "$a:account" or "/regex/"
+	Ignore        []string `yaml:"ignore"`
+	Identity      Identity `yaml:"identity"`
+	Toggles       `yaml:",inline"`
+	CommandChars  []string               `yaml:"commandchars"`
+	ChannelOpts   map[string]ChannelOpts `yaml:"channelopts"`
+	Announcements []Announcement         `yaml:"announcements"`
+	Beatme        Beatme                 `yaml:"beatme"`
+	RateLimit     RateLimit              `yaml:"ratelimit"`
+}
+
+// Limit is how often something may happen: Burst times in a row, and Burst times per Per on average, e.g. 5 per "1m"
+type Limit struct {
+	Burst int    `yaml:"burst"`
+	Per   string `yaml:"per"`
+}
+
+// RateLimit limits how often commands may be used. Limits that aren't set don't apply.
+type RateLimit struct {
+	// User limits each user, across channels
+	User Limit `yaml:"user"`
+	// Channel limits each channel
+	Channel Limit `yaml:"channel"`
+	// Commands limit each command in each channel, by command name, alias or group. A group's limit is shared by its
+	// commands.
+	Commands map[string]Limit `yaml:"commands"`
+	// Exempt are the roles that aren't limited
+	Exempt []string `yaml:"exempt"`
+}
+
+// Announcement is a message the bot sends to a channel on a schedule
+type Announcement struct {
+	// Cron is when to send it, as a standard cron expression, e.g. "0 9 * * 1-5" for 9:00 on weekdays
+	Cron string `yaml:"cron"`
+	// Timezone is the time zone of Cron. The global time zone is used if it's empty.
+	Timezone string `yaml:"timezone"`
+	Channel  string `yaml:"channel"`
+	Message  string `yaml:"message"`
+	// Action sends the message as an action (/me)
+	Action bool `yaml:"action"`
 }
 
 type Config struct {
@@ -39,11 +141,16 @@ type Config struct {
 	Identity Identity              `yaml:"identity"`
 	Servers  map[string]ServerOpts `yaml:"servers"`
 	Plugins  map[string]string     `yaml:"plugins"`
+	// Roles maps role names, e.g. "admin", to the hostmasks (nick!user@host, wildcards allowed) that have the role
+	Roles map[string][]string `yaml:"roles"`
 }
 
 type ctxconf int
 
-const configkey ctxconf = iota
+const (
+	configkey ctxconf = iota
+	serverkey
+)
 
 // InitLogger initializes the logger
 func InitLogger(config *Config) {
@@ -97,6 +204,43 @@ func (c Config) ServerPort(s string) string {
 	return ""
 }
 
+// CommandChars returns the command prefixes for `channel` on `server`. Channel settings override server settings,
+// which override the global settings.
+func (c Config) CommandChars(server, channel string) []string {
+	sconf := c.Servers[server]
+	if co, ok := sconf.Channel(channel); ok && len(co.CommandChars) > 0 {
+		return co.CommandChars
+	}
+	if len(sconf.CommandChars) > 0 {
+		return sconf.CommandChars
+	}
+	if len(c.Main.CommandChars) > 0 {
+		return c.Main.CommandChars
+	}
+	return []string{c.Main.CommandChar}
+}
+
+// HasRole reports whether a user with `hostmask` has `role`. Everyone has the empty role.
+func (c Config) HasRole(role, hostmask string) bool {
+	if role == "" {
+		return true
+	}
+	for _, mask := range c.Roles[role] {
+		if helpers.MatchMask(mask, hostmask) {
+			return true
+		}
+	}
+	return false
+}
+
+// Location returns the global time zone
+func (c Config) Location() (*time.Location, error) {
+	if c.Main.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(c.Main.Timezone)
+}
+
 // Context returns a new context from ctx with c attached
 func (c Config) Context(ctx context.Context) context.Context {
 	return context.WithValue(ctx, configkey, c)
@@ -111,3 +255,81 @@ func FromContext(ctx context.Context) Config {
 	}
 	return Config{}
 }
+
+// WithServer returns a new context from ctx with the name of the server being handled attached
+func WithServer(ctx context.Context, server string) context.Context {
+	return context.WithValue(ctx, serverkey, server)
+}
+
+// ServerFromContext returns the name and options of the server attached to ctx with WithServer. If there is none, or
+// the configuration in ctx doesn't know it, the zero values are returned
+func ServerFromContext(ctx context.Context) (string, ServerOpts) {
+	server, ok := ctx.Value(serverkey).(string)
+	if !ok {
+		return "", ServerOpts{}
+	}
+	return server, FromContext(ctx).Servers[server]
+}
+
+// Channel returns the options for channel. Channel names are case insensitive
+func (s ServerOpts) Channel(channel string) (ChannelOpts, bool) {
+	if co, ok := s.ChannelOpts[channel]; ok {
+		return co, true
+	}
+	for name, co := range s.ChannelOpts {
+		if strings.EqualFold(name, channel) {
+			return co, true
+		}
+	}
+	return ChannelOpts{}, false
+}
+
+// BeatmeFor returns the beatme settings for channel. Channel settings replace the server settings.
+func (s ServerOpts) BeatmeFor(channel string) Beatme {
+	if co, ok := s.Channel(channel); ok && co.Beatme != nil {
+		return *co.Beatme
+	}
+	return s.Beatme
+}
+
+// Enabled reports whether a command or matcher known by any of `names` may run in `channel` on this server. Channel
+// lists are consulted first, and the server lists are used if the channel lists don't mention any of the names.
+func (s ServerOpts) Enabled(channel string, names ...string) bool {
+	if co, ok := s.Channel(channel); ok {
+		if allowed, decided := co.Toggles.verdict(names); decided {
+			return allowed
+		}
+	}
+	if allowed, decided := s.Toggles.verdict(names); decided {
+		return allowed
+	}
+	return true
+}
+
+// verdict returns whether t allows `names`, and whether t has an opinion on them at all. A deny beats an allow.
+func (t Toggles) verdict(names []string) (allowed, decided bool) {
+	if matchesAny(t.Deny, names) {
+		return false, true
+	}
+	if matchesAny(t.Allow, names) {
+		return true, true
+	}
+	if len(t.Allow) > 0 {
+		return false, true
+	}
+	return false, false
+}
+
+func matchesAny(list, names []string) bool {
+	for _, entry := range list {
+		if entry == "*" {
+			return true
+		}
+		for _, name := range names {
+			if strings.EqualFold(entry, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
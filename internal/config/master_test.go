@@ -0,0 +1,93 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServerOpts_Enabled(t *testing.T) {
+	sconf := ServerOpts{
+		Toggles: Toggles{Deny: []string{"beatme", "urlshort"}},
+		ChannelOpts: map[string]ChannelOpts{
+			"#work":  {Toggles: Toggles{Allow: []string{"factoids", "chanlog"}}},
+			"#games": {Toggles: Toggles{Allow: []string{"beatme"}}},
+			"#quiet": {Toggles: Toggles{Deny: []string{"*"}}},
+		},
+	}
+	tests := []struct {
+		name    string
+		channel string
+		names   []string
+		want    bool
+	}{
+		{
+			name:    "not mentioned anywhere",
+			channel: "#other",
+			names:   []string{"coffee"},
+			want:    true,
+		},
+		{
+			name:    "denied on server",
+			channel: "#other",
+			names:   []string{"beatme"},
+			want:    false,
+		},
+		{
+			name:    "plugin denied on server",
+			channel: "#other",
+			names:   []string{"short", "urlshort"},
+			want:    false,
+		},
+		{
+			name:    "allowed in channel overrides server",
+			channel: "#games",
+			names:   []string{"beatme"},
+			want:    true,
+		},
+		{
+			name:    "channel allow list excludes others",
+			channel: "#work",
+			names:   []string{"coffee"},
+			want:    false,
+		},
+		{
+			name:    "group allowed in channel",
+			channel: "#work",
+			names:   []string{"?", "factoids"},
+			want:    true,
+		},
+		{
+			name:    "channel names are case insensitive",
+			channel: "#WORK",
+			names:   []string{"coffee"},
+			want:    false,
+		},
+		{
+			name:    "wildcard deny",
+			channel: "#quiet",
+			names:   []string{"coffee"},
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sconf.Enabled(tt.channel, tt.names...); got != tt.want {
+				t.Errorf("Enabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_Location(t *testing.T) {
+	if loc, err := (Config{}).Location(); err != nil || loc != time.Local {
+		t.Errorf("Location() without a time zone = %v, %v, want local time", loc, err)
+	}
+	c := Config{Main: Main{Timezone: "Europe/Copenhagen"}}
+	if loc, err := c.Location(); err != nil || loc.String() != "Europe/Copenhagen" {
+		t.Errorf("Location() = %v, %v", loc, err)
+	}
+	c.Main.Timezone = "Nowhere/Special"
+	if _, err := c.Location(); err == nil {
+		t.Error("Location() of an unknown time zone succeeded")
+	}
+}
@@ -22,6 +22,8 @@ type factoids struct {
 	m  sync.Mutex
 	v  map[string]FactoidSet
 	db string
+	// dirty is true if there are changes that couldn't be written to disk
+	dirty bool
 }
 
 const (
@@ -139,12 +141,9 @@ func Delete(key, substr string) error {
 	}
 	// delete the found element
 	f.m.Lock()
+	defer f.m.Unlock()
 	f.v[key].Delete(res)
-	if err := syncToDisk(); err != nil {
-		return err
-	}
-	f.m.Unlock()
-	return nil
+	return syncToDisk()
 }
 
 // Search returns a slice of maximum of `max` factoids and an integer with the number of additional facts found
@@ -194,8 +193,21 @@ func syncToDisk() error {
 	if err != nil {
 		return fmt.Errorf("error marshalling DB: %w", err)
 	}
-	if err := os.WriteFile(f.db, jsondata, 0644); err != nil {
+	if err := helpers.WriteFileAtomic(f.db, jsondata, 0644); err != nil {
+		f.dirty = true
 		return fmt.Errorf("error syncing to file %q: %w", f.db, err)
 	}
+	f.dirty = false
 	return nil
 }
+
+// Sync writes the factoid database to disk if there are changes that couldn't be written when they were made. Every
+// change is written right away, so this is for making sure before the bot exits.
+func Sync() error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if !f.dirty {
+		return nil
+	}
+	return syncToDisk()
+}
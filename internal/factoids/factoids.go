@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -147,3 +148,33 @@ func Store(msg string, from string) string {
 	lastfact = fullfactoid{key, fact}
 	return fmt.Sprintf("OK, %q %s %q", key, splitword, val)
 }
+
+// Keys returns all keywords starting with `prefix`, sorted
+func Keys(prefix string) []string {
+	f.m.Lock()
+	defer f.m.Unlock()
+	rv := make([]string, 0, len(f.v))
+	for k := range f.v {
+		if strings.HasPrefix(k, prefix) {
+			rv = append(rv, k)
+		}
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// Facts returns all facts for `key`, sorted by value
+func Facts(key string) ([]fullfactoid, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	vals, ok := f.v[key]
+	if !ok || len(vals) == 0 {
+		return nil, ErrNoSuchFact
+	}
+	rv := make([]fullfactoid, 0, len(vals))
+	for _, v := range vals.Slice() {
+		rv = append(rv, fullfactoid{Keyword: key, factoid: v})
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Value < rv[j].Value })
+	return rv, nil
+}
@@ -14,7 +14,7 @@ type factoid struct {
 }
 
 type fullfactoid struct {
-	Keyword string
+	Keyword string `json:"keyword"`
 	factoid
 }
 
@@ -0,0 +1,31 @@
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic writes data to filename like os.WriteFile, but through a temporary file that replaces filename when
+// it's complete. If the bot is stopped halfway through, the old file is still there.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,37 @@
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "db.json")
+	for _, content := range []string{"first", "second"} {
+		if err := WriteFileAtomic(filename, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("file = %q, want %q", got, content)
+		}
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", fi.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "db.json"), nil, 0600); err == nil {
+		t.Error("wrote to a directory that doesn't exist")
+	}
+}
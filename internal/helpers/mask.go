@@ -0,0 +1,32 @@
+package helpers
+
+import "strings"
+
+// MatchMask reports whether `s` matches the glob `pattern`, where '*' matches any number of characters and '?' matches
+// exactly one. Matching is case insensitive, as is usual for IRC hostmasks like "nick!*@*.example.com".
+func MatchMask(pattern, s string) bool {
+	p, str := []rune(strings.ToLower(pattern)), []rune(strings.ToLower(s))
+	var pi, si int
+	star, mark := -1, 0
+	for si < len(str) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == str[si]):
+			pi++
+			si++
+		case pi < len(p) && p[pi] == '*':
+			star, mark = pi, si
+			pi++
+		case star >= 0:
+			// backtrack: let the last star eat one more character
+			pi = star + 1
+			mark++
+			si = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
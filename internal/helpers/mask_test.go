@@ -0,0 +1,28 @@
+package helpers
+
+import "testing"
+
+func TestMatchMask(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"adam!*@*", "adam!~adam@host.example.com", true},
+		{"ADAM!*@*", "adam!~adam@host.example.com", true},
+		{"*!*@*.example.com", "someone!user@host.example.com", true},
+		{"*!*@*.example.com", "someone!user@example.com", false},
+		{"bot?!*@*", "bot1!x@y", true},
+		{"bot?!*@*", "bot!x@y", false},
+		{"*", "", true},
+		{"", "x", false},
+		{"a*b*c", "aXXbYYbZZc", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.s, func(t *testing.T) {
+			if got := MatchMask(tt.pattern, tt.s); got != tt.want {
+				t.Errorf("MatchMask() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ArgType is the type of a command argument
+type ArgType string
+
+// Argument types. An empty type is the same as String.
+const (
+	String  ArgType = "string"
+	Int     ArgType = "int"
+	Nick    ArgType = "nick"
+	Channel ArgType = "channel"
+)
+
+// Arg describes a positional argument to a command
+type Arg struct {
+	Name     string  `yaml:"name"`
+	Type     ArgType `yaml:"type"`
+	Optional bool    `yaml:"optional"`
+	// Rest makes the argument take everything that's left of the input. Only the last argument can be Rest.
+	Rest bool `yaml:"rest"`
+}
+
+// Flag describes a flag to a command, e.g. "--all" or "--since=2d"
+type Flag struct {
+	Name string `yaml:"name"`
+	// Value is true if the flag takes a value, either as "--flag=value" or "--flag value"
+	Value bool `yaml:"value"`
+}
+
+// Args holds the parsed arguments of a command
+type Args struct {
+	raw    string
+	tokens []string
+	values map[string]string
+	flags  map[string]string
+}
+
+// UsageError is returned when the arguments to a command doesn't match its spec
+type UsageError struct {
+	Spec Spec
+	Err  error
+}
+
+func (u *UsageError) Error() string {
+	return u.Err.Error()
+}
+
+func (u *UsageError) Unwrap() error {
+	return u.Err
+}
+
+// Message returns the error and the usage of the command, using `prefix` as the command char
+func (u *UsageError) Message(prefix string) string {
+	return fmt.Sprintf("%s. Usage: %s", u.Err, u.Spec.Synopsis(prefix))
+}
+
+var ErrUnterminatedQuote = errors.New("unterminated quote")
+
+var (
+	nickRe    = regexp.MustCompile(`^[A-Za-z\[\]\\` + "`" + `_^{|}][A-Za-z0-9\[\]\\` + "`" + `_^{|}-]*$`)
+	channelRe = regexp.MustCompile(`^[#&+!][^\s,\x07]+$`)
+)
+
+// token is a word from the input, and where in the input it starts
+type token struct {
+	value  string
+	start  int
+	quoted bool
+}
+
+// tokenize splits s into words separated by whitespace. A word starting with a double quote runs until the next
+// double quote, and may contain whitespace and escaped quotes (\"). If the last quote isn't terminated, the tokens are
+// returned with ErrUnterminatedQuote, and the last one is the rest of s, from the quote.
+func tokenize(s string) ([]token, error) {
+	var rv []token
+	i := 0
+	for i < len(s) {
+		if s[i] == ' ' || s[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		if s[i] != '"' {
+			for i < len(s) && s[i] != ' ' && s[i] != '\t' {
+				i++
+			}
+			rv = append(rv, token{value: s[start:i], start: start})
+			continue
+		}
+		var b strings.Builder
+		i++
+		for {
+			if i >= len(s) {
+				rv = append(rv, token{value: strings.TrimSpace(s[start:]), start: start, quoted: true})
+				return rv, ErrUnterminatedQuote
+			}
+			if s[i] == '\\' && i+1 < len(s) && s[i+1] == '"' {
+				b.WriteByte('"')
+				i += 2
+				continue
+			}
+			if s[i] == '"' {
+				i++
+				break
+			}
+			b.WriteByte(s[i])
+			i++
+		}
+		rv = append(rv, token{value: b.String(), start: start, quoted: true})
+	}
+	return rv, nil
+}
+
+// Tokenize splits s into words like the command parser does, honouring double quotes
+func Tokenize(s string) ([]string, error) {
+	toks, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	rv := make([]string, len(toks))
+	for i, t := range toks {
+		rv[i] = t.value
+	}
+	return rv, nil
+}
+
+// ParseArgs parses raw according to the arguments and flags in s. If s has neither, raw is only split into tokens.
+// Errors in the arguments are returned as a *UsageError. Quotes in the last argument are only checked if it isn't Rest,
+// since that takes the input as it is.
+func (s Spec) ParseArgs(raw string) (Args, error) {
+	a := Args{raw: strings.TrimSpace(raw), values: make(map[string]string), flags: make(map[string]string)}
+	toks, quoteErr := tokenize(raw)
+	if quoteErr != nil && !s.restful() {
+		return a, &UsageError{s, quoteErr}
+	}
+	for _, t := range toks {
+		a.tokens = append(a.tokens, t.value)
+	}
+	if len(s.Args) == 0 && len(s.Flags) == 0 {
+		return a, nil
+	}
+
+	var positional []token
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if t.quoted || !strings.HasPrefix(t.value, "--") || len(positional) >= len(s.Args)-1 && s.restful() {
+			positional = append(positional, t)
+			continue
+		}
+		if t.value == "--" {
+			positional = append(positional, toks[i+1:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(t.value, "--"), "=")
+		f, ok := s.flag(name)
+		if !ok {
+			return a, &UsageError{s, fmt.Errorf("unknown flag --%s", name)}
+		}
+		if f.Value && !hasValue {
+			if i+1 >= len(toks) {
+				return a, &UsageError{s, fmt.Errorf("flag --%s needs a value", name)}
+			}
+			i++
+			value = toks[i].value
+		}
+		a.flags[name] = value
+	}
+
+	// an unterminated quote is fine in the rest, which is taken as it is, but not before it
+	if quoteErr != nil {
+		n := len(positional)
+		if n < len(s.Args) || positional[n-1].start != toks[len(toks)-1].start {
+			return a, &UsageError{s, quoteErr}
+		}
+	}
+	for i, arg := range s.Args {
+		if i >= len(positional) {
+			if !arg.Optional {
+				return a, &UsageError{s, fmt.Errorf("missing %s", arg.Name)}
+			}
+			break
+		}
+		value := positional[i].value
+		if arg.Rest && len(positional) > i+1 {
+			value = strings.TrimSpace(raw[positional[i].start:])
+		}
+		if err := arg.check(value); err != nil {
+			return a, &UsageError{s, err}
+		}
+		a.values[arg.Name] = value
+	}
+	if len(positional) > len(s.Args) && !s.restful() {
+		return a, &UsageError{s, errors.New("too many arguments")}
+	}
+	return a, nil
+}
+
+// MatchArgs makes arguments from the submatches of a regular expression trigger. Positional arguments in s are filled
+// from the submatches in order, and named submatches are available by their name.
+func (s Spec) MatchArgs(re *regexp.Regexp, match []string) Args {
+	a := Args{raw: match[0], values: make(map[string]string), flags: make(map[string]string)}
+	a.tokens = append(a.tokens, match[1:]...)
+	for i, arg := range s.Args {
+		if i+1 < len(match) {
+			a.values[arg.Name] = match[i+1]
+		}
+	}
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			a.values[name] = match[i]
+		}
+	}
+	return a
+}
+
+// restful returns true if the last argument of s takes the rest of the input
+func (s Spec) restful() bool {
+	return len(s.Args) > 0 && s.Args[len(s.Args)-1].Rest
+}
+
+func (s Spec) flag(name string) (Flag, bool) {
+	for _, f := range s.Flags {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Flag{}, false
+}
+
+// usage makes a usage string from the arguments and flags of s
+func (s Spec) usage() string {
+	parts := make([]string, 0, len(s.Args)+len(s.Flags))
+	for _, arg := range s.Args {
+		name := arg.Name
+		if arg.Rest {
+			name += "..."
+		}
+		if arg.Optional {
+			parts = append(parts, "["+name+"]")
+		} else {
+			parts = append(parts, "<"+name+">")
+		}
+	}
+	for _, f := range s.Flags {
+		if f.Value {
+			parts = append(parts, "[--"+f.Name+"=<"+f.Name+">]")
+		} else {
+			parts = append(parts, "[--"+f.Name+"]")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// check validates value against the type of the argument
+func (arg Arg) check(value string) error {
+	switch arg.Type {
+	case Int:
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("%s must be a number, not %q", arg.Name, value)
+		}
+	case Nick:
+		if !nickRe.MatchString(value) {
+			return fmt.Errorf("%s must be a nick, not %q", arg.Name, value)
+		}
+	case Channel:
+		if !channelRe.MatchString(value) {
+			return fmt.Errorf("%s must be a channel, not %q", arg.Name, value)
+		}
+	}
+	return nil
+}
+
+// Raw returns the unparsed arguments
+func (a Args) Raw() string {
+	return a.raw
+}
+
+// Tokens returns the arguments split into words, with quotes removed
+func (a Args) Tokens() []string {
+	return a.tokens
+}
+
+// Get returns the value of the named argument, or an empty string if it wasn't given
+func (a Args) Get(name string) string {
+	return a.values[name]
+}
+
+// Has returns true if the named argument was given
+func (a Args) Has(name string) bool {
+	_, ok := a.values[name]
+	return ok
+}
+
+// Int returns the value of the named argument as an int. Arguments of type Int are validated during parsing, so this
+// only returns 0 for missing arguments.
+func (a Args) Int(name string) int {
+	i, _ := strconv.Atoi(a.values[name])
+	return i
+}
+
+// Flag returns the value of the named flag, and whether it was given at all
+func (a Args) Flag(name string) (string, bool) {
+	v, ok := a.flags[name]
+	return v, ok
+}
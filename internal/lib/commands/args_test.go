@@ -0,0 +1,211 @@
+package commands
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", in: "", want: []string{}},
+		{name: "repeated spaces", in: "  foo   bar ", want: []string{"foo", "bar"}},
+		{name: "quoted", in: `"foo bar" baz`, want: []string{"foo bar", "baz"}},
+		{name: "escaped quote", in: `"say \"hi\""`, want: []string{`say "hi"`}},
+		{name: "quote inside word", in: `friend's "beer"`, want: []string{"friend's", "beer"}},
+		{name: "backslash outside quotes", in: `\d+`, want: []string{`\d+`}},
+		{name: "unterminated", in: `"foo bar`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Tokenize(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Tokenize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpec_ParseArgs(t *testing.T) {
+	spec := Spec{
+		Name: "grep",
+		Args: []Arg{
+			{Name: "regex"},
+			{Name: "nick", Type: Nick, Optional: true},
+		},
+		Flags: []Flag{{Name: "all"}, {Name: "since", Value: true}},
+	}
+	buy := Spec{
+		Name: "buy",
+		Args: []Arg{{Name: "nick", Type: Nick}, {Name: "item", Rest: true}},
+	}
+	learn := Spec{Name: "!", Args: []Arg{{Name: "fact", Rest: true}}}
+	tests := []struct {
+		name      string
+		spec      Spec
+		raw       string
+		want      map[string]string
+		wantFlags map[string]string
+		wantErr   bool
+	}{
+		{
+			name:      "positional and flags",
+			spec:      spec,
+			raw:       `"foo bar"  --all adam --since 2d`,
+			want:      map[string]string{"regex": "foo bar", "nick": "adam"},
+			wantFlags: map[string]string{"all": "", "since": "2d"},
+		},
+		{
+			name:      "flag with equals",
+			spec:      spec,
+			raw:       `foo --since=3d`,
+			want:      map[string]string{"regex": "foo"},
+			wantFlags: map[string]string{"since": "3d"},
+		},
+		{
+			name:    "missing argument",
+			spec:    spec,
+			raw:     "--all",
+			wantErr: true,
+		},
+		{
+			name:    "unknown flag",
+			spec:    spec,
+			raw:     "foo --none",
+			wantErr: true,
+		},
+		{
+			name:    "invalid nick",
+			spec:    spec,
+			raw:     "foo 1adam",
+			wantErr: true,
+		},
+		{
+			name:    "too many",
+			spec:    spec,
+			raw:     "foo adam bar",
+			wantErr: true,
+		},
+		{
+			name:      "rest keeps spacing and flags",
+			spec:      buy,
+			raw:       "adam  a  cold --beer",
+			want:      map[string]string{"nick": "adam", "item": "a  cold --beer"},
+			wantFlags: map[string]string{},
+		},
+		{
+			name:      "unterminated quote in rest",
+			spec:      buy,
+			raw:       `adam a "cold beer`,
+			want:      map[string]string{"nick": "adam", "item": `a "cold beer`},
+			wantFlags: map[string]string{},
+		},
+		{
+			name:      "rest starting with an unterminated quote",
+			spec:      buy,
+			raw:       `adam "cold beer `,
+			want:      map[string]string{"nick": "adam", "item": `"cold beer`},
+			wantFlags: map[string]string{},
+		},
+		{
+			name:      "only argument is rest, with an unterminated quote",
+			spec:      learn,
+			raw:       `foo is "bar`,
+			want:      map[string]string{"fact": `foo is "bar`},
+			wantFlags: map[string]string{},
+		},
+		{
+			name:      "only argument is rest, starting with an unterminated quote",
+			spec:      learn,
+			raw:       `"quoted`,
+			want:      map[string]string{"fact": `"quoted`},
+			wantFlags: map[string]string{},
+		},
+		{
+			name:    "unterminated quote before rest",
+			spec:    buy,
+			raw:     `"adam`,
+			wantErr: true,
+		},
+		{
+			name:    "unterminated quote in a flag value",
+			spec:    spec,
+			raw:     `foo --since "2d`,
+			wantErr: true,
+		},
+		{
+			name:    "unterminated quote in last argument",
+			spec:    spec,
+			raw:     `foo "adam`,
+			wantErr: true,
+		},
+		{
+			name:      "single quoted rest is unquoted",
+			spec:      buy,
+			raw:       `adam "a beer"`,
+			want:      map[string]string{"nick": "adam", "item": "a beer"},
+			wantFlags: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.spec.ParseArgs(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseArgs() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				var uerr *UsageError
+				if !errors.As(err, &uerr) {
+					t.Errorf("ParseArgs() error is %T, want *UsageError", err)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got.values, tt.want) {
+				t.Errorf("ParseArgs() values = %v, want %v", got.values, tt.want)
+			}
+			if !reflect.DeepEqual(got.flags, tt.wantFlags) {
+				t.Errorf("ParseArgs() flags = %v, want %v", got.flags, tt.wantFlags)
+			}
+		})
+	}
+}
+
+func TestSpec_Synopsis(t *testing.T) {
+	want := "!grep <regex> [nick] [--all] [--since=<since>]"
+	spec := Spec{
+		Name:  "grep",
+		Args:  []Arg{{Name: "regex"}, {Name: "nick", Optional: true}},
+		Flags: []Flag{{Name: "all"}, {Name: "since", Value: true}},
+	}
+	if got := spec.Synopsis("!"); got != want {
+		t.Errorf("Synopsis() = %q, want %q", got, want)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	specs, aliases, triggers = nil, nil, nil
+	MustRegister(Spec{Name: "addressed", Args: []Arg{{Name: "text"}}, Triggers: []string{`^bender[:,] (.+)$`}})
+	spec, args, ok := Match("bender: what is foo?")
+	if !ok || spec.Name != "addressed" {
+		t.Fatalf("Match() = %v, %v, want addressed", spec.Name, ok)
+	}
+	if got := args.Get("text"); got != "what is foo?" {
+		t.Errorf("Match() text = %q", got)
+	}
+	if _, _, ok := Match("hello bender"); ok {
+		t.Error("Match() matched unexpectedly")
+	}
+	if err := Register(Spec{Name: "broken", Triggers: []string{"("}}); err == nil {
+		t.Error("Register() accepted an invalid trigger")
+	}
+}
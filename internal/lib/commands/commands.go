@@ -0,0 +1,202 @@
+// Package commands keeps the catalog of commands the bot knows, built-in as well as from plugins, with their aliases,
+// usage and help texts.
+package commands
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Spec describes a command
+type Spec struct {
+	// Name is the name the command is invoked by, without the command char
+	Name string `yaml:"-"`
+	// Aliases are alternative names for the command
+	Aliases []string `yaml:"aliases"`
+	// Usage describes the arguments, e.g. "<nick> <item>". If empty, it's made from Args and Flags.
+	Usage string `yaml:"usage"`
+	// Args are the positional arguments of the command. If there are neither Args nor Flags, arguments are not
+	// validated.
+	Args []Arg `yaml:"args"`
+	// Flags are the flags the command accepts
+	Flags []Flag `yaml:"flags"`
+	// Triggers are regular expressions that run the command when a message matches, without the command char. The
+	// submatches become the arguments.
+	Triggers []string `yaml:"triggers"`
+	// Description is a short, one line description of what the command does
+	Description string `yaml:"description"`
+	// Role is the role a user must have to run the command. Empty means anyone can.
+	Role string `yaml:"role"`
+	// Group is the group of commands this belongs to, e.g. "factoids", or the plugin name for plugin commands
+	Group string `yaml:"-"`
+}
+
+var (
+	m sync.RWMutex
+	// specs holds all registered commands by name
+	specs map[string]Spec
+	// aliases maps aliases to command names
+	aliases map[string]string
+	// triggers holds the compiled triggers of each command
+	triggers map[string][]*regexp.Regexp
+)
+
+// Register adds a command to the catalog. It's an error if the name or any alias is already taken.
+func Register(s Spec) error {
+	m.Lock()
+	defer m.Unlock()
+	if specs == nil {
+		specs = make(map[string]Spec)
+		aliases = make(map[string]string)
+		triggers = make(map[string][]*regexp.Regexp)
+	}
+	var res []*regexp.Regexp
+	for _, t := range s.Triggers {
+		re, err := regexp.Compile(t)
+		if err != nil {
+			return fmt.Errorf("invalid trigger for %q: %w", s.Name, err)
+		}
+		res = append(res, re)
+	}
+	for _, name := range append([]string{s.Name}, s.Aliases...) {
+		if _, ok := specs[name]; ok {
+			return fmt.Errorf("command name clash: %q is already defined", name)
+		}
+		if _, ok := aliases[name]; ok {
+			return fmt.Errorf("command name clash: %q is already an alias", name)
+		}
+	}
+	specs[s.Name] = s
+	for _, a := range s.Aliases {
+		aliases[a] = s.Name
+	}
+	if len(res) > 0 {
+		triggers[s.Name] = res
+	}
+	return nil
+}
+
+// MustRegister is like Register, but panics on error. Use it for built-in commands.
+func MustRegister(specs ...Spec) {
+	for _, s := range specs {
+		if err := Register(s); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Resolve returns the command name for `name`, which is either a command or an alias. Unknown names are returned as is.
+func Resolve(name string) string {
+	m.RLock()
+	defer m.RUnlock()
+	if n, ok := aliases[name]; ok {
+		return n
+	}
+	return name
+}
+
+// Lookup returns the spec for the command or alias `name`
+func Lookup(name string) (Spec, bool) {
+	name = Resolve(name)
+	m.RLock()
+	defer m.RUnlock()
+	s, ok := specs[name]
+	return s, ok
+}
+
+// Match returns the command that has a trigger matching msg, and the arguments from the match. Commands are tried in
+// order of their names.
+func Match(msg string) (Spec, Args, bool) {
+	m.RLock()
+	names := make([]string, 0, len(triggers))
+	for name := range triggers {
+		names = append(names, name)
+	}
+	m.RUnlock()
+	sort.Strings(names)
+	for _, name := range names {
+		m.RLock()
+		spec, res := specs[name], triggers[name]
+		m.RUnlock()
+		for _, re := range res {
+			if match := re.FindStringSubmatch(msg); match != nil {
+				return spec, spec.MatchArgs(re, match), true
+			}
+		}
+	}
+	return Spec{}, Args{}, false
+}
+
+// All returns all registered commands, sorted by name
+func All() []Spec {
+	m.RLock()
+	rv := make([]Spec, 0, len(specs))
+	for _, s := range specs {
+		rv = append(rv, s)
+	}
+	m.RUnlock()
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Name < rv[j].Name })
+	return rv
+}
+
+// Names returns the names the command can be enabled or disabled by: its own name, and its group if it has one
+func (s Spec) Names() []string {
+	if s.Group == "" {
+		return []string{s.Name}
+	}
+	return []string{s.Name, s.Group}
+}
+
+// Synopsis returns the command and its usage, prefixed by `prefix`, e.g. "!buy <nick> <item>"
+func (s Spec) Synopsis(prefix string) string {
+	usage := s.Usage
+	if usage == "" {
+		usage = s.usage()
+	}
+	if usage == "" {
+		return prefix + s.Name
+	}
+	return prefix + s.Name + " " + usage
+}
+
+// Help returns a one line help text for the command, using `prefix` as the command char
+func (s Spec) Help(prefix string) string {
+	help := s.Synopsis(prefix)
+	if s.Description != "" {
+		help += ": " + s.Description
+	}
+	if len(s.Aliases) > 0 {
+		help += fmt.Sprintf(" (aliases: %s)", strings.Join(s.Aliases, ", "))
+	}
+	if s.Role != "" {
+		help += fmt.Sprintf(" [requires %s]", s.Role)
+	}
+	return help
+}
+
+// Markdown writes the command catalog to w as a Markdown document, using `prefix` as the command char
+func Markdown(w io.Writer, prefix string) error {
+	var b strings.Builder
+	b.WriteString("# Commands\n\n")
+	b.WriteString("| Command | Aliases | Description | Role | Group |\n")
+	b.WriteString("|---|---|---|---|---|\n")
+	for _, s := range All() {
+		aliases := make([]string, len(s.Aliases))
+		for i, a := range s.Aliases {
+			aliases[i] = "`" + prefix + a + "`"
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s |\n", s.Synopsis(prefix), strings.Join(aliases, ", "),
+			mdEscape(s.Description), s.Role, s.Group)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// mdEscape escapes characters that would break a Markdown table cell
+func mdEscape(s string) string {
+	return strings.NewReplacer("|", `\|`, "<", "&lt;", ">", "&gt;", "\n", " ").Replace(s)
+}
@@ -0,0 +1,53 @@
+package commands
+
+import "testing"
+
+func TestRegister(t *testing.T) {
+	specs, aliases, triggers = nil, nil, nil
+	if err := Register(Spec{Name: "buy", Aliases: []string{"b"}, Usage: "<nick> <item>", Description: "Buy stuff"}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		spec    Spec
+		wantErr bool
+	}{
+		{
+			name: "new command",
+			spec: Spec{Name: "coffee"},
+		},
+		{
+			name:    "name clash",
+			spec:    Spec{Name: "buy"},
+			wantErr: true,
+		},
+		{
+			name:    "name clashes with alias",
+			spec:    Spec{Name: "b"},
+			wantErr: true,
+		},
+		{
+			name:    "alias clashes with name",
+			spec:    Spec{Name: "purchase", Aliases: []string{"buy"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Register(tt.spec); (err != nil) != tt.wantErr {
+				t.Errorf("Register() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	if got := Resolve("b"); got != "buy" {
+		t.Errorf("Resolve() = %q, want %q", got, "buy")
+	}
+	spec, ok := Lookup("b")
+	if !ok {
+		t.Fatal("Lookup() found nothing")
+	}
+	want := "!buy <nick> <item>: Buy stuff (aliases: b)"
+	if got := spec.Help("!"); got != want {
+		t.Errorf("Help() = %q, want %q", got, want)
+	}
+}
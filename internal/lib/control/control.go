@@ -0,0 +1,231 @@
+// Package control implements the bot's control API: line-delimited JSON requests and responses on a UNIX domain
+// socket, used by benderctl to run the bot without being on IRC. The core and plugins register commands with Handle.
+package control
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adamhassel/bender/internal/config"
+)
+
+// maxRequest is the longest request line the server reads
+const maxRequest = 64 * 1024
+
+// Request is a command and its arguments, e.g. {"command": "say", "args": ["IRCNet", "#chan", "hi"]}
+type Request struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// Response is the result of a request, or why it failed
+type Response struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// HandlerFunc runs a command. The result is sent to the client as JSON.
+type HandlerFunc func(ctx context.Context, args []string) (any, error)
+
+// Command is a registered command
+type Command struct {
+	Name        string `json:"name"`
+	Usage       string `json:"usage,omitempty"`
+	Description string `json:"description"`
+	handler     HandlerFunc
+}
+
+var (
+	hm       sync.RWMutex
+	handlers = make(map[string]Command)
+)
+
+// Handle registers f as the handler of the command `name`. usage describes the arguments, like "<network> <#chan>".
+func Handle(name, usage, description string, f HandlerFunc) {
+	hm.Lock()
+	defer hm.Unlock()
+	if _, ok := handlers[name]; ok {
+		log.Warnf("control command %q registered twice", name)
+	}
+	handlers[name] = Command{Name: name, Usage: usage, Description: description, handler: f}
+}
+
+// Commands returns all registered commands, sorted by name
+func Commands() []Command {
+	hm.RLock()
+	defer hm.RUnlock()
+	rv := make([]Command, 0, len(handlers))
+	for _, c := range handlers {
+		rv = append(rv, c)
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Name < rv[j].Name })
+	return rv
+}
+
+// UsageError is returned by handlers when they're given the wrong arguments
+type UsageError struct {
+	Command Command
+}
+
+func (e UsageError) Error() string {
+	return fmt.Sprintf("usage: %s %s", e.Command.Name, e.Command.Usage)
+}
+
+// Usage returns a UsageError for the command `name`
+func Usage(name string) error {
+	hm.RLock()
+	defer hm.RUnlock()
+	return UsageError{handlers[name]}
+}
+
+// run runs req, and returns its response
+func run(ctx context.Context, req Request) Response {
+	hm.RLock()
+	c, ok := handlers[req.Command]
+	hm.RUnlock()
+	if !ok {
+		return Response{Error: fmt.Sprintf("unknown command %q, try \"help\"", req.Command)}
+	}
+	result, err := c.handler(ctx, req.Args)
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return Response{Error: fmt.Sprintf("error encoding result: %s", err)}
+	}
+	return Response{Result: raw}
+}
+
+// serveConn answers requests on conn, one per line, until the client hangs up
+func serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxRequest)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req Request
+		resp := Response{Error: "invalid request"}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err == nil {
+			log.Infof("control: %s %q", req.Command, req.Args)
+			resp = run(ctx, req)
+		}
+		if err := enc.Encode(resp); err != nil {
+			log.Warnf("error answering control request: %s", err)
+			return
+		}
+	}
+}
+
+// socket is a listener on a UNIX socket that was moved into place. Closing it removes the socket.
+type socket struct {
+	net.Listener
+	path string
+}
+
+func (s socket) Close() error {
+	os.Remove(s.path)
+	return s.Listener.Close()
+}
+
+// listen listens on the UNIX socket at path, replacing a socket left behind by a bot that didn't shut down cleanly.
+// Only the bot's user may connect. The socket is created in a directory no one else can get into, and only moved to
+// path when its mode is set, so there's no window where others can connect.
+func listen(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%q exists, and isn't a socket", path)
+		}
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%q is in use, is another bot running?", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".ctl")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return socket{l, path}, nil
+}
+
+// Serve answers requests on the socket configured in ctx until ctx is done. It does nothing if no socket is
+// configured.
+func Serve(ctx context.Context) error {
+	path := config.FromContext(ctx).Main.Control
+	if path == "" {
+		return nil
+	}
+	l, err := listen(path)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	log.Infof("control socket listening on %s", path)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go serveConn(ctx, conn)
+	}
+}
+
+// Call sends req to the bot listening on the socket at path, and returns the result
+func Call(path string, req Request) (json.RawMessage, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.Result, nil
+}
+
+func init() {
+	Handle("help", "", "List the commands", func(ctx context.Context, args []string) (any, error) {
+		return Commands(), nil
+	})
+}
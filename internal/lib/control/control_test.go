@@ -0,0 +1,89 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/bender/internal/config"
+)
+
+func TestServe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bender.sock")
+	// a socket left behind by a bot that crashed
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	Handle("echo", "<words>", "Repeat the words", func(ctx context.Context, args []string) (any, error) {
+		if len(args) == 0 {
+			return nil, Usage("echo")
+		}
+		return args, nil
+	})
+	var c config.Config
+	c.Main.Control = path
+	ctx, cancel := context.WithCancel(c.Context(context.Background()))
+	done := make(chan error)
+	go func() { done <- Serve(ctx) }()
+
+	var result json.RawMessage
+	for i := 0; ; i++ {
+		if result, err = Call(path, Request{Command: "echo", Args: []string{"a", "b"}}); err == nil || i == 50 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != `["a","b"]` {
+		t.Errorf("echo = %s", result)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %s", fi.Mode())
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("left %d files next to the socket", len(entries)-1)
+	}
+
+	if _, err := Call(path, Request{Command: "echo"}); err == nil || err.Error() != "usage: echo <words>" {
+		t.Errorf("echo without words: err = %v", err)
+	}
+	if _, err := Call(path, Request{Command: "nope"}); err == nil {
+		t.Error("unknown command succeeded")
+	}
+	if _, err := listen(path); err == nil {
+		t.Error("listened on a socket in use")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Serve: %s", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket left behind: %v", err)
+	}
+}
+
+func TestListen_notSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bender.sock")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen(path); err == nil {
+		t.Error("replaced a regular file")
+	}
+}
@@ -0,0 +1,347 @@
+package irc
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	irc "github.com/thoj/go-ircevent"
+
+	"github.com/adamhassel/bender/internal/config"
+	"github.com/adamhassel/bender/internal/helpers"
+	"github.com/adamhassel/bender/internal/lib/commands"
+)
+
+const beatstatsFile = "db/beatstats.json"
+
+// beatmeDefaults are the settings of the original game
+var beatmeDefaults = config.Beatme{
+	Days:      []string{"friday"},
+	Timezones: []string{"America/Los_Angeles", "Europe/Copenhagen"},
+	Messages:  []string{"Det har du sikkert fortjent"},
+	WrongDay:  []string{"Det er ikke fredag, tåbe."},
+}
+
+// game is the beatme settings of a channel
+type game struct {
+	days      map[time.Weekday]bool
+	locations []*time.Location
+	messages  []string
+	wrongDay  []string
+	cooldown  time.Duration
+	protected []string
+	dryRun    bool
+}
+
+// newGame makes a game from the settings in b, with defaults for what isn't set
+func newGame(b config.Beatme) (game, error) {
+	if len(b.Days) == 0 {
+		b.Days = beatmeDefaults.Days
+	}
+	if len(b.Timezones) == 0 {
+		b.Timezones = beatmeDefaults.Timezones
+	}
+	if len(b.Messages) == 0 {
+		b.Messages = beatmeDefaults.Messages
+	}
+	if len(b.WrongDay) == 0 {
+		b.WrongDay = beatmeDefaults.WrongDay
+	}
+	g := game{days: make(map[time.Weekday]bool), messages: b.Messages, wrongDay: b.WrongDay, protected: b.Protected,
+		dryRun: b.DryRun}
+	for _, day := range b.Days {
+		wd, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return game{}, fmt.Errorf("unknown day %q in beatme settings", day)
+		}
+		g.days[wd] = true
+	}
+	for _, zone := range b.Timezones {
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return game{}, fmt.Errorf("invalid time zone in beatme settings: %w", err)
+		}
+		g.locations = append(g.locations, loc)
+	}
+	if b.Cooldown != "" {
+		d, err := time.ParseDuration(b.Cooldown)
+		if err != nil {
+			return game{}, fmt.Errorf("invalid cooldown in beatme settings: %w", err)
+		}
+		g.cooldown = d
+	}
+	return g, nil
+}
+
+// isDay returns true if it's one of the game days at `now` in any of the game's time zones
+func (g game) isDay(now time.Time) bool {
+	for _, loc := range g.locations {
+		if g.days[now.In(loc).Weekday()] {
+			return true
+		}
+	}
+	return false
+}
+
+// protects returns true if u must never be kicked. Protected entries with a "!" or "@" are matched against the
+// hostmask, others against the nick.
+func (g game) protects(u User) bool {
+	for _, p := range g.protected {
+		if strings.ContainsAny(p, "!@") {
+			if helpers.MatchMask(p, u.Hostmask()) {
+				return true
+			}
+		} else if helpers.MatchMask(p, u.Nick) {
+			return true
+		}
+	}
+	return false
+}
+
+// pick returns a random one of messages
+func pick(messages []string) string {
+	return messages[rand.Intn(len(messages))]
+}
+
+// beatStats is the hall of fame of a channel
+type beatStats struct {
+	// Kicks counts kicks by who played, and then who got kicked
+	Kicks map[string]map[string]int `json:"kicks"`
+	// WrongDay counts players kicked for playing on the wrong day
+	WrongDay map[string]int `json:"wrongday"`
+}
+
+var (
+	bm sync.Mutex
+	// beatstats holds the hall of fame of each channel, by userKey(network, channel). It's loaded when first needed.
+	beatstats map[string]*beatStats
+	// lastGame is when beatme was last played in each channel
+	lastGame = make(map[string]time.Time)
+)
+
+func init() {
+	handle(commands.Spec{Name: "beatme", Args: []commands.Arg{{Name: "message", Optional: true, Rest: true}},
+		Description: "On game days (fridays), have me kick a random channel member. Any other day, you get kicked"},
+		cmdBeatme)
+	handle(commands.Spec{Name: "beatstats", Args: []commands.Arg{{Name: "nick", Optional: true}},
+		Description: "Show the beatme hall of fame, or how someone did"}, cmdBeatstats)
+}
+
+// channelStats returns the stats for channel on network. The caller must hold bm.
+func channelStats(network, channel string) *beatStats {
+	if beatstats == nil {
+		beatstats = make(map[string]*beatStats)
+		if err := loadJSON(beatstatsFile, &beatstats); err != nil {
+			log.Errorf("error loading beatme stats: %s", err)
+		}
+	}
+	k := userKey(network, channel)
+	s, ok := beatstats[k]
+	if !ok {
+		s = &beatStats{}
+		beatstats[k] = s
+	}
+	if s.Kicks == nil {
+		s.Kicks = make(map[string]map[string]int)
+	}
+	if s.WrongDay == nil {
+		s.WrongDay = make(map[string]int)
+	}
+	return s
+}
+
+// recordKick adds a kick of victim by player to the stats of channel. If victim is empty, player was kicked for
+// playing on the wrong day.
+func recordKick(network, channel, player, victim string) {
+	bm.Lock()
+	defer bm.Unlock()
+	s := channelStats(network, channel)
+	player, victim = strings.ToLower(player), strings.ToLower(victim)
+	if victim == "" {
+		s.WrongDay[player]++
+	} else {
+		if s.Kicks[player] == nil {
+			s.Kicks[player] = make(map[string]int)
+		}
+		s.Kicks[player][victim]++
+	}
+	if err := saveJSON(beatstatsFile, beatstats); err != nil {
+		log.Errorf("error saving beatme stats: %s", err)
+	}
+}
+
+// startGame records that a game is played in the channel with key k at now, which starts the cooldown. If the last
+// game was less than cooldown ago, nothing is recorded, and it returns how long is left until the next one.
+func startGame(k string, cooldown time.Duration, now time.Time) time.Duration {
+	bm.Lock()
+	defer bm.Unlock()
+	if left := lastGame[k].Add(cooldown).Sub(now); left > 0 {
+		return left
+	}
+	lastGame[k] = now
+	return 0
+}
+
+func cmdBeatme(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	channel := e.Arguments[0]
+	if !isChannel(channel) {
+		SendReply(c, replyTarget(c, e), "beatme is played in channels", false)
+		return
+	}
+	_, sconf := config.ServerFromContext(ctx)
+	g, err := newGame(sconf.BeatmeFor(channel))
+	if err != nil {
+		log.Error(err)
+		SendReply(c, channel, "beatme is misconfigured here, sorry", false)
+		return
+	}
+	network := Network(c)
+	now := time.Now()
+	members, ok := ChannelMembers(c, channel)
+	if !ok {
+		SendReply(c, channel, "I don't know who's here yet, try again in a bit", false)
+		return
+	}
+
+	// Can we kick anyone?
+	if me, _ := ChannelMember(c, channel, c.GetNick()); !g.dryRun && !me.Op {
+		SendReply(c, channel, "I am not a channel operator", false)
+		return
+	}
+	kick := func(nick, message string) {
+		if g.dryRun {
+			SendReply(c, channel, fmt.Sprintf("I would have kicked %s if I were mean, while yelling %q", nick, message),
+				false)
+			return
+		}
+		c.Kick(nick, channel, message)
+	}
+
+	// If it ain't a game day, kick whoever beatme'd
+	if !g.isDay(now) {
+		player, ok := ChannelMember(c, channel, e.Nick)
+		if !ok {
+			player.User = User{Nick: e.Nick, Ident: e.User, Host: e.Host}
+		}
+		if g.protects(player.User) {
+			SendReply(c, channel, fmt.Sprintf("%s: it's not the day for it, and you know it", e.Nick), false)
+			return
+		}
+		kick(e.Nick, pick(g.wrongDay))
+		if !g.dryRun {
+			recordKick(network, channel, e.Nick, "")
+		}
+		return
+	}
+
+	// Let's not kick ourselves, or anyone protected
+	var victims []string
+	for _, m := range members {
+		if !strings.EqualFold(m.Nick, c.GetNick()) && !g.protects(m.User) {
+			victims = append(victims, m.Nick)
+		}
+	}
+	if len(victims) == 0 {
+		SendReply(c, channel, "There's no one here I can kick", false)
+		return
+	}
+	victim := victims[rand.Intn(len(victims))]
+	message := args.Get("message")
+	if message == "" {
+		message = pick(g.messages)
+	}
+	// the cooldown starts when someone's kicked, not when asking on the wrong day, or with no one to kick
+	if left := startGame(userKey(network, channel), g.cooldown, now); left > 0 {
+		SendReply(c, channel, fmt.Sprintf("%s: not so fast. Try again in %s", e.Nick, left.Round(time.Second)), false)
+		return
+	}
+	kick(victim, message)
+	if !g.dryRun {
+		recordKick(network, channel, e.Nick, victim)
+	}
+}
+
+// count is a nick and how many times something happened to them
+type count struct {
+	nick string
+	n    int
+}
+
+// top returns the n highest counts in m, highest first
+func top(m map[string]int, n int) []count {
+	counts := make([]count, 0, len(m))
+	for nick, c := range m {
+		counts = append(counts, count{nick, c})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].n != counts[j].n {
+			return counts[i].n > counts[j].n
+		}
+		return counts[i].nick < counts[j].nick
+	})
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// formatCounts formats counts like "alice (3), bob (1)"
+func formatCounts(counts []count) string {
+	parts := make([]string, len(counts))
+	for i, c := range counts {
+		parts[i] = fmt.Sprintf("%s (%d)", c.nick, c.n)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// hallOfFame describes the stats in s, or how nick did if it isn't empty
+func (s *beatStats) hallOfFame(nick string) string {
+	kickers, victims := make(map[string]int), make(map[string]int)
+	for player, kicks := range s.Kicks {
+		for victim, n := range kicks {
+			kickers[player] += n
+			victims[victim] += n
+		}
+	}
+	if nick != "" {
+		nick = strings.ToLower(nick)
+		if kickers[nick] == 0 && victims[nick] == 0 && s.WrongDay[nick] == 0 {
+			return fmt.Sprintf("%s hasn't played or been kicked here", nick)
+		}
+		msg := fmt.Sprintf("%s has kicked %d", nick, kickers[nick])
+		if fav := top(s.Kicks[nick], 1); len(fav) > 0 {
+			msg += fmt.Sprintf(" (mostly %s, %d times)", fav[0].nick, fav[0].n)
+		}
+		return msg + fmt.Sprintf(", been kicked %d times, and played on the wrong day %d times", victims[nick],
+			s.WrongDay[nick])
+	}
+	if len(kickers) == 0 && len(s.WrongDay) == 0 {
+		return "No one has played beatme here yet"
+	}
+	var parts []string
+	if len(kickers) > 0 {
+		parts = append(parts, "top kickers: "+formatCounts(top(kickers, 3)),
+			"most kicked: "+formatCounts(top(victims, 3)))
+	}
+	if len(s.WrongDay) > 0 {
+		parts = append(parts, "wrong day: "+formatCounts(top(s.WrongDay, 3)))
+	}
+	return "Hall of fame: " + strings.Join(parts, " | ")
+}
+
+func cmdBeatstats(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	channel := e.Arguments[0]
+	if !isChannel(channel) {
+		SendReply(c, replyTarget(c, e), "Ask in the channel", false)
+		return
+	}
+	bm.Lock()
+	msg := channelStats(Network(c), channel).hallOfFame(args.Get("nick"))
+	bm.Unlock()
+	SendReply(c, channel, msg, false)
+}
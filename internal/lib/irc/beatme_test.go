@@ -0,0 +1,105 @@
+package irc
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/bender/internal/config"
+)
+
+func Test_newGame(t *testing.T) {
+	g, err := newGame(config.Beatme{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// friday evening in Los Angeles is saturday in Copenhagen
+	if !g.isDay(time.Date(2024, 5, 18, 3, 0, 0, 0, time.UTC)) {
+		t.Error("default game isn't on friday in Los Angeles")
+	}
+	if g.isDay(time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC)) {
+		t.Error("default game is on wednesday")
+	}
+	if g.dryRun || g.cooldown != 0 || pick(g.wrongDay) != "Det er ikke fredag, tåbe." {
+		t.Errorf("default game = %+v", g)
+	}
+
+	g, err = newGame(config.Beatme{Days: []string{"Monday", "tirsdag"}, Timezones: []string{"UTC"},
+		Cooldown: "10m", Protected: []string{"chanserv", "admin*", "*!*@staff.example.com"}, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.isDay(time.Date(2024, 5, 14, 12, 0, 0, 0, time.UTC)) || g.isDay(time.Date(2024, 5, 17, 12, 0, 0, 0, time.UTC)) {
+		t.Error("days aren't monday and tuesday")
+	}
+	if g.cooldown != 10*time.Minute || !g.dryRun {
+		t.Errorf("game = %+v", g)
+	}
+	if !g.protects(User{Nick: "ChanServ"}) || !g.protects(User{Nick: "admin42"}) || g.protects(User{Nick: "bob"}) {
+		t.Error("protected nicks are wrong")
+	}
+	if !g.protects(User{Nick: "bob", Ident: "bob", Host: "staff.example.com"}) ||
+		g.protects(User{Nick: "bob", Ident: "staff.example.com", Host: "example.com"}) {
+		t.Error("protected hostmasks are wrong")
+	}
+
+	for _, bad := range []config.Beatme{{Days: []string{"someday"}}, {Timezones: []string{"Nowhere/Special"}},
+		{Cooldown: "a while"}} {
+		if _, err := newGame(bad); err == nil {
+			t.Errorf("newGame(%+v) succeeded", bad)
+		}
+	}
+}
+
+func Test_startGame(t *testing.T) {
+	delete(lastGame, "net/#cool")
+	now := time.Now()
+	if left := startGame("net/#cool", time.Minute, now); left != 0 {
+		t.Errorf("first game has to wait %s", left)
+	}
+	if left := startGame("net/#cool", time.Minute, now.Add(20*time.Second)); left != 40*time.Second {
+		t.Errorf("second game has to wait %s, want 40s", left)
+	}
+	// a game that had to wait doesn't restart the cooldown
+	if left := startGame("net/#cool", time.Minute, now.Add(time.Minute)); left != 0 {
+		t.Errorf("game after the cooldown has to wait %s", left)
+	}
+
+	// of games started at the same time, only one is played
+	delete(lastGame, "net/#race")
+	var wg sync.WaitGroup
+	var started atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if startGame("net/#race", time.Minute, now) == 0 {
+				started.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if started.Load() != 1 {
+		t.Errorf("%d games started at once, want 1", started.Load())
+	}
+}
+
+func Test_hallOfFame(t *testing.T) {
+	s := &beatStats{
+		Kicks:    map[string]map[string]int{"alice": {"bob": 3, "carol": 1}, "bob": {"alice": 1}},
+		WrongDay: map[string]int{"carol": 2},
+	}
+	if got, want := s.hallOfFame(""), "Hall of fame: top kickers: alice (4), bob (1) | most kicked: bob (3), alice (1), carol (1) | wrong day: carol (2)"; got != want {
+		t.Errorf("hallOfFame() = %q, want %q", got, want)
+	}
+	if got, want := s.hallOfFame("Alice"), "alice has kicked 4 (mostly bob, 3 times), been kicked 1 times, and played on the wrong day 0 times"; got != want {
+		t.Errorf("hallOfFame(alice) = %q, want %q", got, want)
+	}
+	if got := s.hallOfFame("dave"); got != "dave hasn't played or been kicked here" {
+		t.Errorf("hallOfFame(dave) = %q", got)
+	}
+	if got := (&beatStats{}).hallOfFame(""); got != "No one has played beatme here yet" {
+		t.Errorf("empty hallOfFame() = %q", got)
+	}
+}
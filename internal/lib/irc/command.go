@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/adamhassel/bender/internal/config"
+	"github.com/adamhassel/bender/internal/lib/commands"
 )
 
 type Command struct {
@@ -15,13 +16,135 @@ type Command struct {
 
 var ErrNotCommand = errors.New("not a command")
 
+type ctxirc int
+
+const (
+	nickkey ctxirc = iota
+	channelkey
+)
+
+// withNick returns a new context from ctx with the current nick of the bot attached
+func withNick(ctx context.Context, nick string) context.Context {
+	return context.WithValue(ctx, nickkey, nick)
+}
+
+// nickFromContext returns the nick attached with withNick, or an empty string
+func nickFromContext(ctx context.Context) string {
+	nick, _ := ctx.Value(nickkey).(string)
+	return nick
+}
+
+// withChannel returns a new context from ctx with the channel a message was sent to attached
+func withChannel(ctx context.Context, channel string) context.Context {
+	return context.WithValue(ctx, channelkey, channel)
+}
+
+// channelFromContext returns the channel attached with withChannel, or an empty string
+func channelFromContext(ctx context.Context) string {
+	channel, _ := ctx.Value(channelkey).(string)
+	return channel
+}
+
+// commandChars returns the command prefixes for the server and channel in ctx
+func commandChars(ctx context.Context) []string {
+	server, _ := config.ServerFromContext(ctx)
+	return config.FromContext(ctx).CommandChars(server, channelFromContext(ctx))
+}
+
+// commandChar returns the preferred command prefix for the server and channel in ctx, for use in help texts
+func commandChar(ctx context.Context) string {
+	return commandChars(ctx)[0]
+}
+
+// ParseCommand parses msg into a command, if it starts with a command prefix or addresses the bot by its nick
+// ("Bender: coffee"). Addressing the bot also understands factoids in plain language: "Bender: foo is bar",
+// "Bender, what is foo" and "Bender: foo?". Private messages to the bot are always addressed to it, but factoids are
+// only learnt from them with the nick or a command, so chatting with the bot doesn't teach it anything.
 func ParseCommand(ctx context.Context, msg string) (cmd Command, err error) {
-	c := config.FromContext(ctx)
-	if !strings.HasPrefix(msg, c.Main.CommandChar) {
+	prefixes := commandChars(ctx)
+	if rest, ok := trimPrefix(prefixes, msg); ok {
+		return parseCommand(rest), nil
+	}
+	nick := nickFromContext(ctx)
+	text, ok := addressed(nick, msg)
+	learn := ok
+	if !ok && nick != "" && strings.EqualFold(channelFromContext(ctx), nick) {
+		text, ok = strings.TrimSpace(msg), msg != ""
+	}
+	if !ok {
 		err = ErrNotCommand
 		return
 	}
-	cmd.Command, cmd.Argument = splitBySpace(strings.TrimPrefix(msg, c.Main.CommandChar))
+	if rest, ok := trimPrefix(prefixes, text); ok {
+		return parseCommand(rest), nil
+	}
+	return parseAddressed(text, learn)
+}
+
+// trimPrefix removes the longest of `prefixes` that msg starts with. It returns false if msg has none of them.
+func trimPrefix(prefixes []string, msg string) (string, bool) {
+	longest := -1
+	for _, p := range prefixes {
+		if strings.HasPrefix(msg, p) && len(p) > longest {
+			longest = len(p)
+		}
+	}
+	if longest < 0 {
+		return "", false
+	}
+	return msg[longest:], true
+}
+
+// parseCommand parses what follows the command prefix. The factoid shortcuts, "!" and "?", need no space after them.
+func parseCommand(rest string) (cmd Command) {
+	if len(rest) > 0 && (rest[0] == '!' || rest[0] == '?') {
+		return Command{Command: rest[:1], Argument: strings.TrimSpace(rest[1:])}
+	}
+	cmd.Command, cmd.Argument = splitBySpace(rest)
+	return
+}
+
+// addressed returns the rest of msg, if it starts with "nick:" or "nick,"
+func addressed(nick, msg string) (string, bool) {
+	if nick == "" || len(msg) <= len(nick) || !strings.EqualFold(msg[:len(nick)], nick) {
+		return "", false
+	}
+	if msg[len(nick)] != ':' && msg[len(nick)] != ',' {
+		return "", false
+	}
+	text := strings.TrimSpace(msg[len(nick)+1:])
+	return text, text != ""
+}
+
+// questions are the ways of asking for a factoid in plain language
+var questions = []string{"what is ", "what's ", "who is ", "hvad er ", "hvem er "}
+
+// parseAddressed parses what was said to the bot after its nick, or in private. Questions are looked up, with or
+// without a question mark. Statements like "foo is bar" are learnt if `learn` is true.
+func parseAddressed(text string, learn bool) (cmd Command, err error) {
+	key := strings.TrimSpace(strings.TrimRight(text, "?"))
+	question := strings.HasSuffix(text, "?")
+	for _, q := range questions {
+		if len(key) > len(q) && strings.EqualFold(key[:len(q)], q) {
+			key, question = strings.TrimSpace(key[len(q):]), true
+			break
+		}
+	}
+	if question {
+		if key == "" {
+			err = ErrNotCommand
+			return
+		}
+		return Command{Command: "?", Argument: key}, nil
+	}
+	command, argument := splitBySpace(text)
+	if _, ok := commands.Lookup(command); ok {
+		return Command{Command: command, Argument: argument}, nil
+	}
+	if learn && (strings.Contains(text, " is ") || strings.Contains(text, " er ")) {
+		return Command{Command: "!", Argument: text}, nil
+	}
+	err = ErrNotCommand
 	return
 }
 
@@ -19,6 +19,8 @@ func TestParseCommand(t *testing.T) {
 		},
 	}
 	ctx := conf.Context(context.Background())
+	nickctx := withNick(ctx, "Bender")
+	privctx := withChannel(nickctx, "bender")
 	tests := []struct {
 		name    string
 		args    args
@@ -85,6 +87,198 @@ func TestParseCommand(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "addressed command",
+			args: args{
+				ctx: nickctx,
+				msg: "Bender: buy adam a beer",
+			},
+			wantCmd: Command{
+				Command:  "buy",
+				Argument: "adam a beer",
+			},
+			wantErr: false,
+		},
+		{
+			name: "addressed with command char",
+			args: args{
+				ctx: nickctx,
+				msg: "bender, !coffee",
+			},
+			wantCmd: Command{
+				Command:  "coffee",
+				Argument: "",
+			},
+			wantErr: false,
+		},
+		{
+			name: "addressed question",
+			args: args{
+				ctx: nickctx,
+				msg: "Bender, what is foo bar?",
+			},
+			wantCmd: Command{
+				Command:  "?",
+				Argument: "foo bar",
+			},
+			wantErr: false,
+		},
+		{
+			name: "addressed short question",
+			args: args{
+				ctx: nickctx,
+				msg: "Bender: foo?",
+			},
+			wantCmd: Command{
+				Command:  "?",
+				Argument: "foo",
+			},
+			wantErr: false,
+		},
+		{
+			name: "addressed statement",
+			args: args{
+				ctx: nickctx,
+				msg: "Bender: foo is bar",
+			},
+			wantCmd: Command{
+				Command:  "!",
+				Argument: "foo is bar",
+			},
+			wantErr: false,
+		},
+		{
+			name: "addressed chatter",
+			args: args{
+				ctx: nickctx,
+				msg: "Bender: hello there",
+			},
+			wantCmd: Command{
+				Command:  "",
+				Argument: "",
+			},
+			wantErr: true,
+		},
+		{
+			name: "private message",
+			args: args{
+				ctx: privctx,
+				msg: "more",
+			},
+			wantCmd: Command{
+				Command:  "more",
+				Argument: "",
+			},
+			wantErr: false,
+		},
+		{
+			name: "private question",
+			args: args{
+				ctx: privctx,
+				msg: "foo?",
+			},
+			wantCmd: Command{
+				Command:  "?",
+				Argument: "foo",
+			},
+			wantErr: false,
+		},
+		{
+			name: "addressed question without a question mark",
+			args: args{
+				ctx: nickctx,
+				msg: "Bender: what is foo",
+			},
+			wantCmd: Command{
+				Command:  "?",
+				Argument: "foo",
+			},
+			wantErr: false,
+		},
+		{
+			name: "addressed question in Danish",
+			args: args{
+				ctx: nickctx,
+				msg: "Bender, hvad er foo?",
+			},
+			wantCmd: Command{
+				Command:  "?",
+				Argument: "foo",
+			},
+			wantErr: false,
+		},
+		{
+			name: "private question without a question mark",
+			args: args{
+				ctx: privctx,
+				msg: "who is foo",
+			},
+			wantCmd: Command{
+				Command:  "?",
+				Argument: "foo",
+			},
+			wantErr: false,
+		},
+		{
+			name: "private chatter isn't learnt",
+			args: args{
+				ctx: privctx,
+				msg: "can you tell me what the time is now",
+			},
+			wantCmd: Command{
+				Command:  "",
+				Argument: "",
+			},
+			wantErr: true,
+		},
+		{
+			name: "private statement with the nick is learnt",
+			args: args{
+				ctx: privctx,
+				msg: "Bender: foo is bar",
+			},
+			wantCmd: Command{
+				Command:  "!",
+				Argument: "foo is bar",
+			},
+			wantErr: false,
+		},
+		{
+			name: "private statement with a command is learnt",
+			args: args{
+				ctx: privctx,
+				msg: "learn foo is bar",
+			},
+			wantCmd: Command{
+				Command:  "learn",
+				Argument: "foo is bar",
+			},
+			wantErr: false,
+		},
+		{
+			name: "nick in the middle",
+			args: args{
+				ctx: nickctx,
+				msg: "hey Bender: coffee",
+			},
+			wantCmd: Command{
+				Command:  "",
+				Argument: "",
+			},
+			wantErr: true,
+		},
+		{
+			name: "nick prefix of a longer word",
+			args: args{
+				ctx: nickctx,
+				msg: "Benders: coffee",
+			},
+			wantCmd: Command{
+				Command:  "",
+				Argument: "",
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -99,3 +293,96 @@ func TestParseCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestParseCommandPrefixes(t *testing.T) {
+	conf := config.Config{
+		Main: config.Main{
+			CommandChar:  "!",
+			CommandChars: []string{"!", "bender!", "~"},
+		},
+		Servers: map[string]config.ServerOpts{
+			"irc.example.com": {
+				CommandChars: []string{"."},
+				ChannelOpts: map[string]config.ChannelOpts{
+					"#shared": {CommandChars: []string{"@@"}},
+				},
+			},
+		},
+	}
+	ctx := conf.Context(context.Background())
+	server := config.WithServer(ctx, "irc.example.com")
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		msg     string
+		wantCmd Command
+		wantErr bool
+	}{
+		{
+			name:    "first prefix",
+			ctx:     ctx,
+			msg:     "!coffee",
+			wantCmd: Command{Command: "coffee"},
+		},
+		{
+			name:    "longest prefix wins",
+			ctx:     ctx,
+			msg:     "bender!coffee",
+			wantCmd: Command{Command: "coffee"},
+		},
+		{
+			name:    "other prefix",
+			ctx:     ctx,
+			msg:     "~buy adam beer",
+			wantCmd: Command{Command: "buy", Argument: "adam beer"},
+		},
+		{
+			name:    "store shortcut with other prefix",
+			ctx:     ctx,
+			msg:     "~! foo is bar",
+			wantCmd: Command{Command: "!", Argument: "foo is bar"},
+		},
+		{
+			name:    "lookup shortcut without space",
+			ctx:     ctx,
+			msg:     "~?foo",
+			wantCmd: Command{Command: "?", Argument: "foo"},
+		},
+		{
+			name:    "server override",
+			ctx:     withChannel(server, "#other"),
+			msg:     ".coffee",
+			wantCmd: Command{Command: "coffee"},
+		},
+		{
+			name:    "global prefix not used when server overrides",
+			ctx:     withChannel(server, "#other"),
+			msg:     "!coffee",
+			wantErr: true,
+		},
+		{
+			name:    "channel override",
+			ctx:     withChannel(server, "#Shared"),
+			msg:     "@@? foo",
+			wantCmd: Command{Command: "?", Argument: "foo"},
+		},
+		{
+			name:    "server prefix not used when channel overrides",
+			ctx:     withChannel(server, "#shared"),
+			msg:     ".coffee",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotCmd, err := ParseCommand(tt.ctx, tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCommand() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(gotCmd, tt.wantCmd) {
+				t.Errorf("ParseCommand() gotCmd = %v, want %v", gotCmd, tt.wantCmd)
+			}
+		})
+	}
+}
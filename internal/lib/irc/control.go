@@ -0,0 +1,103 @@
+package irc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	irc "github.com/thoj/go-ircevent"
+
+	"github.com/adamhassel/bender/internal/factoids"
+	"github.com/adamhassel/bender/internal/lib/control"
+	"github.com/adamhassel/bender/internal/lib/plugins"
+)
+
+// controlConnection returns the connection to network for a control command, or an error if there isn't one
+func controlConnection(network string) (*irc.Connection, error) {
+	c := connectionFor(network)
+	if c == nil {
+		return nil, fmt.Errorf("not connected to %q", network)
+	}
+	return c, nil
+}
+
+// onNetwork returns a control handler that runs f on the connection to the network given as the first argument. f
+// gets the rest of the arguments, and there must be at least min of them.
+func onNetwork(name string, min int, f func(c *irc.Connection, args []string) error) control.HandlerFunc {
+	return func(ctx context.Context, args []string) (any, error) {
+		if len(args) < min+1 {
+			return nil, control.Usage(name)
+		}
+		c, err := controlConnection(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return nil, f(c, args[1:])
+	}
+}
+
+// factoidOrigin is who factoids set with the control API are by
+const factoidOrigin = "benderctl"
+
+// controlFactoid gets the facts for a keyword, or adds one
+func controlFactoid(ctx context.Context, args []string) (any, error) {
+	switch {
+	case len(args) == 2 && args[0] == "get":
+		return factoids.Facts(strings.ToLower(args[1]))
+	case len(args) > 2 && args[0] == "set":
+		return factoids.Store(args[1]+" is "+strings.Join(args[2:], " "), factoidOrigin), nil
+	}
+	return nil, control.Usage("factoid")
+}
+
+func init() {
+	control.Handle("say", "<network> <#channel|nick> <message>", "Say something",
+		onNetwork("say", 2, func(c *irc.Connection, args []string) error {
+			SendReply(c, args[0], strings.Join(args[1:], " "), false)
+			return nil
+		}))
+	control.Handle("join", "<network> <#channel> [key]", "Join a channel",
+		onNetwork("join", 1, func(c *irc.Connection, args []string) error {
+			if !isChannel(args[0]) {
+				return fmt.Errorf("%q isn't a channel", args[0])
+			}
+			c.Join(strings.Join(args, " "))
+			return nil
+		}))
+	control.Handle("part", "<network> <#channel> [reason]", "Leave a channel",
+		onNetwork("part", 1, func(c *irc.Connection, args []string) error {
+			if !isChannel(args[0]) {
+				return fmt.Errorf("%q isn't a channel", args[0])
+			}
+			if len(args) > 1 {
+				c.SendRawf("PART %s :%s", args[0], strings.Join(args[1:], " "))
+				return nil
+			}
+			c.Part(args[0])
+			return nil
+		}))
+	control.Handle("nick", "<network> <nick>", "Change nick",
+		onNetwork("nick", 1, func(c *irc.Connection, args []string) error {
+			c.Nick(args[0])
+			return nil
+		}))
+	control.Handle("raw", "<network> <line>", "Send a raw IRC line",
+		onNetwork("raw", 1, func(c *irc.Connection, args []string) error {
+			c.SendRaw(strings.Join(args, " "))
+			return nil
+		}))
+	control.Handle("status", "", "Show the uptime, servers and plugins",
+		func(ctx context.Context, args []string) (any, error) {
+			return struct {
+				Uptime  string         `json:"uptime"`
+				Servers []ServerStatus `json:"servers"`
+				Plugins []string       `json:"plugins"`
+			}{Uptime().Round(time.Second).String(), Status(), plugins.Loaded()}, nil
+		})
+	control.Handle("plugins", "", "List the loaded plugins", func(ctx context.Context, args []string) (any, error) {
+		return plugins.Loaded(), nil
+	})
+	control.Handle("factoid", "get <keyword> | set <keyword> <fact>", "Show the facts for a keyword, or add one",
+		controlFactoid)
+}
@@ -5,132 +5,234 @@ import (
 	"errors"
 	"fmt"
 	"strings"
-	"time"
 
 	log "github.com/sirupsen/logrus"
 	irc "github.com/thoj/go-ircevent"
 
+	"github.com/adamhassel/bender/internal/config"
 	"github.com/adamhassel/bender/internal/factoids"
-	"github.com/adamhassel/bender/internal/helpers"
+	"github.com/adamhassel/bender/internal/lib/commands"
 	"github.com/adamhassel/bender/internal/lib/plugins"
 )
 
+// factoidGroup is the group of the built-in commands that work on the factoid database. They can be enabled or
+// disabled together by this name.
+const factoidGroup = "factoids"
+
+// handler implements a built-in command
+type handler func(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args)
+
+// handlers holds the built-in commands by name
+var handlers = make(map[string]handler)
+
+// handle registers a built-in command
+func handle(spec commands.Spec, h handler) {
+	commands.MustRegister(spec)
+	handlers[spec.Name] = h
+}
+
+func init() {
+	handle(commands.Spec{Name: "help", Aliases: []string{"commands"},
+		Args:        []commands.Arg{{Name: "command", Optional: true}},
+		Description: "List commands, or show help for a command"}, cmdHelp)
+	handle(commands.Spec{Name: "!", Aliases: []string{"learn"}, Usage: "<key> is <value>",
+		Args:        []commands.Arg{{Name: "fact", Rest: true}},
+		Description: "Teach me a factoid", Group: factoidGroup}, cmdStore)
+	handle(commands.Spec{Name: "?", Aliases: []string{"whatis"},
+		Args:        []commands.Arg{{Name: "key", Rest: true}},
+		Description: "Look up a factoid", Group: factoidGroup}, cmdLookup)
+	handle(commands.Spec{Name: "random", Description: "Tell a random factoid", Group: factoidGroup}, cmdRandom)
+	handle(commands.Spec{Name: "finfo", Description: "Show who created the last factoid I told, and when",
+		Group: factoidGroup}, cmdFinfo)
+	handle(commands.Spec{Name: "list", Args: []commands.Arg{{Name: "start"}},
+		Description: "List factoid keys starting with <start>", Group: factoidGroup}, cmdList)
+	handle(commands.Spec{Name: "search", Args: []commands.Arg{{Name: "regex", Rest: true}},
+		Description: "Search factoid values for a regular expression", Group: factoidGroup}, cmdSearch)
+	handle(commands.Spec{Name: "coffee", Description: "Get a cup of coffee"}, cmdCoffee)
+	handle(commands.Spec{Name: "buy",
+		Args:        []commands.Arg{{Name: "nick", Type: commands.Nick}, {Name: "item", Rest: true}},
+		Description: "Buy someone something from the bar"}, cmdBuy)
+}
+
 // HandleMessages is the function that intercepts channel (or private) messages and handles them
 func HandleMessages(ctx context.Context, c *irc.Connection, e *irc.Event) {
 	msg := e.Message()
 	channel := e.Arguments[0]
+	_, sconf := config.ServerFromContext(ctx)
 
 	factoidconf, err := factoids.ParseConfFile(factoids.DefaultConfFile)
 	if err != nil {
 		log.Error(err)
 	}
 	ctx = factoidconf.Context(ctx)
+	ctx = withNick(ctx, c.GetNick())
+	ctx = withChannel(ctx, channel)
 
-	// TODO: this structure is ugly
 	command, err := ParseCommand(ctx, msg)
-	if err != nil {
-		if errors.Is(err, ErrNotCommand) {
-			replies, err := plugins.Matchers(msg, e)
-			if err != nil {
-				log.Error(err)
-				return
-			}
-			for _, r := range replies {
-				SendReply(c, channel, r.Message, r.Action)
+	if errors.Is(err, ErrNotCommand) {
+		if spec, args, ok := commands.Match(msg); ok {
+			if allowed(ctx, c, e, spec) {
+				run(ctx, c, e, spec, args)
 			}
-		}
-		return
-	}
-
-	switch command.Command {
-	case "!":
-		reply := factoids.Store(command.Argument, e.Nick)
-		c.Privmsg(channel, reply)
-	case "?":
-		reply, action := factoids.Lookup(ctx, e.Nick, command.Argument)
-		SendReply(c, channel, reply, action)
-	case "random":
-		reply, action := factoids.Lookup(ctx, e.Nick, factoids.RandomKey())
-		SendReply(c, channel, reply, action)
-	case "finfo":
-		SendReply(c, channel, factoids.Lastfact().Info(), false)
-	case "list":
-		if command.Argument == "" {
-			SendReply(c, channel, "You gotta tell me what to look for, bub", false)
-			return
-		}
-		results, err := factoids.List(command.Argument)
-		if err != nil {
-			SendReply(c, channel, err.Error(), false)
 			return
 		}
-		SendReply(c, channel, results, false)
-	case "search":
-		if command.Argument == "" {
-			SendReply(c, channel, "You gotta tell me what to look for, bub", false)
-			return
-		}
-		results, err := factoids.Search(command.Argument, 5)
+		replies, err := plugins.Matchers(msg, e, func(plugin string) bool {
+			return sconf.Enabled(channel, plugin)
+		})
 		if err != nil {
-			SendReply(c, channel, err.Error(), false)
+			log.Error(err)
 			return
 		}
-		for _, s := range results {
-			SendReply(c, channel, s, false)
-			time.Sleep(200 * time.Millisecond)
+		for _, r := range replies {
+			SendReply(c, replyTarget(c, e), r.Message, r.Action)
 		}
-	case "coffee":
-		reply := fmt.Sprintf("pours %s a cup of hot coffee, straight from the pot", e.Nick)
-		SendReply(c, channel, reply, true)
-	case "buy": // this is the most used !bar feature from old bender, so it's implemented on its own.
-		nick, item := splitBySpace(command.Argument)
-		reply := fmt.Sprintf("gives %s a %s, \"Compliments of %s!\"", nick, item, e.Nick)
-		SendReply(c, channel, reply, true)
-	case "beatme":
-		l, err := RequestReply(c, "353", "NAMES "+channel)
-		if err != nil {
-			SendReply(c, channel, fmt.Sprintf("Error getting user list: %s", err), false)
-			return
-		}
-		users := helpers.NewSet(strings.Split(strings.TrimSpace(l), " ")...)
+		return
+	}
 
-		// Can we kick anyone?
-		if !users.Exists("@" + c.GetNick()) {
-			SendReply(c, channel, "I am not a channel operator", false)
+	spec, ok := commands.Lookup(command.Command)
+	if !ok {
+		log.Debugf("unknown command %q", command.Command)
+		return
+	}
+	if !allowed(ctx, c, e, spec) {
+		return
+	}
+	args, err := spec.ParseArgs(command.Argument)
+	if err != nil {
+		var uerr *commands.UsageError
+		if errors.As(err, &uerr) {
+			SendReply(c, replyTarget(c, e), uerr.Message(commandChar(ctx)), false)
 			return
 		}
+		log.Error(err)
+		return
+	}
+	run(ctx, c, e, spec, args)
+}
 
-		// If it ain't friday in either CA or DK, kick whoever beatme'd.
-		CA, _ := time.LoadLocation("America/Los_Angeles")
-		DK, _ := time.LoadLocation("Europe/Copenhagen")
-		if time.Now().In(CA).Weekday() != time.Friday && time.Now().In(DK).Weekday() != time.Friday {
-			c.Kick(e.Nick, channel, "Det er ikke fredag, tåbe.")
-			return
-		}
+// allowed returns true if the command in spec is enabled where e was sent, the sender has the role it requires, and
+// isn't over a rate limit
+func allowed(ctx context.Context, c *irc.Connection, e *irc.Event, spec commands.Spec) bool {
+	channel := e.Arguments[0]
+	_, sconf := config.ServerFromContext(ctx)
+	if !sconf.Enabled(channel, spec.Names()...) {
+		log.Debugf("command %q is disabled in %s", spec.Name, channel)
+		return false
+	}
+	if !config.FromContext(ctx).HasRole(spec.Role, e.Source) {
+		SendReply(c, replyTarget(c, e), fmt.Sprintf("%s: you need to be %s to do that", e.Nick, spec.Role), false)
+		return false
+	}
+	return !rateLimited(ctx, c, e, spec)
+}
 
-		// Let's not kick ourselves or the channel. Also, we have a '@' now, because we're channel operator
-		users.Delete("@" + c.GetNick())
+// run runs a built-in or plugin command
+func run(ctx context.Context, c *irc.Connection, e *irc.Event, spec commands.Spec, args commands.Args) {
+	if h, ok := handlers[spec.Name]; ok {
+		h(ctx, c, e, args)
+		return
+	}
+	r, err := plugins.Execute(spec.Name, args, e)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if r.Message == "" {
+		return
+	}
+	SendReply(c, replyTarget(c, e), r.Message, r.Action)
+}
+
+// replyTarget returns where to reply to e: the channel it was sent to, or the sender if it was a private message
+func replyTarget(c *irc.Connection, e *irc.Event) string {
+	if strings.EqualFold(e.Arguments[0], c.GetNick()) {
+		return e.Nick
+	}
+	return e.Arguments[0]
+}
 
-		kickme := users.Random()
-		if command.Argument == "" {
-			command.Argument = "Det har du sikkert fortjent"
+// help returns the list of commands enabled in `channel`, or the help text for `command` if it's not empty
+func help(ctx context.Context, channel, command string) string {
+	_, sconf := config.ServerFromContext(ctx)
+	prefix := commandChar(ctx)
+	if command != "" {
+		if rest, ok := trimPrefix(commandChars(ctx), command); ok && rest != "" {
+			command = rest
 		}
-		//SendReply(c, channel, fmt.Sprintf("I would have kicked %s if I were mean, while yelling %q", kickme, command.Argument), false)
-		c.Kick(kickme, channel, command.Argument)
-	default: // Check plugins
-		r, err := plugins.Execute(command.Command, strings.Split(command.Argument, " "), e)
-		if err != nil {
-			log.Error(err)
-			return
+		spec, ok := commands.Lookup(command)
+		if !ok || !sconf.Enabled(channel, spec.Names()...) {
+			return fmt.Sprintf("I don't know the command %q", command)
+		}
+		return spec.Help(prefix)
+	}
+	var names []string
+	for _, spec := range commands.All() {
+		if sconf.Enabled(channel, spec.Names()...) {
+			names = append(names, prefix+spec.Name)
 		}
-		SendReply(c, channel, r.Message, r.Action)
 	}
+	return fmt.Sprintf("I know these commands: %s. Try %shelp <command> for more.", strings.Join(names, ", "), prefix)
+}
+
+func cmdHelp(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	SendReply(c, replyTarget(c, e), help(ctx, e.Arguments[0], args.Get("command")), false)
+}
+
+func cmdStore(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	SendReply(c, replyTarget(c, e), factoids.Store(args.Get("fact"), e.Nick), false)
+}
+
+func cmdLookup(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	reply, action := factoids.Lookup(ctx, e.Nick, args.Get("key"))
+	SendReply(c, replyTarget(c, e), reply, action)
+}
+
+func cmdRandom(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	reply, action := factoids.Lookup(ctx, e.Nick, factoids.RandomKey())
+	SendReply(c, replyTarget(c, e), reply, action)
+}
+
+func cmdFinfo(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	SendReply(c, replyTarget(c, e), factoids.Lastfact().Info(), false)
+}
+
+func cmdList(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	channel := replyTarget(c, e)
+	results, err := factoids.List(args.Get("start"))
+	if err != nil {
+		SendReply(c, channel, err.Error(), false)
+		return
+	}
+	SendReply(c, channel, results, false)
 }
 
+func cmdSearch(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	channel := replyTarget(c, e)
+	results, err := factoids.Search(args.Get("regex"), 50)
+	if err != nil {
+		SendReply(c, channel, err.Error(), false)
+		return
+	}
+	SendPaged(c, channel, e.Nick, results)
+}
+
+func cmdCoffee(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	reply := fmt.Sprintf("pours %s a cup of hot coffee, straight from the pot", e.Nick)
+	SendReply(c, replyTarget(c, e), reply, true)
+}
+
+// cmdBuy is the most used !bar feature from old bender, so it's implemented on its own.
+func cmdBuy(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	reply := fmt.Sprintf("gives %s a %s, \"Compliments of %s!\"", args.Get("nick"), args.Get("item"), e.Nick)
+	SendReply(c, replyTarget(c, e), reply, true)
+}
+
+// SendReply sends msg to ch, as an action if `action` is true, and tells outbound hooks about it
 func SendReply(c *irc.Connection, ch string, msg string, action bool) {
 	if action {
 		c.Action(ch, msg)
-		return
+	} else {
+		c.Privmsg(ch, msg)
 	}
-	c.Privmsg(ch, msg)
+	runOutboundHooks(Outbound{Conn: c, Target: ch, Message: msg, Action: action})
 }
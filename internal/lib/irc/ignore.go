@@ -0,0 +1,335 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	irc "github.com/thoj/go-ircevent"
+
+	"github.com/adamhassel/bender/internal/config"
+	"github.com/adamhassel/bender/internal/helpers"
+	"github.com/adamhassel/bender/internal/lib/commands"
+)
+
+const (
+	ignoresFile = "db/ignores.json"
+	// adminRole is the role that manages ignores, and is never ignored, so admins can't lock themselves out
+	adminRole = "admin"
+)
+
+// mask is a parsed ignore entry. Entries are nicks or nick!user@host masks with wildcards, "$a:account" for services
+// accounts (wildcards allowed), or "/regex/", which is matched against nick!user@host.
+type mask struct {
+	glob    string
+	account bool
+	re      *regexp.Regexp
+}
+
+// parseMask parses an ignore entry
+func parseMask(s string) (mask, error) {
+	switch {
+	case len(s) > 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/"):
+		re, err := regexp.Compile("(?i)" + s[1:len(s)-1])
+		if err != nil {
+			return mask{}, fmt.Errorf("invalid regular expression in %q: %w", s, err)
+		}
+		return mask{re: re}, nil
+	case strings.HasPrefix(strings.ToLower(s), "$a:"):
+		if len(s) == 3 {
+			return mask{}, fmt.Errorf("no account in %q", s)
+		}
+		return mask{glob: s[3:], account: true}, nil
+	case s == "" || strings.ContainsAny(s, " \t"):
+		return mask{}, fmt.Errorf("invalid ignore mask %q", s)
+	}
+	return mask{glob: s}, nil
+}
+
+// matches returns true if u matches m. Masks without "!" or "@" are matched against the nick.
+func (m mask) matches(u User) bool {
+	switch {
+	case m.re != nil:
+		return m.re.MatchString(u.Hostmask())
+	case m.account:
+		return u.Account != "" && helpers.MatchMask(m.glob, u.Account)
+	case strings.ContainsAny(m.glob, "!@"):
+		return helpers.MatchMask(m.glob, u.Hostmask())
+	}
+	return helpers.MatchMask(m.glob, u.Nick)
+}
+
+// ignoreEntry is an ignore added at runtime
+type ignoreEntry struct {
+	Network string `json:"network"`
+	// Channel is where the ignore applies. Empty means the whole network.
+	Channel string    `json:"channel,omitempty"`
+	Mask    string    `json:"mask"`
+	By      string    `json:"by"`
+	Added   time.Time `json:"added"`
+	// Expires is when the ignore is lifted. Zero means never.
+	Expires time.Time `json:"expires"`
+}
+
+// expired returns true if e has expired at now
+func (e ignoreEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// applies returns true if e applies to channel on network
+func (e ignoreEntry) applies(network, channel string) bool {
+	return strings.EqualFold(e.Network, network) && (e.Channel == "" || strings.EqualFold(e.Channel, channel))
+}
+
+var (
+	im sync.Mutex
+	// ignores are the ignores added at runtime. They're loaded when first needed.
+	ignores []ignoreEntry
+	// ignoresLoaded is true when ignores have been loaded
+	ignoresLoaded bool
+	// masks caches parsed masks, so regexes are only compiled once
+	masks = make(map[string]mask)
+)
+
+func init() {
+	handle(commands.Spec{Name: "ignore", Usage: "add <mask> [#channel] [duration] | del <mask> [#channel] | list",
+		Args: []commands.Arg{{Name: "action"}, {Name: "mask", Optional: true}, {Name: "rest", Optional: true, Rest: true}},
+		Description: "Manage who I ignore. Masks are nicks or nick!user@host with wildcards, $a:account or /regex/. " +
+			"Without a channel, the ignore is for the whole network",
+		Role: adminRole}, cmdIgnore)
+}
+
+// loadedIgnores returns the runtime ignores, without the expired ones. The caller must hold im.
+func loadedIgnores(now time.Time) []ignoreEntry {
+	if !ignoresLoaded {
+		if err := loadJSON(ignoresFile, &ignores); err != nil {
+			log.Errorf("error loading ignores: %s", err)
+		}
+		ignoresLoaded = true
+	}
+	live := ignores[:0]
+	for _, e := range ignores {
+		if !e.expired(now) {
+			live = append(live, e)
+		}
+	}
+	if len(live) != len(ignores) {
+		ignores = live
+		if err := saveJSON(ignoresFile, ignores); err != nil {
+			log.Errorf("error saving ignores: %s", err)
+		}
+	}
+	return ignores
+}
+
+// cachedMask returns the parsed mask s. The caller must hold im.
+func cachedMask(s string) (mask, error) {
+	if m, ok := masks[s]; ok {
+		return m, nil
+	}
+	m, err := parseMask(s)
+	if err != nil {
+		return mask{}, err
+	}
+	masks[s] = m
+	return m, nil
+}
+
+// ignoreMasks returns the ignore entries that apply in channel on network: the server's and the channel's from the
+// configuration, and the ones added at runtime
+func ignoreMasks(sconf config.ServerOpts, network, channel string, now time.Time) []string {
+	list := append([]string{}, sconf.Ignore...)
+	if co, ok := sconf.Channel(channel); ok {
+		list = append(list, co.Ignore...)
+	}
+	im.Lock()
+	defer im.Unlock()
+	for _, e := range loadedIgnores(now) {
+		if e.applies(network, channel) {
+			list = append(list, e.Mask)
+		}
+	}
+	return list
+}
+
+// sender returns what's known about who sent e
+func sender(c *irc.Connection, e *irc.Event) User {
+	u, ok := LookupUser(c, e.Nick)
+	if !ok {
+		u = User{Nick: e.Nick}
+	}
+	u.Nick, u.Ident, u.Host = e.Nick, e.User, e.Host
+	// account-tag tells on each message
+	if account, ok := e.Tags["account"]; ok {
+		u.Account = account
+	}
+	return u
+}
+
+// ignored returns true if the sender of e is ignored where e was sent
+func ignored(ctx context.Context, c *irc.Connection, e *irc.Event) bool {
+	if config.FromContext(ctx).HasRole(adminRole, e.Source) {
+		return false
+	}
+	_, sconf := config.ServerFromContext(ctx)
+	u := sender(c, e)
+	for _, s := range ignoreMasks(sconf, Network(c), eventChannel(e), time.Now()) {
+		im.Lock()
+		m, err := cachedMask(s)
+		im.Unlock()
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		if m.matches(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// addIgnore adds an ignore, or updates who added it and when it expires if it exists
+func addIgnore(entry ignoreEntry) error {
+	if _, err := parseMask(entry.Mask); err != nil {
+		return err
+	}
+	im.Lock()
+	defer im.Unlock()
+	loadedIgnores(entry.Added)
+	for i, e := range ignores {
+		if strings.EqualFold(e.Network, entry.Network) && strings.EqualFold(e.Channel, entry.Channel) &&
+			strings.EqualFold(e.Mask, entry.Mask) {
+			ignores[i] = entry
+			return saveJSON(ignoresFile, ignores)
+		}
+	}
+	ignores = append(ignores, entry)
+	return saveJSON(ignoresFile, ignores)
+}
+
+// errNoIgnore is returned when deleting an ignore that doesn't exist
+var errNoIgnore = errors.New("no such ignore")
+
+// delIgnore removes the ignore of mask in channel on network. An empty channel is the ignore for the whole network.
+func delIgnore(network, channel, mask string) error {
+	im.Lock()
+	defer im.Unlock()
+	for i, e := range loadedIgnores(time.Now()) {
+		if strings.EqualFold(e.Network, network) && strings.EqualFold(e.Channel, channel) &&
+			strings.EqualFold(e.Mask, mask) {
+			ignores = append(ignores[:i], ignores[i+1:]...)
+			return saveJSON(ignoresFile, ignores)
+		}
+	}
+	return errNoIgnore
+}
+
+// parseIgnoreArgs parses the optional channel and duration after the mask in "ignore add"
+func parseIgnoreArgs(words []string) (channel string, d time.Duration, err error) {
+	if len(words) > 0 && isChannel(words[0]) {
+		channel, words = words[0], words[1:]
+	}
+	if len(words) > 0 && strings.EqualFold(words[0], "for") {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return channel, 0, nil
+	}
+	d, rest, err := parseDuration(words)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(rest) > 0 {
+		return "", 0, fmt.Errorf("I don't understand %q", strings.Join(rest, " "))
+	}
+	return channel, d, nil
+}
+
+// describeIgnore describes e for "ignore list"
+func describeIgnore(e ignoreEntry, now time.Time) string {
+	where := "everywhere"
+	if e.Channel != "" {
+		where = "in " + e.Channel
+	}
+	until := "for good"
+	if !e.Expires.IsZero() {
+		until = "for another " + e.Expires.Sub(now).Round(time.Minute).String()
+	}
+	return fmt.Sprintf("%s %s, %s (added by %s)", e.Mask, where, until, e.By)
+}
+
+func cmdIgnore(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	target := replyTarget(c, e)
+	network := Network(c)
+	now := time.Now()
+	m := args.Get("mask")
+	words := strings.Fields(args.Get("rest"))
+	switch strings.ToLower(args.Get("action")) {
+	case "add":
+		if m == "" {
+			SendReply(c, target, fmt.Sprintf("%s: ignore whom?", e.Nick), false)
+			return
+		}
+		channel, d, err := parseIgnoreArgs(words)
+		if err != nil {
+			SendReply(c, target, fmt.Sprintf("%s: %s", e.Nick, err), false)
+			return
+		}
+		entry := ignoreEntry{Network: network, Channel: channel, Mask: m, By: e.Nick, Added: now}
+		if d > 0 {
+			entry.Expires = now.Add(d)
+		}
+		if err := addIgnore(entry); err != nil {
+			SendReply(c, target, fmt.Sprintf("%s: %s", e.Nick, err), false)
+			return
+		}
+		SendReply(c, target, fmt.Sprintf("%s: ignoring %s", e.Nick, describeIgnore(entry, now)), false)
+	case "del", "rm", "remove":
+		if m == "" {
+			SendReply(c, target, fmt.Sprintf("%s: stop ignoring whom?", e.Nick), false)
+			return
+		}
+		var channel string
+		if len(words) > 0 {
+			channel = words[0]
+		}
+		if err := delIgnore(network, channel, m); err != nil {
+			if errors.Is(err, errNoIgnore) {
+				SendReply(c, target, fmt.Sprintf("%s: I'm not ignoring %s there", e.Nick, m), false)
+				return
+			}
+			log.Errorf("error saving ignores: %s", err)
+		}
+		SendReply(c, target, fmt.Sprintf("%s: no longer ignoring %s", e.Nick, m), false)
+	case "list":
+		_, sconf := config.ServerFromContext(ctx)
+		var lines []string
+		for _, s := range sconf.Ignore {
+			lines = append(lines, s+" everywhere (configured)")
+		}
+		for channel, co := range sconf.ChannelOpts {
+			for _, s := range co.Ignore {
+				lines = append(lines, fmt.Sprintf("%s in %s (configured)", s, channel))
+			}
+		}
+		im.Lock()
+		for _, entry := range loadedIgnores(now) {
+			if strings.EqualFold(entry.Network, network) {
+				lines = append(lines, describeIgnore(entry, now))
+			}
+		}
+		im.Unlock()
+		if len(lines) == 0 {
+			SendReply(c, target, fmt.Sprintf("%s: I'm not ignoring anyone", e.Nick), false)
+			return
+		}
+		SendPaged(c, e.Nick, e.Nick, lines)
+	default:
+		SendReply(c, target, fmt.Sprintf("%s: I can add, del or list ignores", e.Nick), false)
+	}
+}
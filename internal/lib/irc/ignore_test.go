@@ -0,0 +1,120 @@
+package irc
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/bender/internal/config"
+)
+
+func Test_mask_matches(t *testing.T) {
+	alice := User{Nick: "Alice", Ident: "~alice", Host: "home.example.com", Account: "alice"}
+	guest := User{Nick: "Guest42", Ident: "webchat", Host: "gateway.example.org"}
+	tests := []struct {
+		mask         string
+		alice, guest bool
+	}{
+		{"alice", true, false},
+		{"guest*", false, true},
+		{"*!*@*.example.com", true, false},
+		{"*!webchat@*", false, true},
+		{"$a:alice", true, false},
+		{"$a:*", true, false},
+		{"/^guest\\d+!/", false, true},
+		{"/example\\.(com|org)$/", true, true},
+	}
+	for _, tt := range tests {
+		m, err := parseMask(tt.mask)
+		if err != nil {
+			t.Errorf("parseMask(%q): %s", tt.mask, err)
+			continue
+		}
+		if got := m.matches(alice); got != tt.alice {
+			t.Errorf("%q matches alice = %t", tt.mask, got)
+		}
+		if got := m.matches(guest); got != tt.guest {
+			t.Errorf("%q matches guest = %t", tt.mask, got)
+		}
+	}
+	for _, bad := range []string{"", "$a:", "/(/", "two words"} {
+		if _, err := parseMask(bad); err == nil {
+			t.Errorf("parseMask(%q) succeeded", bad)
+		}
+	}
+}
+
+func Test_parseIgnoreArgs(t *testing.T) {
+	tests := []struct {
+		args        []string
+		wantChannel string
+		wantD       time.Duration
+		wantErr     bool
+	}{
+		{nil, "", 0, false},
+		{[]string{"#chan"}, "#chan", 0, false},
+		{[]string{"#chan", "2h"}, "#chan", 2 * time.Hour, false},
+		{[]string{"for", "3", "days"}, "", 72 * time.Hour, false},
+		{[]string{"a", "while"}, "", 0, true},
+		{[]string{"1h", "please"}, "", 0, true},
+	}
+	for _, tt := range tests {
+		channel, d, err := parseIgnoreArgs(tt.args)
+		if (err != nil) != tt.wantErr || channel != tt.wantChannel || d != tt.wantD {
+			t.Errorf("parseIgnoreArgs(%q) = %q, %s, %v", tt.args, channel, d, err)
+		}
+	}
+}
+
+func Test_ignores(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	now := time.Now()
+	for _, e := range []ignoreEntry{
+		{Network: "net", Mask: "spammer", Added: now},
+		{Network: "net", Channel: "#quiet", Mask: "*!*@loud.example.com", Added: now},
+		{Network: "net", Mask: "brief", Added: now, Expires: now.Add(time.Minute)},
+		{Network: "other", Mask: "elsewhere", Added: now},
+	} {
+		if err := addIgnore(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := addIgnore(ignoreEntry{Network: "net", Mask: "/(/", Added: now}); err == nil {
+		t.Error("added an invalid mask")
+	}
+
+	sconf := config.ServerOpts{Ignore: []string{"oldbot"},
+		ChannelOpts: map[string]config.ChannelOpts{"#quiet": {Ignore: []string{"$a:troll"}}}}
+	if got, want := ignoreMasks(sconf, "net", "#quiet", now), []string{"oldbot", "$a:troll", "spammer",
+		"*!*@loud.example.com", "brief"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ignores in #quiet = %q, want %q", got, want)
+	}
+	if got, want := ignoreMasks(sconf, "net", "#other", now.Add(time.Hour)), []string{"oldbot",
+		"spammer"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ignores in #other an hour later = %q, want %q", got, want)
+	}
+
+	if err := delIgnore("net", "", "spammer"); err != nil {
+		t.Fatal(err)
+	}
+	if err := delIgnore("net", "", "spammer"); err != errNoIgnore {
+		t.Errorf("deleting twice: err = %v", err)
+	}
+
+	// forget what's in memory, and read it back
+	ignores, ignoresLoaded = nil, false
+	if got, want := ignoreMasks(sconf, "net", "#quiet", now.Add(time.Hour)), []string{"oldbot", "$a:troll",
+		"*!*@loud.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ignores after reloading = %q, want %q", got, want)
+	}
+	ignores, ignoresLoaded = nil, false
+}
@@ -3,22 +3,52 @@ package irc
 import (
 	"context"
 	"crypto/tls"
-	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/adamhassel/bender/internal/config"
+	"github.com/adamhassel/bender/internal/lib/plugins"
 
 	irc "github.com/thoj/go-ircevent"
 )
 
+// quitTimeout is how long to wait for servers to close the connections after QUIT when shutting down
+const quitTimeout = 5 * time.Second
+
+// reloaded is the configuration from the last Reload, if there's been one
+var reloaded atomic.Pointer[config.Config]
+
+// Reload replaces the configuration of the running bot. What's read when handling messages, like command chars,
+// allow and deny lists, ignores, roles and rate limits, takes effect right away. Servers, channels, identities,
+// announcements and plugins are set up when the bot starts, and need a restart.
+func Reload(c config.Config) {
+	reloaded.Store(&c)
+}
+
+// current returns ctx with the configuration from the last Reload, if there's been one
+func current(ctx context.Context) context.Context {
+	if c := reloaded.Load(); c != nil {
+		return c.Context(ctx)
+	}
+	return ctx
+}
+
+// InitBot connects to the configured servers, and runs the bot until ctx is done, or all connections have ended. When
+// ctx is done, it sends QUIT on all connections, and waits for the servers to close them.
 func InitBot(ctx context.Context) error {
 	conf := config.FromContext(ctx)
+	if err := loadReminders(); err != nil {
+		return fmt.Errorf("error loading reminders: %w", err)
+	}
 	var wg sync.WaitGroup
 	for server, sconf := range conf.Servers {
+		server, sconf := server, sconf
+		ctx := config.WithServer(ctx, server)
 		irccon := irc.IRC(sconf.Identity.Nick, sconf.Identity.Name)
 		irccon.Log.SetOutput(conf.Main.LogWriter)
 		irccon.VerboseCallbackHandler = conf.Main.LogLevel == "debug"
@@ -26,6 +56,16 @@ func InitBot(ctx context.Context) error {
 		irccon.UseTLS = sconf.SSL
 		irccon.Password = sconf.Password
 		irccon.TLSConfig = &tls.Config{InsecureSkipVerify: sconf.SkipInsecureVerify, ServerName: server}
+		irccon.QuitMessage = conf.Main.QuitMessage
+
+		network := sconf.Network
+		if network == "" {
+			network = server
+		}
+		track(irccon, server, network)
+		if err := scheduleAnnouncements(irccon, conf, sconf); err != nil {
+			return fmt.Errorf("error scheduling announcements for %q: %w", server, err)
+		}
 
 		// Join configured channels
 		irccon.AddCallback("001", func(e *irc.Event) {
@@ -36,8 +76,9 @@ func InitBot(ctx context.Context) error {
 
 		// Have the bot parse any messages in a channel to see if it should act
 		irccon.AddCallback("PRIVMSG", func(e *irc.Event) {
-			if stringInSlice(e.Nick, sconf.Ignore) {
-				irccon.Log.Printf("Ignoring %q", e.Nick)
+			ctx := current(ctx)
+			if ignored(ctx, irccon, e) {
+				irccon.Log.Printf("Ignoring %q", e.Source)
 				return
 			}
 			go HandleMessages(ctx, irccon, e)
@@ -45,13 +86,24 @@ func InitBot(ctx context.Context) error {
 
 		// For now, handle actions as regular messages
 		irccon.AddCallback("CTCP_ACTION", func(e *irc.Event) {
-			if stringInSlice(e.Nick, sconf.Ignore) {
-				irccon.Log.Printf("Ignoring %q", e.Nick)
+			ctx := current(ctx)
+			if ignored(ctx, irccon, e) {
+				irccon.Log.Printf("Ignoring %q", e.Source)
 				return
 			}
 			go HandleMessages(ctx, irccon, e)
 		})
 
+		// Pass anything else plugins have asked for on to them
+		for _, code := range plugins.EventCodes() {
+			irccon.AddCallback(code, func(e *irc.Event) {
+				_, sconf := config.ServerFromContext(current(ctx))
+				plugins.HandleEvent(e, func(plugin string) bool {
+					return sconf.Enabled(eventChannel(e), plugin)
+				})
+			})
+		}
+
 		err := irccon.Connect(conf.ServerPort(server))
 		if err != nil {
 			return fmt.Errorf("error connecting to IRC server %q: %w", server, err)
@@ -62,35 +114,47 @@ func InitBot(ctx context.Context) error {
 			wg.Done()
 		}(irccon)
 	}
-	wg.Wait()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		quitAll()
+		select {
+		case <-done:
+		case <-time.After(quitTimeout):
+			log.Warn("timed out waiting for servers to close the connections")
+		}
+	}
 	return nil
 }
 
-func stringInSlice(s string, sl []string) bool {
-	for _, v := range sl {
-		if s == v {
-			return true
+// quitAll sends QUIT on all connections. Messages already queued are sent first.
+func quitAll() {
+	nm.RLock()
+	defer nm.RUnlock()
+	for c, conn := range connections {
+		if !c.Connected() {
+			continue
 		}
+		log.Infof("quitting %s", conn.server)
+		c.Quit()
 	}
-	return false
 }
 
-// RequestReply abstracts sending a command to the IRC server and listening for a reply. Eventcodes must match the
-// commands. See https://www.alien.net.au/irc/irc2numerics.html
-func RequestReply(c *irc.Connection, eventcode, command string) (string, error) {
-	reply := make(chan string, 1)
-	id := c.AddCallback(eventcode, func(e *irc.Event) {
-		reply <- e.Message()
-		defer close(reply)
-	})
-	defer c.RemoveCallback(eventcode, id)
-	c.SendRaw(command)
-	var r string
-	select {
-	case r = <-reply:
-	case <-time.NewTicker(5 * time.Second).C:
-		return "", errors.New("timeout getting command response")
+// eventChannel returns the channel an event happened in, or an empty string for events that aren't tied to a channel,
+// like QUIT and NICK
+func eventChannel(e *irc.Event) string {
+	if len(e.Arguments) == 0 || !isChannel(e.Arguments[0]) {
+		return ""
 	}
-	log.Debugf("%+v", r)
-	return r, nil
+	return e.Arguments[0]
+}
+
+// isChannel returns true if name is a channel name
+func isChannel(name string) bool {
+	return name != "" && strings.ContainsRune("#&+!", rune(name[0]))
 }
@@ -0,0 +1,93 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/bender/internal/config"
+)
+
+func TestInitBot_shutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	registered, quit := make(chan struct{}), make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		lines := bufio.NewScanner(conn)
+		for lines.Scan() {
+			line := lines.Text()
+			switch {
+			case strings.HasPrefix(line, "USER "):
+				close(registered)
+			case strings.HasPrefix(line, "QUIT"):
+				quit <- line
+				io.WriteString(conn, "ERROR :Closing link\r\n")
+				return
+			}
+		}
+	}()
+
+	conf := config.Config{
+		Main: config.Main{LogWriter: io.Discard, QuitMessage: "see you"},
+		Servers: map[string]config.ServerOpts{"127.0.0.1": {Port: l.Addr().(*net.TCPAddr).Port,
+			Identity: config.Identity{Nick: "bender", Name: "Bender"}}},
+	}
+	ctx, cancel := context.WithCancel(conf.Context(context.Background()))
+	errs := make(chan error, 1)
+	go func() { errs <- InitBot(ctx) }()
+
+	select {
+	case <-registered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("bot never connected")
+	}
+	cancel()
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(quitTimeout + time.Second):
+		t.Fatal("InitBot didn't return after shutdown")
+	}
+	select {
+	case line := <-quit:
+		if line != "QUIT :see you" {
+			t.Errorf("quit with %q", line)
+		}
+	default:
+		t.Error("bot didn't send QUIT")
+	}
+}
+
+func TestReload(t *testing.T) {
+	var old, c config.Config
+	old.Main.QuitMessage = "old"
+	c.Main.QuitMessage = "new"
+	c.Servers = map[string]config.ServerOpts{"irc.example.com": {Network: "net"}}
+	ctx := config.WithServer(old.Context(context.Background()), "irc.example.com")
+	if got := config.FromContext(current(ctx)).Main.QuitMessage; got != "old" {
+		t.Errorf("before reloading, quit message = %q", got)
+	}
+	Reload(c)
+	defer reloaded.Store(nil)
+	ctx = current(ctx)
+	if got := config.FromContext(ctx).Main.QuitMessage; got != "new" {
+		t.Errorf("after reloading, quit message = %q", got)
+	}
+	if _, sconf := config.ServerFromContext(ctx); sconf.Network != "net" {
+		t.Errorf("after reloading, server options = %+v", sconf)
+	}
+}
@@ -0,0 +1,37 @@
+package irc
+
+import (
+	"sync"
+
+	irc "github.com/thoj/go-ircevent"
+)
+
+// Outbound is a message sent by the bot
+type Outbound struct {
+	Conn    *irc.Connection
+	Target  string
+	Message string
+	Action  bool
+}
+
+var (
+	hm            sync.RWMutex
+	outboundHooks []func(Outbound)
+)
+
+// OnOutbound registers f to be called with every message the bot sends with SendReply. Hooks are called synchronously,
+// after the message is queued for sending, so they shouldn't block.
+func OnOutbound(f func(Outbound)) {
+	hm.Lock()
+	defer hm.Unlock()
+	outboundHooks = append(outboundHooks, f)
+}
+
+// runOutboundHooks calls all registered outbound hooks with o
+func runOutboundHooks(o Outbound) {
+	hm.RLock()
+	defer hm.RUnlock()
+	for _, f := range outboundHooks {
+		f(o)
+	}
+}
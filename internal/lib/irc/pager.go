@@ -0,0 +1,77 @@
+package irc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	irc "github.com/thoj/go-ircevent"
+
+	"github.com/adamhassel/bender/internal/lib/commands"
+)
+
+// PageSize is the number of lines sent at a time by SendPaged
+const PageSize = 5
+
+// pageKey identifies the user waiting for more pages
+type pageKey struct {
+	c    *irc.Connection
+	nick string
+}
+
+// pending holds the lines not yet sent to a user, and where to send them
+type pending struct {
+	target string
+	lines  []string
+}
+
+var (
+	pm    sync.Mutex
+	pages = make(map[pageKey]pending)
+)
+
+func init() {
+	handle(commands.Spec{Name: "more", Description: "Show the next page of results"}, cmdMore)
+}
+
+// SendPaged sends the first page of `lines` to target, and keeps the rest for when `nick` asks for more. Any earlier
+// pages kept for `nick` are forgotten.
+func SendPaged(c *irc.Connection, target, nick string, lines []string) {
+	pm.Lock()
+	delete(pages, pageKey{c, nick})
+	pm.Unlock()
+	sendPage(c, nick, pending{target: target, lines: lines})
+}
+
+// sendPage sends a page from p to its target, and keeps the rest for `nick`
+func sendPage(c *irc.Connection, nick string, p pending) {
+	n := PageSize
+	if len(p.lines) < n {
+		n = len(p.lines)
+	}
+	for _, line := range p.lines[:n] {
+		SendReply(c, p.target, line, false)
+		time.Sleep(200 * time.Millisecond)
+	}
+	rest := p.lines[n:]
+	if len(rest) == 0 {
+		return
+	}
+	pm.Lock()
+	pages[pageKey{c, nick}] = pending{target: p.target, lines: rest}
+	pm.Unlock()
+	SendReply(c, p.target, fmt.Sprintf("... %d more. Say \"more\" to me for the next page", len(rest)), false)
+}
+
+func cmdMore(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	pm.Lock()
+	p, ok := pages[pageKey{c, e.Nick}]
+	delete(pages, pageKey{c, e.Nick})
+	pm.Unlock()
+	if !ok {
+		SendReply(c, replyTarget(c, e), "There's no more", false)
+		return
+	}
+	sendPage(c, e.Nick, p)
+}
@@ -0,0 +1,75 @@
+package irc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	irc "github.com/thoj/go-ircevent"
+
+	"github.com/adamhassel/bender/internal/config"
+	"github.com/adamhassel/bender/internal/lib/commands"
+	"github.com/adamhassel/bender/internal/lib/ratelimit"
+)
+
+// limiter holds the rate limit buckets of all users, channels and commands on all networks
+var limiter = ratelimit.New()
+
+// limit converts l to a ratelimit.Limit. Invalid limits are logged, and don't limit anything.
+func limit(l config.Limit) ratelimit.Limit {
+	if l.Burst <= 0 {
+		return ratelimit.Limit{}
+	}
+	per, err := time.ParseDuration(l.Per)
+	if err != nil || per <= 0 {
+		log.Warnf("invalid rate limit period %q", l.Per)
+		return ratelimit.Limit{}
+	}
+	return ratelimit.Limit{Burst: l.Burst, Per: per}
+}
+
+// rateChecks returns the rate limits for running spec in channel on network, by a user with user@host `userhost`
+func rateChecks(rl config.RateLimit, network, channel, userhost string, spec commands.Spec) []ratelimit.Check {
+	// users are known by user@host, so changing nick doesn't help
+	checks := []ratelimit.Check{{Key: userKey(network, "user "+userhost), Limit: limit(rl.User)}}
+	if isChannel(channel) {
+		checks = append(checks, ratelimit.Check{Key: userKey(network, channel), Limit: limit(rl.Channel)})
+	}
+	// a group's limit is shared by the commands in it
+	for _, name := range append(spec.Names(), spec.Aliases...) {
+		if l, ok := rl.Commands[name]; ok {
+			checks = append(checks, ratelimit.Check{Key: userKey(network, channel+" "+name), Limit: limit(l)})
+			break
+		}
+	}
+	return checks
+}
+
+// rateLimited returns true if the sender of e may not run spec now, because they, the channel or the command have
+// hit a rate limit. The first time, the sender is told in a notice. After that, they're ignored until the limit is
+// lifted.
+func rateLimited(ctx context.Context, c *irc.Connection, e *irc.Event, spec commands.Spec) bool {
+	_, sconf := config.ServerFromContext(ctx)
+	conf := config.FromContext(ctx)
+	for _, role := range sconf.RateLimit.Exempt {
+		if conf.HasRole(role, e.Source) {
+			return false
+		}
+	}
+	channel := e.Arguments[0]
+	if strings.EqualFold(channel, c.GetNick()) {
+		channel = e.Nick
+	}
+	checks := rateChecks(sconf.RateLimit, Network(c), channel, e.User+"@"+e.Host, spec)
+	r := limiter.Allow(time.Now(), checks...)
+	if r.OK {
+		return false
+	}
+	log.Debugf("rate limiting %s on %s", e.Source, spec.Name)
+	if r.Warn {
+		c.Notice(e.Nick, fmt.Sprintf("Slow down, please. Try again in %s", r.Wait.Round(time.Second)))
+	}
+	return true
+}
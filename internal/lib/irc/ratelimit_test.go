@@ -0,0 +1,40 @@
+package irc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/bender/internal/config"
+	"github.com/adamhassel/bender/internal/lib/commands"
+	"github.com/adamhassel/bender/internal/lib/ratelimit"
+)
+
+func Test_rateChecks(t *testing.T) {
+	rl := config.RateLimit{
+		User:     config.Limit{Burst: 5, Per: "1m"},
+		Channel:  config.Limit{Burst: 20, Per: "bad"},
+		Commands: map[string]config.Limit{"whatis": {Burst: 2, Per: "30s"}},
+	}
+	spec := commands.Spec{Name: "?", Aliases: []string{"whatis"}}
+	got := rateChecks(rl, "Net", "#Chan", "~alice@host", spec)
+	want := []ratelimit.Check{
+		{Key: "net/user ~alice@host", Limit: ratelimit.Limit{Burst: 5, Per: time.Minute}},
+		{Key: "net/#chan", Limit: ratelimit.Limit{}},
+		{Key: "net/#chan whatis", Limit: ratelimit.Limit{Burst: 2, Per: 30 * time.Second}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rateChecks() = %+v, want %+v", got, want)
+	}
+
+	// in private, there's no channel limit, and commands are limited per user
+	rl.Commands["factoids"] = config.Limit{Burst: 10, Per: "1h"}
+	got = rateChecks(rl, "net", "alice", "~alice@host", commands.Spec{Name: "random", Group: "factoids"})
+	want = []ratelimit.Check{
+		{Key: "net/user ~alice@host", Limit: ratelimit.Limit{Burst: 5, Per: time.Minute}},
+		{Key: "net/alice factoids", Limit: ratelimit.Limit{Burst: 10, Per: time.Hour}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rateChecks() in private = %+v, want %+v", got, want)
+	}
+}
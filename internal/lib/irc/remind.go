@@ -0,0 +1,568 @@
+package irc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	irc "github.com/thoj/go-ircevent"
+
+	"github.com/adamhassel/bender/internal/config"
+	"github.com/adamhassel/bender/internal/helpers"
+	"github.com/adamhassel/bender/internal/lib/commands"
+	"github.com/adamhassel/bender/internal/lib/scheduler"
+)
+
+const (
+	remindersFile = "db/reminders.json"
+	timezonesFile = "db/timezones.json"
+	// retryDelivery is how long to wait before trying again to deliver a reminder to a network the bot isn't
+	// connected to
+	retryDelivery = time.Minute
+)
+
+// reminder is a message to send at a given time
+type reminder struct {
+	ID      int    `json:"id"`
+	Network string `json:"network"`
+	// Target is the channel or nick to send the reminder to
+	Target string `json:"target"`
+	// Nick is who asked for the reminder
+	Nick    string    `json:"nick"`
+	Message string    `json:"message"`
+	At      time.Time `json:"at"`
+	// Self is true if Nick asked to be reminded, rather than a channel
+	Self bool `json:"self"`
+}
+
+// text returns what the bot says when r is due
+func (r reminder) text() string {
+	if r.Self {
+		return fmt.Sprintf("%s: you asked me to remind you: %s", r.Nick, r.Message)
+	}
+	return fmt.Sprintf("Reminder from %s: %s", r.Nick, r.Message)
+}
+
+var (
+	rm        sync.Mutex
+	reminders = make(map[int]reminder)
+	// reminderJobs holds the scheduled job of each reminder
+	reminderJobs = make(map[int]scheduler.Job)
+	lastReminder int
+
+	zm        sync.Mutex
+	timezones map[string]string
+)
+
+func init() {
+	handle(commands.Spec{Name: "remind", Usage: "<me|#channel> <in <duration>|at <time> [day]> <message>",
+		Args:        []commands.Arg{{Name: "who"}, {Name: "when", Rest: true}},
+		Description: "Remind you or a channel of something, e.g. \"remind me in 2h tea\" or \"remind #chan at 16:00 friday beer\""},
+		cmdRemind)
+	handle(commands.Spec{Name: "reminders", Description: "List your reminders"}, cmdReminders)
+	handle(commands.Spec{Name: "unremind", Args: []commands.Arg{{Name: "id", Type: commands.Int}},
+		Description: "Cancel one of your reminders"}, cmdUnremind)
+	handle(commands.Spec{Name: "timezone", Aliases: []string{"tz"},
+		Args:        []commands.Arg{{Name: "zone", Optional: true}},
+		Description: "Show or set your time zone, e.g. Europe/Copenhagen"}, cmdTimezone)
+}
+
+// loadJSON reads the JSON in filename into v. It's not an error if the file doesn't exist.
+func loadJSON(filename string, v interface{}) error {
+	raw, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("error parsing %s: %w", filename, err)
+	}
+	return nil
+}
+
+// saveJSON writes v as JSON to filename
+func saveJSON(filename string, v interface{}) error {
+	raw, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	return helpers.WriteFileAtomic(filename, raw, 0600)
+}
+
+// userKey identifies nick on network in per-user settings
+func userKey(network, nick string) string {
+	return strings.ToLower(network + "/" + nick)
+}
+
+// userLocation returns the time zone of nick on network: their own, or the global one
+func userLocation(ctx context.Context, network, nick string) *time.Location {
+	zm.Lock()
+	if timezones == nil {
+		timezones = make(map[string]string)
+		if err := loadJSON(timezonesFile, &timezones); err != nil {
+			log.Errorf("error loading time zones: %s", err)
+		}
+	}
+	zone := timezones[userKey(network, nick)]
+	zm.Unlock()
+	if zone != "" {
+		if loc, err := time.LoadLocation(zone); err == nil {
+			return loc
+		}
+	}
+	loc, err := config.FromContext(ctx).Location()
+	if err != nil {
+		log.Errorf("invalid time zone: %s", err)
+		return time.Local
+	}
+	return loc
+}
+
+// setUserLocation sets the time zone of nick on network
+func setUserLocation(ctx context.Context, network, nick string, loc *time.Location) error {
+	userLocation(ctx, network, nick) // load the time zones
+	zm.Lock()
+	defer zm.Unlock()
+	timezones[userKey(network, nick)] = loc.String()
+	return saveJSON(timezonesFile, timezones)
+}
+
+// durationUnits are the units understood in "in ..." reminders
+var durationUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+// parseDuration parses a duration at the start of words, like "2h30m", "1d" or "2 hours 30 minutes". It returns the
+// duration and the words after it.
+func parseDuration(words []string) (time.Duration, []string, error) {
+	var total time.Duration
+	i := 0
+	for i < len(words) {
+		w := strings.ToLower(words[i])
+		if w == "and" && total > 0 {
+			i++
+			continue
+		}
+		// "2 hours"
+		if n, err := strconv.Atoi(w); err == nil && i+1 < len(words) {
+			unit, ok := durationUnits[strings.ToLower(words[i+1])]
+			if !ok {
+				break
+			}
+			total += time.Duration(n) * unit
+			i += 2
+			continue
+		}
+		// "2h30m"
+		d, ok := compactDuration(w)
+		if !ok {
+			break
+		}
+		total += d
+		i++
+	}
+	if total <= 0 {
+		return 0, words, errors.New("I don't understand how long that is. Try e.g. 2h30m or \"3 days\"")
+	}
+	return total, words[i:], nil
+}
+
+var compactPart = regexp.MustCompile(`(\d+)([a-z]+)`)
+
+// compactDuration parses durations like "2h30m" or "1w2d"
+func compactDuration(s string) (time.Duration, bool) {
+	matches := compactPart.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return 0, false
+	}
+	var total time.Duration
+	end := 0
+	for _, m := range matches {
+		if m[0] != end {
+			return 0, false
+		}
+		end = m[1]
+		n, _ := strconv.Atoi(s[m[2]:m[3]])
+		unit, ok := durationUnits[s[m[4]:m[5]]]
+		if !ok {
+			return 0, false
+		}
+		total += time.Duration(n) * unit
+	}
+	return total, end == len(s)
+}
+
+var clockTime = regexp.MustCompile(`^(\d{1,2})(?:[:.](\d{2}))?(am|pm)?$`)
+
+// parseClock parses a time of day like "16:00", "16.30", "16", "4pm" or "4:30pm"
+func parseClock(s string) (hour, minute int, ok bool) {
+	m := clockTime.FindStringSubmatch(strings.ToLower(s))
+	if m == nil {
+		return 0, 0, false
+	}
+	hour, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+	switch m[3] {
+	case "am":
+		if hour == 12 {
+			hour = 0
+		}
+	case "pm":
+		if hour < 12 {
+			hour += 12
+		}
+	}
+	if m[3] != "" && (hour > 23 || m[1] == "0") {
+		return 0, 0, false
+	}
+	return hour, minute, hour < 24 && minute < 60
+}
+
+// weekdays maps the names of the days of the week to their number, in English and Danish
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday, "søndag": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday, "mandag": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tirsdag": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday, "onsdag": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "torsdag": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday, "fredag": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday, "lørdag": time.Saturday,
+}
+
+// parseAt parses a time of day at the start of words, optionally followed by a day: "today", "tomorrow", a day of the
+// week or a date (2006-01-02). It returns the next such time after now, in loc, and the words after it.
+func parseAt(words []string, now time.Time, loc *time.Location) (time.Time, []string, error) {
+	if len(words) == 0 {
+		return time.Time{}, words, errors.New("at what time?")
+	}
+	hour, minute, ok := parseClock(words[0])
+	if !ok {
+		return time.Time{}, words, fmt.Errorf("I don't understand the time %q. Try e.g. 16:00 or 4pm", words[0])
+	}
+	words = words[1:]
+	now = now.In(loc)
+	at := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, loc)
+	day := ""
+	if len(words) > 0 {
+		day = strings.ToLower(words[0])
+		if day == "on" && len(words) > 1 {
+			day = strings.ToLower(words[1])
+			words = words[1:]
+		}
+	}
+	switch wd, isWeekday := weekdays[day]; {
+	case day == "today":
+		words = words[1:]
+	case day == "tomorrow" || day == "imorgen":
+		at = at.AddDate(0, 0, 1)
+		words = words[1:]
+	case isWeekday:
+		days := (int(wd) - int(now.Weekday()) + 7) % 7
+		if days == 0 && !at.After(now) {
+			days = 7
+		}
+		at = at.AddDate(0, 0, days)
+		words = words[1:]
+	default:
+		if date, err := time.ParseInLocation("2006-01-02", day, loc); err == nil {
+			at = time.Date(date.Year(), date.Month(), date.Day(), hour, minute, 0, 0, loc)
+			words = words[1:]
+			break
+		}
+		// no day: the next time it's that time
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+	}
+	if !at.After(now) {
+		return time.Time{}, words, errors.New("that's in the past")
+	}
+	return at, words, nil
+}
+
+// parseWhen parses "in <duration>" or "at <time> [day]" at the start of words, and returns when that is, and the rest
+// of the words
+func parseWhen(words []string, now time.Time, loc *time.Location) (time.Time, []string, error) {
+	if len(words) == 0 {
+		return time.Time{}, words, errors.New("when?")
+	}
+	switch strings.ToLower(words[0]) {
+	case "in", "om":
+		d, rest, err := parseDuration(words[1:])
+		return now.Add(d), rest, err
+	case "at", "kl", "kl.":
+		return parseAt(words[1:], now, loc)
+	}
+	return time.Time{}, words, errors.New("say \"in\" and how long, or \"at\" and what time")
+}
+
+// connectionFor returns the connection to network, if the bot is connected to it
+func connectionFor(network string) *irc.Connection {
+	nm.RLock()
+	defer nm.RUnlock()
+	for c, conn := range connections {
+		if strings.EqualFold(conn.network, network) && c.Connected() {
+			return c
+		}
+	}
+	return nil
+}
+
+// schedule schedules delivery of r. The caller must hold rm.
+func schedule(r reminder) {
+	j, err := scheduler.At(r.At, func() { deliver(r.ID) })
+	if err != nil {
+		log.Errorf("error scheduling reminder %d: %s", r.ID, err)
+		return
+	}
+	reminderJobs[r.ID] = j
+}
+
+// deliver sends the reminder with id, and forgets it. If the bot isn't connected to its network, it's tried again
+// later.
+func deliver(id int) {
+	rm.Lock()
+	defer rm.Unlock()
+	r, ok := reminders[id]
+	if !ok {
+		return
+	}
+	c := connectionFor(r.Network)
+	if c == nil {
+		r.At = time.Now().Add(retryDelivery)
+		schedule(r)
+		return
+	}
+	SendReply(c, r.Target, r.text(), false)
+	delete(reminders, id)
+	delete(reminderJobs, id)
+	if err := saveReminders(); err != nil {
+		log.Errorf("error saving reminders: %s", err)
+	}
+}
+
+// saveReminders writes the reminders to disk. The caller must hold rm.
+func saveReminders() error {
+	list := make([]reminder, 0, len(reminders))
+	for _, r := range reminders {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return saveJSON(remindersFile, list)
+}
+
+// loadReminders reads the reminders saved on disk, and schedules them. Reminders that were due while the bot was
+// down are sent as soon as it's connected.
+func loadReminders() error {
+	var list []reminder
+	if err := loadJSON(remindersFile, &list); err != nil {
+		return err
+	}
+	rm.Lock()
+	defer rm.Unlock()
+	for _, r := range list {
+		reminders[r.ID] = r
+		if r.ID > lastReminder {
+			lastReminder = r.ID
+		}
+		schedule(r)
+	}
+	return nil
+}
+
+// addReminder saves and schedules r, and returns it with its ID
+func addReminder(r reminder) (reminder, error) {
+	rm.Lock()
+	defer rm.Unlock()
+	lastReminder++
+	r.ID = lastReminder
+	reminders[r.ID] = r
+	if err := saveReminders(); err != nil {
+		delete(reminders, r.ID)
+		return r, err
+	}
+	schedule(r)
+	return r, nil
+}
+
+// formatTime formats t for a user in loc
+func formatTime(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format("Mon Jan 2 15:04 MST")
+}
+
+// remindChannel returns an error if nick may not have reminders sent to channel. Reminders must be enabled there, and
+// unless it's `here`, the channel the command was given in, nick must be in it.
+func remindChannel(ctx context.Context, c *irc.Connection, here, channel, nick string) error {
+	_, sconf := config.ServerFromContext(ctx)
+	if !sconf.Enabled(channel, "remind") {
+		return fmt.Errorf("reminders are turned off in %s", channel)
+	}
+	if strings.EqualFold(channel, here) {
+		return nil
+	}
+	if _, ok := ChannelMember(c, channel, nick); !ok {
+		return fmt.Errorf("I only remind channels you're in")
+	}
+	return nil
+}
+
+func cmdRemind(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	target := replyTarget(c, e)
+	network := Network(c)
+	who := args.Get("who")
+	r := reminder{Network: network, Nick: e.Nick}
+	switch {
+	case strings.EqualFold(who, "me") || strings.EqualFold(who, "mig"):
+		r.Target, r.Self = target, true
+	case isChannel(who):
+		if err := remindChannel(ctx, c, eventChannel(e), who, e.Nick); err != nil {
+			SendReply(c, target, fmt.Sprintf("%s: %s", e.Nick, err), false)
+			return
+		}
+		r.Target = who
+	default:
+		SendReply(c, target, fmt.Sprintf("%s: I can remind you (me) or a channel", e.Nick), false)
+		return
+	}
+	loc := userLocation(ctx, network, e.Nick)
+	at, rest, err := parseWhen(strings.Fields(args.Get("when")), time.Now(), loc)
+	if err != nil {
+		SendReply(c, target, fmt.Sprintf("%s: %s", e.Nick, err), false)
+		return
+	}
+	if len(rest) > 0 && (strings.EqualFold(rest[0], "to") || strings.EqualFold(rest[0], "that")) {
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		SendReply(c, target, fmt.Sprintf("%s: remind you of what?", e.Nick), false)
+		return
+	}
+	r.Message, r.At = strings.Join(rest, " "), at
+	if r, err = addReminder(r); err != nil {
+		log.Errorf("error saving reminder: %s", err)
+		SendReply(c, target, fmt.Sprintf("%s: sorry, I couldn't save that", e.Nick), false)
+		return
+	}
+	whom := r.Target
+	if r.Self {
+		whom = "you"
+	}
+	SendReply(c, target, fmt.Sprintf("%s: okay, I'll remind %s on %s (#%d)", e.Nick, whom, formatTime(at, loc), r.ID),
+		false)
+}
+
+func cmdReminders(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	network := Network(c)
+	loc := userLocation(ctx, network, e.Nick)
+	rm.Lock()
+	var mine []reminder
+	for _, r := range reminders {
+		if strings.EqualFold(r.Network, network) && strings.EqualFold(r.Nick, e.Nick) {
+			mine = append(mine, r)
+		}
+	}
+	rm.Unlock()
+	if len(mine) == 0 {
+		SendReply(c, replyTarget(c, e), fmt.Sprintf("%s: you have no reminders", e.Nick), false)
+		return
+	}
+	sort.Slice(mine, func(i, j int) bool { return mine[i].At.Before(mine[j].At) })
+	lines := make([]string, len(mine))
+	for i, r := range mine {
+		lines[i] = fmt.Sprintf("#%d %s to %s: %s", r.ID, formatTime(r.At, loc), r.Target, r.Message)
+	}
+	SendPaged(c, e.Nick, e.Nick, lines)
+}
+
+func cmdUnremind(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	target := replyTarget(c, e)
+	id := args.Int("id")
+	rm.Lock()
+	r, ok := reminders[id]
+	if !ok || !strings.EqualFold(r.Network, Network(c)) || !strings.EqualFold(r.Nick, e.Nick) {
+		rm.Unlock()
+		SendReply(c, target, fmt.Sprintf("%s: you have no reminder #%d", e.Nick, id), false)
+		return
+	}
+	scheduler.Remove(reminderJobs[id])
+	delete(reminders, id)
+	delete(reminderJobs, id)
+	err := saveReminders()
+	rm.Unlock()
+	if err != nil {
+		log.Errorf("error saving reminders: %s", err)
+	}
+	SendReply(c, target, fmt.Sprintf("%s: forgot reminder #%d", e.Nick, id), false)
+}
+
+func cmdTimezone(ctx context.Context, c *irc.Connection, e *irc.Event, args commands.Args) {
+	target := replyTarget(c, e)
+	network := Network(c)
+	zone := args.Get("zone")
+	if zone == "" {
+		loc := userLocation(ctx, network, e.Nick)
+		SendReply(c, target, fmt.Sprintf("%s: your time zone is %s, where it's %s", e.Nick, loc,
+			formatTime(time.Now(), loc)), false)
+		return
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil || zone == "Local" {
+		SendReply(c, target, fmt.Sprintf("%s: I don't know the time zone %q. Try e.g. Europe/Copenhagen", e.Nick, zone),
+			false)
+		return
+	}
+	if err := setUserLocation(ctx, network, e.Nick, loc); err != nil {
+		log.Errorf("error saving time zones: %s", err)
+		SendReply(c, target, fmt.Sprintf("%s: sorry, I couldn't save that", e.Nick), false)
+		return
+	}
+	SendReply(c, target, fmt.Sprintf("%s: your time zone is now %s, where it's %s", e.Nick, loc,
+		formatTime(time.Now(), loc)), false)
+}
+
+// scheduleAnnouncements schedules the announcements configured for a server on c
+func scheduleAnnouncements(c *irc.Connection, conf config.Config, sconf config.ServerOpts) error {
+	for _, a := range sconf.Announcements {
+		a := a
+		if a.Channel == "" || a.Message == "" {
+			return fmt.Errorf("announcement %q needs a channel and a message", a.Cron)
+		}
+		loc, err := conf.Location()
+		if a.Timezone != "" {
+			loc, err = time.LoadLocation(a.Timezone)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid time zone for announcement %q: %w", a.Cron, err)
+		}
+		if _, err := scheduler.Cron(a.Cron, loc, func() {
+			if c.Connected() {
+				SendReply(c, a.Channel, a.Message, a.Action)
+			}
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
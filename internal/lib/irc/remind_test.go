@@ -0,0 +1,136 @@
+package irc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	irc "github.com/thoj/go-ircevent"
+
+	"github.com/adamhassel/bender/internal/config"
+)
+
+func Test_parseWhen(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		t.Skip(err)
+	}
+	// a wednesday afternoon
+	now := time.Date(2024, 5, 15, 14, 30, 0, 0, loc)
+	tests := []struct {
+		when     string
+		want     time.Time
+		wantRest string
+		wantErr  bool
+	}{
+		{"in 2h tea", now.Add(2 * time.Hour), "tea", false},
+		{"in 1h30m stretch legs", now.Add(90 * time.Minute), "stretch legs", false},
+		{"in 2 hours and 5 minutes tea", now.Add(2*time.Hour + 5*time.Minute), "tea", false},
+		{"in 3 days to call mom", now.Add(72 * time.Hour), "to call mom", false},
+		{"in 1w", now.Add(7 * 24 * time.Hour), "", false},
+		{"at 16:00 beer", time.Date(2024, 5, 15, 16, 0, 0, 0, loc), "beer", false},
+		{"at 9 coffee", time.Date(2024, 5, 16, 9, 0, 0, 0, loc), "coffee", false},
+		{"at 4:30pm today x", time.Date(2024, 5, 15, 16, 30, 0, 0, loc), "x", false},
+		{"at 16:00 friday beer", time.Date(2024, 5, 17, 16, 0, 0, 0, loc), "beer", false},
+		{"at 16:00 on friday beer", time.Date(2024, 5, 17, 16, 0, 0, 0, loc), "beer", false},
+		{"at 10:00 wednesday x", time.Date(2024, 5, 22, 10, 0, 0, 0, loc), "x", false},
+		{"at 08:15 tomorrow x", time.Date(2024, 5, 16, 8, 15, 0, 0, loc), "x", false},
+		{"at 12:00 2024-06-01 x", time.Date(2024, 6, 1, 12, 0, 0, 0, loc), "x", false},
+		{"at 12:00 2024-01-01 x", time.Time{}, "", true},
+		{"at 10:00 today x", time.Time{}, "", true},
+		{"at 25:00 x", time.Time{}, "", true},
+		{"in a while", time.Time{}, "", true},
+		{"tomorrow x", time.Time{}, "", true},
+		{"", time.Time{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.when, func(t *testing.T) {
+			got, rest, err := parseWhen(strings.Fields(tt.when), now, loc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWhen() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseWhen() = %s, want %s", got, tt.want)
+			}
+			if r := strings.Join(rest, " "); r != tt.wantRest {
+				t.Errorf("parseWhen() rest = %q, want %q", r, tt.wantRest)
+			}
+		})
+	}
+}
+
+func Test_parseAt_timezone(t *testing.T) {
+	la, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skip(err)
+	}
+	now := time.Date(2024, 5, 15, 12, 0, 0, 0, time.UTC) // 05:00 in LA
+	got, _, err := parseAt([]string{"16:00"}, now, la)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 5, 15, 23, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("parseAt(16:00) in LA = %s, want %s", got.UTC(), want)
+	}
+}
+
+func Test_reminderText(t *testing.T) {
+	self := reminder{Nick: "alice", Message: "tea", Self: true}
+	if got := self.text(); got != "alice: you asked me to remind you: tea" {
+		t.Errorf("text() = %q", got)
+	}
+	channel := reminder{Nick: "alice", Target: "#chan", Message: "beer"}
+	if got := channel.text(); got != "Reminder from alice: beer" {
+		t.Errorf("text() = %q", got)
+	}
+}
+
+func Test_remindChannel(t *testing.T) {
+	s := newState()
+	for _, line := range []string{
+		":Bender!bender@bot.example.com JOIN #chan",
+		":Bender!bender@bot.example.com JOIN #quiet",
+		":Bender!bender@bot.example.com JOIN #secret",
+		":irc.example.com 353 Bender = #chan :@Bender alice mallory",
+		":irc.example.com 353 Bender = #quiet :@Bender alice",
+		":irc.example.com 353 Bender = #secret :@Bender alice",
+	} {
+		s.handle("Bender", event(line))
+	}
+	c := &irc.Connection{}
+	nm.Lock()
+	connections[c] = &connection{network: "net", state: s}
+	nm.Unlock()
+	defer func() {
+		nm.Lock()
+		delete(connections, c)
+		nm.Unlock()
+	}()
+	conf := config.Config{Servers: map[string]config.ServerOpts{"srv": {
+		ChannelOpts: map[string]config.ChannelOpts{"#quiet": {Toggles: config.Toggles{Deny: []string{"remind"}}}},
+	}}}
+	ctx := config.WithServer(conf.Context(context.Background()), "srv")
+	tests := []struct {
+		here, channel, nick string
+		ok                  bool
+	}{
+		{"#chan", "#chan", "alice", true},
+		{"#chan", "#CHAN", "stranger", true},
+		{"", "#secret", "alice", true},
+		{"#chan", "#secret", "alice", true},
+		{"#chan", "#secret", "mallory", false},
+		{"", "#secret", "mallory", false},
+		{"", "#nowhere", "alice", false},
+		{"", "#quiet", "alice", false},
+	}
+	for _, tt := range tests {
+		err := remindChannel(ctx, c, tt.here, tt.channel, tt.nick)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s reminding %s from %q: err = %v", tt.nick, tt.channel, tt.here, err)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	irc "github.com/thoj/go-ircevent"
+)
+
+// replyTimeout is how long RequestReply waits for a reply if its context has no deadline
+const replyTimeout = 5 * time.Second
+
+// Request is a command to send to the IRC server, and how to recognize its reply. See
+// https://www.alien.net.au/irc/irc2numerics.html for the numerics.
+type Request struct {
+	// Command is the raw command, e.g. "NAMES #channel"
+	Command string
+	// Target is the channel or nick the command is about. Only replies with Target as one of their parameters belong
+	// to the request.
+	Target string
+	// Replies are the numerics of the reply, e.g. "353". If empty, any numeric about Target is part of the reply.
+	Replies []string
+	// End is the numeric that ends a reply of several lines, e.g. "366". If empty, the reply is the first one.
+	End string
+	// Errors are the numerics that mean the command failed, e.g. "401"
+	Errors []string
+}
+
+// NamesRequest asks who's in channel
+func NamesRequest(channel string) Request {
+	return Request{Command: "NAMES " + channel, Target: channel, Replies: []string{"353"}, End: "366",
+		Errors: []string{"403"}}
+}
+
+// WhoRequest asks who matches mask, which may be a channel
+func WhoRequest(mask string) Request {
+	return Request{Command: "WHO " + mask, Target: mask, Replies: []string{"352", "354"}, End: "315",
+		Errors: []string{"403"}}
+}
+
+// WhoisRequest asks about nick
+func WhoisRequest(nick string) Request {
+	return Request{Command: "WHOIS " + nick, Target: nick, End: "318", Errors: []string{"401", "402"}}
+}
+
+// ReplyError is an error numeric the server replied to a request with
+type ReplyError struct {
+	Event *irc.Event
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("server replied %s: %s", e.Event.Code, e.Event.Message())
+}
+
+// ErrNoConnection is returned for requests on connections the bot doesn't know
+var ErrNoConnection = errors.New("unknown connection")
+
+// waiting is a request waiting for its reply
+type waiting struct {
+	req Request
+	// label is the labeled-response label of the request. If it's empty, replies are matched by Target.
+	label string
+	// batch is the reference of the labeled-response batch with the reply
+	batch  string
+	events []*irc.Event
+	err    error
+	done   chan struct{}
+}
+
+// is returns true if code is one of codes
+func is(code string, codes []string) bool {
+	for _, c := range codes {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
+
+// about returns true if e is a numeric with target as one of its parameters. The first parameter, which is the bot's
+// nick, and the trailing text are skipped.
+func about(e *irc.Event, target string) bool {
+	if len(e.Code) != 3 || len(e.Arguments) < 2 {
+		return false
+	}
+	params := e.Arguments[1:]
+	if len(params) > 1 {
+		params = params[:len(params)-1]
+	}
+	for _, p := range params {
+		if strings.EqualFold(p, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// requests are the requests waiting for replies on a connection
+type requests struct {
+	m       sync.Mutex
+	waiting []*waiting
+	labels  int
+}
+
+// finish removes p, and tells whoever's waiting. The caller must hold the lock.
+func (r *requests) finish(p *waiting, err error) {
+	for i, q := range r.waiting {
+		if q == p {
+			r.waiting = append(r.waiting[:i], r.waiting[i+1:]...)
+			p.err = err
+			close(p.done)
+			return
+		}
+	}
+}
+
+// dispatch hands e to the request it's a reply to, if any
+func (r *requests) dispatch(e *irc.Event) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	for _, p := range r.waiting {
+		if p.label != "" {
+			if r.labeled(p, e) {
+				return
+			}
+			continue
+		}
+		if !about(e, p.req.Target) {
+			continue
+		}
+		switch {
+		case is(e.Code, p.req.Errors):
+			r.finish(p, &ReplyError{Event: e})
+		case e.Code == p.req.End:
+			r.finish(p, nil)
+		case len(p.req.Replies) == 0 || is(e.Code, p.req.Replies):
+			p.events = append(p.events, e)
+			if p.req.End == "" {
+				r.finish(p, nil)
+			}
+		default:
+			continue
+		}
+		// servers answer in order, so an event only belongs to the oldest request it matches
+		return
+	}
+}
+
+// labeled handles e if it's part of the labeled-response reply to p, and returns true if it was. The caller must hold
+// the lock.
+func (r *requests) labeled(p *waiting, e *irc.Event) bool {
+	switch {
+	case e.Tags["label"] == p.label:
+		// the reply is either a batch, an ACK for no reply at all, or a single line
+		switch {
+		case e.Code == "BATCH" && len(e.Arguments) > 0 && strings.HasPrefix(e.Arguments[0], "+"):
+			p.batch = e.Arguments[0][1:]
+		case e.Code == "ACK":
+			r.finish(p, nil)
+		case is(e.Code, p.req.Errors):
+			r.finish(p, &ReplyError{Event: e})
+		default:
+			p.events = append(p.events, e)
+			r.finish(p, nil)
+		}
+	case p.batch != "" && e.Tags["batch"] == p.batch:
+		if is(e.Code, p.req.Errors) && p.err == nil {
+			p.err = &ReplyError{Event: e}
+		}
+		if e.Code != p.req.End && !is(e.Code, p.req.Errors) {
+			p.events = append(p.events, e)
+		}
+	case p.batch != "" && e.Code == "BATCH" && len(e.Arguments) > 0 && e.Arguments[0] == "-"+p.batch:
+		r.finish(p, p.err)
+	default:
+		return false
+	}
+	return true
+}
+
+// do sends req with `send`, and waits for the reply. If labeled is true, the request is sent with a labeled-response
+// label.
+func (r *requests) do(ctx context.Context, send func(string), labeled bool, req Request) ([]*irc.Event, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, replyTimeout)
+		defer cancel()
+	}
+	p := &waiting{req: req, done: make(chan struct{})}
+	r.m.Lock()
+	command := req.Command
+	if labeled {
+		r.labels++
+		p.label = fmt.Sprintf("bender%d", r.labels)
+		command = "@label=" + p.label + " " + command
+	}
+	r.waiting = append(r.waiting, p)
+	r.m.Unlock()
+
+	send(command)
+	select {
+	case <-p.done:
+		log.Debugf("reply to %q: %d lines, error %v", req.Command, len(p.events), p.err)
+		return p.events, p.err
+	case <-ctx.Done():
+		r.m.Lock()
+		defer r.m.Unlock()
+		select {
+		case <-p.done:
+			// the reply made it after all
+			return p.events, p.err
+		default:
+		}
+		r.finish(p, ctx.Err())
+		return nil, fmt.Errorf("no reply to %q: %w", req.Command, ctx.Err())
+	}
+}
+
+// follow has r collect the replies on c
+func (r *requests) follow(c *irc.Connection) {
+	c.AddCallback("*", r.dispatch)
+}
+
+// RequestReply sends req to the IRC server, and returns all the lines of the reply. Replies are matched to requests by
+// their target, or by label if the server supports labeled-response, so concurrent requests each get their own. It
+// gives up when ctx is done, or after 5 seconds if ctx has no deadline.
+func RequestReply(ctx context.Context, c *irc.Connection, req Request) ([]*irc.Event, error) {
+	nm.RLock()
+	conn, ok := connections[c]
+	nm.RUnlock()
+	if !ok {
+		return nil, ErrNoConnection
+	}
+	return conn.requests.do(ctx, c.SendRaw, conn.state.hasCap("labeled-response"), req)
+}
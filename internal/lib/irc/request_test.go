@@ -0,0 +1,144 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	irc "github.com/thoj/go-ircevent"
+)
+
+// replies runs req on r, and feeds it the lines when it's been sent
+func replies(r *requests, labeled bool, req Request, lines ...string) ([]*irc.Event, error) {
+	return r.do(context.Background(), func(string) {
+		go func() {
+			for _, line := range lines {
+				r.dispatch(event(line))
+			}
+		}()
+	}, labeled, req)
+}
+
+// messages returns the last parameter of each of events
+func messages(events []*irc.Event) []string {
+	rv := make([]string, len(events))
+	for i, e := range events {
+		rv[i] = e.Message()
+	}
+	return rv
+}
+
+func Test_requests_concurrent(t *testing.T) {
+	r := &requests{}
+	type result struct {
+		events []*irc.Event
+		err    error
+	}
+	a, b := make(chan result), make(chan result)
+	sent := make(chan string, 2)
+	for _, req := range []struct {
+		channel string
+		res     chan result
+	}{{"#a", a}, {"#b", b}} {
+		req := req
+		go func() {
+			events, err := r.do(context.Background(), func(cmd string) { sent <- cmd }, false, NamesRequest(req.channel))
+			req.res <- result{events, err}
+		}()
+	}
+	<-sent
+	<-sent
+	// the replies are interleaved, and there's an unrelated NAMES reply for a channel the bot just joined
+	for _, line := range []string{
+		":srv 353 bot = #b :@bot b1",
+		":srv 353 bot = #c :@bot c1",
+		":srv 353 bot = #a :@bot a1",
+		":srv 353 bot = #a :a2",
+		":srv 366 bot #a :End of /NAMES list.",
+		":srv 353 bot = #b :b2",
+		":srv 366 bot #b :End of /NAMES list.",
+	} {
+		r.dispatch(event(line))
+	}
+	for channel, res := range map[string]chan result{"#a": a, "#b": b} {
+		got := <-res
+		if got.err != nil {
+			t.Fatalf("NAMES %s: %s", channel, got.err)
+		}
+		want := map[string][]string{"#a": {"@bot a1", "a2"}, "#b": {"@bot b1", "b2"}}[channel]
+		if m := messages(got.events); len(m) != 2 || m[0] != want[0] || m[1] != want[1] {
+			t.Errorf("NAMES %s = %q, want %q", channel, m, want)
+		}
+	}
+	if len(r.waiting) != 0 {
+		t.Errorf("%d requests still waiting", len(r.waiting))
+	}
+}
+
+func Test_requests(t *testing.T) {
+	r := &requests{}
+	events, err := replies(r, false, WhoisRequest("Alice"),
+		":srv 311 bot alice ~a host.example.com * :Alice A",
+		":srv 311 bot bob ~b host.example.com * :Not this one",
+		":srv 319 bot alice :#a @#b",
+		":srv 318 bot alice :End of /WHOIS list.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := messages(events); len(m) != 2 || m[0] != "Alice A" || m[1] != "#a @#b" {
+		t.Errorf("WHOIS = %q", m)
+	}
+
+	_, err = replies(r, false, WhoisRequest("nobody"), ":srv 401 bot nobody :No such nick/channel")
+	var rerr *ReplyError
+	if !errors.As(err, &rerr) || rerr.Event.Code != "401" {
+		t.Errorf("WHOIS nobody: err = %v", err)
+	}
+
+	// a single line reply
+	events, err = replies(r, false, Request{Command: "TOPIC #a", Target: "#a", Replies: []string{"331", "332"}},
+		":srv 332 bot #a :the topic")
+	if err != nil || len(events) != 1 || events[0].Message() != "the topic" {
+		t.Errorf("TOPIC = %v, %v", events, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.do(ctx, func(string) {}, false, NamesRequest("#silent")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("no reply: err = %v", err)
+	}
+	if len(r.waiting) != 0 {
+		t.Errorf("%d requests still waiting", len(r.waiting))
+	}
+}
+
+func Test_requests_labeled(t *testing.T) {
+	r := &requests{}
+	events, err := replies(r, true, WhoRequest("#a"),
+		":srv 352 bot #a ~x host srv other H :0 Not labeled",
+		"@label=bender1 :srv BATCH +ref1 labeled-response",
+		"@batch=ref1 :srv 352 bot #a ~a host srv alice H :0 Alice",
+		"@batch=ref1 :srv 352 bot #a ~b host srv bob H@ :0 Bob",
+		"@batch=ref1 :srv 315 bot #a :End of /WHO list.",
+		":srv BATCH -ref1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := messages(events); len(m) != 2 || m[0] != "0 Alice" || m[1] != "0 Bob" {
+		t.Errorf("WHO = %q", m)
+	}
+
+	// a single reply isn't batched
+	events, err = replies(r, true, WhoisRequest("nobody"), "@label=bender2 :srv 401 bot nobody :No such nick")
+	var rerr *ReplyError
+	if !errors.As(err, &rerr) || len(events) != 0 {
+		t.Errorf("WHOIS nobody = %v, %v", events, err)
+	}
+
+	// no reply at all is an ACK
+	events, err = replies(r, true, Request{Command: "MODE #a +m", Target: "#a", End: "324"}, "@label=bender3 :srv ACK")
+	if err != nil || len(events) != 0 {
+		t.Errorf("MODE = %v, %v", events, err)
+	}
+}
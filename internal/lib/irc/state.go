@@ -0,0 +1,556 @@
+package irc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	irc "github.com/thoj/go-ircevent"
+
+	"github.com/adamhassel/bender/internal/helpers"
+)
+
+// whoxToken marks the WHO replies the state tracker asked for
+const whoxToken = "745"
+
+// wantedCaps are the IRCv3 capabilities the bot uses when the server has them: the ones that make the state tracker
+// more accurate, account-tag for ignores, and labeled-response for RequestReply. They're requested one at a time, so a
+// server that doesn't know one still acknowledges the rest.
+var wantedCaps = []string{"multi-prefix", "userhost-in-names", "extended-join", "account-notify", "chghost",
+	"account-tag", "batch", "labeled-response"}
+
+// User is what the bot knows about someone it shares a channel with
+type User struct {
+	Nick  string `json:"nick"`
+	Ident string `json:"user"`
+	Host  string `json:"host"`
+	// Account is the services account the user is logged in to, if the server tells
+	Account  string `json:"account,omitempty"`
+	Realname string `json:"realname,omitempty"`
+}
+
+// Hostmask returns the user's nick!user@host. Parts that aren't known are "*".
+func (u User) Hostmask() string {
+	ident, host := u.Ident, u.Host
+	if ident == "" {
+		ident = "*"
+	}
+	if host == "" {
+		host = "*"
+	}
+	return u.Nick + "!" + ident + "@" + host
+}
+
+// Member is a user in a channel
+type Member struct {
+	User
+	// Prefixes are the user's channel status prefixes, highest first, e.g. "@+"
+	Prefixes string `json:"prefixes"`
+	// Op is true if the user is a channel operator, or higher
+	Op bool `json:"op"`
+}
+
+// chanState is what the bot knows about a channel it's in
+type chanState struct {
+	name string
+	// members maps folded nicks to their channel status prefixes
+	members map[string]string
+	// namesDone is true when a NAMES reply has ended, so the next one starts over
+	namesDone bool
+}
+
+// state tracks the channels the bot is in on one connection, who's in them, and their status
+type state struct {
+	m sync.RWMutex
+	// prefixModes and prefixes are the channel status modes and their prefixes, highest first, from ISUPPORT PREFIX
+	prefixModes string
+	prefixes    string
+	// chanModes are the channel modes in the four ISUPPORT CHANMODES groups: lists, always with a parameter, with a
+	// parameter when set, and never with a parameter
+	chanModes   [4]string
+	chanTypes   string
+	casemapping string
+	whox        bool
+	// caps are the capabilities the server has acknowledged since connecting
+	caps     helpers.Set[string]
+	users    map[string]*User
+	channels map[string]*chanState
+}
+
+func newState() *state {
+	s := &state{}
+	s.reset()
+	return s
+}
+
+// reset forgets everything, as when connecting. The caller must hold the lock, or own s.
+func (s *state) reset() {
+	s.prefixModes, s.prefixes = "ov", "@+"
+	s.chanModes = [4]string{"beI", "k", "l", "imnpst"}
+	s.chanTypes = "#&"
+	s.casemapping = "rfc1459"
+	s.whox = false
+	s.caps = helpers.NewSet[string]()
+	s.users = make(map[string]*User)
+	s.channels = make(map[string]*chanState)
+}
+
+// fold returns the case folded name, by the server's case mapping
+func (s *state) fold(name string) string {
+	name = strings.ToLower(name)
+	switch s.casemapping {
+	case "ascii":
+		return name
+	case "strict-rfc1459":
+		return strings.NewReplacer("[", "{", "]", "}", `\`, "|").Replace(name)
+	}
+	return strings.NewReplacer("[", "{", "]", "}", `\`, "|", "~", "^").Replace(name)
+}
+
+// isChannel returns true if name is a channel, by the server's channel types
+func (s *state) isChannel(name string) bool {
+	return name != "" && strings.ContainsRune(s.chanTypes, rune(name[0]))
+}
+
+// user returns the user with nick, and adds them if they aren't known
+func (s *state) user(nick string) *User {
+	k := s.fold(nick)
+	u, ok := s.users[k]
+	if !ok {
+		u = &User{Nick: nick}
+		s.users[k] = u
+	}
+	return u
+}
+
+// seen updates what's known about the sender of e
+func (s *state) seen(e *irc.Event) *User {
+	u := s.user(e.Nick)
+	u.Nick = e.Nick
+	if e.User != "" {
+		u.Ident = e.User
+	}
+	if e.Host != "" {
+		u.Host = e.Host
+	}
+	return u
+}
+
+// forget removes nick from the users if they're in none of the bot's channels
+func (s *state) forget(nick string) {
+	k := s.fold(nick)
+	for _, ch := range s.channels {
+		if _, ok := ch.members[k]; ok {
+			return
+		}
+	}
+	delete(s.users, k)
+}
+
+// prune removes the users that are in none of the bot's channels
+func (s *state) prune() {
+	for k := range s.users {
+		s.forget(k)
+	}
+}
+
+// sortPrefixes orders prefixes highest first, and removes duplicates
+func (s *state) sortPrefixes(prefixes string) string {
+	var b strings.Builder
+	for _, p := range s.prefixes {
+		if strings.ContainsRune(prefixes, p) {
+			b.WriteRune(p)
+		}
+	}
+	return b.String()
+}
+
+// splitPrefixes splits a name from NAMES into its status prefixes and the rest
+func (s *state) splitPrefixes(name string) (prefixes, rest string) {
+	i := 0
+	for i < len(name) && strings.IndexByte(s.prefixes, name[i]) >= 0 {
+		i++
+	}
+	return name[:i], name[i:]
+}
+
+// isOp returns true if prefixes make a user a channel operator or higher
+func (s *state) isOp(prefixes string) bool {
+	op := strings.IndexByte(s.prefixModes, 'o')
+	if op < 0 || prefixes == "" {
+		return false
+	}
+	return strings.IndexByte(s.prefixes, prefixes[0]) <= op
+}
+
+// isupport reads the ISUPPORT tokens the tracker cares about
+func (s *state) isupport(tokens []string) {
+	for _, t := range tokens {
+		key, value, _ := strings.Cut(t, "=")
+		switch key {
+		case "PREFIX":
+			// (qaohv)~&@%+
+			modes, prefixes, ok := strings.Cut(strings.TrimPrefix(value, "("), ")")
+			if ok && len(modes) == len(prefixes) {
+				s.prefixModes, s.prefixes = modes, prefixes
+			}
+		case "CHANMODES":
+			if groups := strings.Split(value, ","); len(groups) >= 4 {
+				copy(s.chanModes[:], groups)
+			}
+		case "CHANTYPES":
+			s.chanTypes = value
+		case "CASEMAPPING":
+			s.casemapping = strings.ToLower(value)
+		case "WHOX":
+			s.whox = true
+		}
+	}
+}
+
+// mode applies a channel MODE change
+func (s *state) mode(ch *chanState, modes string, params []string) {
+	adding := true
+	for _, m := range modes {
+		switch {
+		case m == '+':
+			adding = true
+		case m == '-':
+			adding = false
+		case strings.ContainsRune(s.prefixModes, m):
+			if len(params) == 0 {
+				return
+			}
+			nick := s.fold(params[0])
+			params = params[1:]
+			prefixes, ok := ch.members[nick]
+			if !ok {
+				continue
+			}
+			p := string(s.prefixes[strings.IndexRune(s.prefixModes, m)])
+			if adding {
+				ch.members[nick] = s.sortPrefixes(prefixes + p)
+			} else {
+				ch.members[nick] = strings.ReplaceAll(prefixes, p, "")
+			}
+		case strings.ContainsRune(s.chanModes[0], m), strings.ContainsRune(s.chanModes[1], m),
+			adding && strings.ContainsRune(s.chanModes[2], m):
+			// modes with a parameter that isn't a nick
+			if len(params) > 0 {
+				params = params[1:]
+			}
+		}
+	}
+}
+
+// handle updates the state from e. `me` is the bot's current nick.
+func (s *state) handle(me string, e *irc.Event) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	arg := func(i int) string {
+		if i < len(e.Arguments) {
+			return e.Arguments[i]
+		}
+		return ""
+	}
+	switch e.Code {
+	case "001":
+		s.reset()
+	case "CAP":
+		// CAP me ACK :caps, or CAP me DEL :caps
+		for _, cap := range strings.Fields(arg(2)) {
+			switch arg(1) {
+			case "ACK":
+				if strings.HasPrefix(cap, "-") {
+					s.caps.Delete(cap[1:])
+				} else {
+					s.caps.Add(cap)
+				}
+			case "DEL":
+				s.caps.Delete(cap)
+			}
+		}
+	case "005":
+		if len(e.Arguments) > 2 {
+			s.isupport(e.Arguments[1 : len(e.Arguments)-1])
+		}
+	case "JOIN":
+		name := arg(0)
+		if s.fold(e.Nick) == s.fold(me) {
+			s.channels[s.fold(name)] = &chanState{name: name, members: make(map[string]string), namesDone: true}
+		}
+		ch, ok := s.channels[s.fold(name)]
+		if !ok {
+			return
+		}
+		u := s.seen(e)
+		// extended-join: JOIN #channel account :realname
+		if len(e.Arguments) >= 3 {
+			u.Account, u.Realname = arg(1), arg(2)
+			if u.Account == "*" {
+				u.Account = ""
+			}
+		}
+		if _, ok := ch.members[s.fold(e.Nick)]; !ok {
+			ch.members[s.fold(e.Nick)] = ""
+		}
+	case "PART":
+		s.leave(me, arg(0), e.Nick)
+	case "KICK":
+		s.leave(me, arg(0), arg(1))
+	case "QUIT":
+		k := s.fold(e.Nick)
+		for _, ch := range s.channels {
+			delete(ch.members, k)
+		}
+		delete(s.users, k)
+	case "NICK":
+		oldKey, newNick := s.fold(e.Nick), e.Message()
+		newKey := s.fold(newNick)
+		if u, ok := s.users[oldKey]; ok {
+			delete(s.users, oldKey)
+			u.Nick = newNick
+			s.users[newKey] = u
+		}
+		for _, ch := range s.channels {
+			if prefixes, ok := ch.members[oldKey]; ok {
+				delete(ch.members, oldKey)
+				ch.members[newKey] = prefixes
+			}
+		}
+	case "MODE":
+		if ch, ok := s.channels[s.fold(arg(0))]; ok && len(e.Arguments) > 1 {
+			s.mode(ch, arg(1), e.Arguments[2:])
+		}
+	case "ACCOUNT":
+		if u, ok := s.users[s.fold(e.Nick)]; ok {
+			u.Account = arg(0)
+			if u.Account == "*" {
+				u.Account = ""
+			}
+		}
+	case "CHGHOST":
+		if u, ok := s.users[s.fold(e.Nick)]; ok {
+			u.Ident, u.Host = arg(0), arg(1)
+		}
+	case "PRIVMSG", "NOTICE":
+		if _, ok := s.users[s.fold(e.Nick)]; ok {
+			s.seen(e)
+		}
+	case "353":
+		// RPL_NAMREPLY: me = #channel :names
+		ch, ok := s.channels[s.fold(arg(2))]
+		if !ok {
+			return
+		}
+		if ch.namesDone {
+			ch.members = make(map[string]string)
+			ch.namesDone = false
+		}
+		for _, name := range strings.Fields(arg(3)) {
+			prefixes, rest := s.splitPrefixes(name)
+			// userhost-in-names: nick!user@host
+			nick, userhost, _ := strings.Cut(rest, "!")
+			if nick == "" {
+				continue
+			}
+			u := s.user(nick)
+			u.Nick = nick
+			if ident, host, ok := strings.Cut(userhost, "@"); ok {
+				u.Ident, u.Host = ident, host
+			}
+			ch.members[s.fold(nick)] = s.sortPrefixes(prefixes)
+		}
+	case "366":
+		// RPL_ENDOFNAMES
+		if ch, ok := s.channels[s.fold(arg(1))]; ok {
+			ch.namesDone = true
+			s.prune()
+		}
+	case "352":
+		// RPL_WHOREPLY: me #channel user host server nick flags :hops realname
+		if u, ok := s.users[s.fold(arg(5))]; ok {
+			u.Ident, u.Host = arg(2), arg(3)
+			if _, realname, ok := strings.Cut(arg(7), " "); ok {
+				u.Realname = realname
+			}
+		}
+	case "354":
+		// WHOX reply to "%tcuhnar": me token #channel user host nick account :realname
+		if arg(1) != whoxToken {
+			return
+		}
+		if u, ok := s.users[s.fold(arg(5))]; ok {
+			u.Ident, u.Host, u.Realname = arg(3), arg(4), arg(7)
+			u.Account = arg(6)
+			if u.Account == "0" {
+				u.Account = ""
+			}
+		}
+	}
+}
+
+// leave removes nick from channel. If nick is the bot, the channel is forgotten.
+func (s *state) leave(me, channel, nick string) {
+	k := s.fold(channel)
+	if s.fold(nick) == s.fold(me) {
+		delete(s.channels, k)
+		s.prune()
+		return
+	}
+	if ch, ok := s.channels[k]; ok {
+		delete(ch.members, s.fold(nick))
+	}
+	s.forget(nick)
+}
+
+// stateEvents are the events the state tracker follows
+var stateEvents = []string{"001", "CAP", "005", "JOIN", "PART", "KICK", "QUIT", "NICK", "MODE", "ACCOUNT", "CHGHOST",
+	"PRIVMSG", "NOTICE", "353", "366", "352", "354"}
+
+// follow has s track the events on c
+func (s *state) follow(c *irc.Connection) {
+	for _, code := range stateEvents {
+		c.AddCallback(code, func(e *irc.Event) {
+			s.handle(c.GetNick(), e)
+		})
+	}
+	c.AddCallback("001", func(e *irc.Event) {
+		for _, cap := range wantedCaps {
+			c.SendRawf("CAP REQ :%s", cap)
+		}
+	})
+	// ask who's who when the bot joins a channel, for hostmasks and accounts
+	c.AddCallback("JOIN", func(e *irc.Event) {
+		if !strings.EqualFold(e.Nick, c.GetNick()) {
+			return
+		}
+		s.m.RLock()
+		whox := s.whox
+		s.m.RUnlock()
+		if whox {
+			c.SendRawf("WHO %s %%tcuhnar,%s", e.Arguments[0], whoxToken)
+		} else {
+			c.Who(e.Arguments[0])
+		}
+	})
+}
+
+// hasCap returns true if the server has acknowledged cap
+func (s *state) hasCap(cap string) bool {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.caps.Exists(cap)
+}
+
+// member returns the member of ch with the folded nick k. The caller must hold the lock.
+func (s *state) member(ch *chanState, k string) Member {
+	m := Member{Prefixes: ch.members[k], Op: s.isOp(ch.members[k])}
+	if u, ok := s.users[k]; ok {
+		m.User = *u
+	}
+	return m
+}
+
+// stateOf returns the state tracker of c
+func stateOf(c *irc.Connection) *state {
+	nm.RLock()
+	defer nm.RUnlock()
+	if conn, ok := connections[c]; ok {
+		return conn.state
+	}
+	return nil
+}
+
+// Joined returns the channels the bot is in on c, sorted
+func Joined(c *irc.Connection) []string {
+	s := stateOf(c)
+	if s == nil {
+		return nil
+	}
+	return s.joined()
+}
+
+// joined returns the channels the bot is in, sorted
+func (s *state) joined() []string {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	rv := make([]string, 0, len(s.channels))
+	for _, ch := range s.channels {
+		rv = append(rv, ch.name)
+	}
+	sort.Slice(rv, func(i, j int) bool { return strings.ToLower(rv[i]) < strings.ToLower(rv[j]) })
+	return rv
+}
+
+// ChannelMembers returns who's in channel, sorted by nick. It returns false if the bot isn't in channel.
+func ChannelMembers(c *irc.Connection, channel string) ([]Member, bool) {
+	s := stateOf(c)
+	if s == nil {
+		return nil, false
+	}
+	s.m.RLock()
+	defer s.m.RUnlock()
+	ch, ok := s.channels[s.fold(channel)]
+	if !ok {
+		return nil, false
+	}
+	rv := make([]Member, 0, len(ch.members))
+	for k := range ch.members {
+		rv = append(rv, s.member(ch, k))
+	}
+	sort.Slice(rv, func(i, j int) bool { return strings.ToLower(rv[i].Nick) < strings.ToLower(rv[j].Nick) })
+	return rv, true
+}
+
+// ChannelMember returns nick in channel, if they're there
+func ChannelMember(c *irc.Connection, channel, nick string) (Member, bool) {
+	s := stateOf(c)
+	if s == nil {
+		return Member{}, false
+	}
+	s.m.RLock()
+	defer s.m.RUnlock()
+	ch, ok := s.channels[s.fold(channel)]
+	if !ok {
+		return Member{}, false
+	}
+	if _, ok := ch.members[s.fold(nick)]; !ok {
+		return Member{}, false
+	}
+	return s.member(ch, s.fold(nick)), true
+}
+
+// LookupUser returns what the bot knows about nick, if it shares a channel with them
+func LookupUser(c *irc.Connection, nick string) (User, bool) {
+	s := stateOf(c)
+	if s == nil {
+		return User{}, false
+	}
+	s.m.RLock()
+	defer s.m.RUnlock()
+	u, ok := s.users[s.fold(nick)]
+	if !ok {
+		return User{}, false
+	}
+	return *u, true
+}
+
+// UserChannels returns the channels the bot shares with nick, sorted
+func UserChannels(c *irc.Connection, nick string) []string {
+	s := stateOf(c)
+	if s == nil {
+		return nil
+	}
+	s.m.RLock()
+	defer s.m.RUnlock()
+	var rv []string
+	k := s.fold(nick)
+	for _, ch := range s.channels {
+		if _, ok := ch.members[k]; ok {
+			rv = append(rv, ch.name)
+		}
+	}
+	sort.Slice(rv, func(i, j int) bool { return strings.ToLower(rv[i]) < strings.ToLower(rv[j]) })
+	return rv
+}
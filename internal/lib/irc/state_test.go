@@ -0,0 +1,152 @@
+package irc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	irc "github.com/thoj/go-ircevent"
+)
+
+// event parses a raw IRC line, like the IRC library does
+func event(line string) *irc.Event {
+	e := &irc.Event{Raw: line}
+	if strings.HasPrefix(line, "@") {
+		var tags string
+		tags, line, _ = strings.Cut(line[1:], " ")
+		e.Tags = make(map[string]string)
+		for _, tag := range strings.Split(tags, ";") {
+			k, v, _ := strings.Cut(tag, "=")
+			e.Tags[k] = v
+		}
+	}
+	if strings.HasPrefix(line, ":") {
+		e.Source, line, _ = strings.Cut(line[1:], " ")
+		if nick, userhost, ok := strings.Cut(e.Source, "!"); ok {
+			e.Nick = nick
+			e.User, e.Host, _ = strings.Cut(userhost, "@")
+		}
+	}
+	line, trailing, ok := strings.Cut(line, " :")
+	args := strings.Split(line, " ")
+	e.Code, e.Arguments = args[0], args[1:]
+	if ok {
+		e.Arguments = append(e.Arguments, trailing)
+	}
+	return e
+}
+
+// members returns the members of channel in s, by nick
+func members(s *state, channel string) map[string]Member {
+	ch, ok := s.channels[s.fold(channel)]
+	if !ok {
+		return nil
+	}
+	rv := make(map[string]Member)
+	for k := range ch.members {
+		m := s.member(ch, k)
+		rv[m.Nick] = m
+	}
+	return rv
+}
+
+func Test_state(t *testing.T) {
+	s := newState()
+	for _, line := range []string{
+		":irc.example.com 001 Bender :Welcome",
+		":irc.example.com CAP Bender ACK :multi-prefix labeled-response",
+		":irc.example.com CAP Bender DEL :multi-prefix",
+		":irc.example.com 005 Bender PREFIX=(qaohv)~&@%+ CHANMODES=beI,k,l,imnpst WHOX :are supported by this server",
+		":Bender!bender@bot.example.com JOIN #chan",
+		":irc.example.com 353 Bender = #chan :@Bender ~@owner %half +voice plain",
+		":irc.example.com 353 Bender = #chan :@+both",
+		":irc.example.com 366 Bender #chan :End of /NAMES list.",
+		":irc.example.com 354 Bender 745 #chan ~o owner.example.com owner ownacct :The Owner",
+		":irc.example.com 354 Bender 745 #chan plain plain.example.com plain 0 :Plain Jane",
+		":irc.example.com 352 Bender #chan ~h half.example.com irc.example.com half H% :0 Half Life",
+	} {
+		s.handle("Bender", event(line))
+	}
+	if !s.hasCap("labeled-response") || s.hasCap("multi-prefix") {
+		t.Errorf("caps = %v", s.caps)
+	}
+	if !s.whox || s.prefixes != "~&@%+" {
+		t.Fatalf("ISUPPORT wasn't read: %+v", s)
+	}
+	got := members(s, "#CHAN")
+	want := map[string]Member{
+		"Bender": {User: User{Nick: "Bender", Ident: "bender", Host: "bot.example.com"}, Prefixes: "@", Op: true},
+		"owner": {User: User{Nick: "owner", Ident: "~o", Host: "owner.example.com", Account: "ownacct",
+			Realname: "The Owner"}, Prefixes: "~@", Op: true},
+		"half":  {User: User{Nick: "half", Ident: "~h", Host: "half.example.com", Realname: "Half Life"}, Prefixes: "%"},
+		"voice": {User: User{Nick: "voice"}, Prefixes: "+"},
+		"plain": {User: User{Nick: "plain", Ident: "plain", Host: "plain.example.com", Realname: "Plain Jane"}},
+		"both":  {User: User{Nick: "both"}, Prefixes: "@+", Op: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("after NAMES and WHO:\n got %+v\nwant %+v", got, want)
+	}
+
+	for _, line := range []string{
+		":owner!~o@owner.example.com MODE #chan -q+o-v+l voice 10 voice",
+		":owner!~o@owner.example.com MODE #chan +vh-o half half both",
+		":new!n@new.example.com JOIN #chan newacct :New Person",
+		":plain!plain@plain.example.com NICK :Jane",
+		":both!b@b.example.com PART #chan :bye",
+		":owner!~o@owner.example.com KICK #chan voice :out",
+		":half!~h@half.example.com QUIT :gone",
+		":new!n@new.example.com ACCOUNT *",
+		":Jane!plain@plain.example.com CHGHOST jane jane.example.com",
+	} {
+		s.handle("Bender", event(line))
+	}
+	got = members(s, "#chan")
+	want = map[string]Member{
+		"Bender": {User: User{Nick: "Bender", Ident: "bender", Host: "bot.example.com"}, Prefixes: "@", Op: true},
+		"owner": {User: User{Nick: "owner", Ident: "~o", Host: "owner.example.com", Account: "ownacct",
+			Realname: "The Owner"}, Prefixes: "~@", Op: true},
+		"new":  {User: User{Nick: "new", Ident: "n", Host: "new.example.com", Realname: "New Person"}},
+		"Jane": {User: User{Nick: "Jane", Ident: "jane", Host: "jane.example.com", Realname: "Plain Jane"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("after changes:\n got %+v\nwant %+v", got, want)
+	}
+	for _, gone := range []string{"half", "both", "voice", "plain"} {
+		if _, ok := s.users[gone]; ok {
+			t.Errorf("%s is still a known user", gone)
+		}
+	}
+
+	// a new NAMES reply starts over
+	s.handle("Bender", event(":irc.example.com 353 Bender = #chan :@Bender owner"))
+	s.handle("Bender", event(":irc.example.com 366 Bender #chan :End of /NAMES list."))
+	if got := members(s, "#chan"); len(got) != 2 || got["owner"].Prefixes != "" {
+		t.Errorf("after a new NAMES: %+v", got)
+	}
+
+	s.handle("Bender", event(":Bender!bender@bot.example.com PART #chan"))
+	if len(s.channels) != 0 || len(s.users) != 0 {
+		t.Errorf("after parting: channels %v, users %v", s.channels, s.users)
+	}
+}
+
+func Test_state_defaults(t *testing.T) {
+	s := newState()
+	// no ISUPPORT: (ov)@+, rfc1459 case mapping, and userhost-in-names
+	s.handle("bender", event(":Bender!b@h JOIN #Chan[1]"))
+	s.handle("bender", event(":irc.example.com 353 bender = #chan{1} :@Bender +Nick[a]!u@host.example.com"))
+	got := members(s, "#chan{1}")
+	want := map[string]Member{
+		"Bender":  {User: User{Nick: "Bender", Ident: "b", Host: "h"}, Prefixes: "@", Op: true},
+		"Nick[a]": {User: User{Nick: "Nick[a]", Ident: "u", Host: "host.example.com"}, Prefixes: "+"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+	if h := got["Nick[a]"].Hostmask(); h != "Nick[a]!u@host.example.com" {
+		t.Errorf("Hostmask() = %q", h)
+	}
+	if h := (User{Nick: "x"}).Hostmask(); h != "x!*@*" {
+		t.Errorf("Hostmask() of unknown user = %q", h)
+	}
+}
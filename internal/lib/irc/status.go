@@ -0,0 +1,106 @@
+package irc
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	irc "github.com/thoj/go-ircevent"
+
+	"github.com/adamhassel/bender/internal/lib/plugins"
+	"github.com/adamhassel/bender/internal/lib/web"
+)
+
+// connection is what the bot knows about a server connection
+type connection struct {
+	server   string
+	network  string
+	state    *state
+	requests *requests
+}
+
+var (
+	started     = time.Now()
+	nm          sync.RWMutex
+	connections = make(map[*irc.Connection]*connection)
+)
+
+// Network returns the name of the network c is connected to
+func Network(c *irc.Connection) string {
+	nm.RLock()
+	defer nm.RUnlock()
+	if conn, ok := connections[c]; ok {
+		return conn.network
+	}
+	return ""
+}
+
+// track registers c as connected to `server` on `network`, and keeps track of the channels it's in, who's there,
+// and replies to requests
+func track(c *irc.Connection, server, network string) {
+	conn := &connection{server: server, network: network, state: newState(), requests: &requests{}}
+	nm.Lock()
+	connections[c] = conn
+	nm.Unlock()
+	conn.state.follow(c)
+	conn.requests.follow(c)
+}
+
+// ServerStatus is the state of a server connection
+type ServerStatus struct {
+	Server    string   `json:"server"`
+	Network   string   `json:"network"`
+	Nick      string   `json:"nick"`
+	Connected bool     `json:"connected"`
+	Channels  []string `json:"channels"`
+}
+
+// Status returns the state of all server connections, sorted by server
+func Status() []ServerStatus {
+	nm.RLock()
+	defer nm.RUnlock()
+	rv := make([]ServerStatus, 0, len(connections))
+	for c, conn := range connections {
+		rv = append(rv, ServerStatus{
+			Server:    conn.server,
+			Network:   conn.network,
+			Nick:      c.GetNick(),
+			Connected: c.Connected(),
+			Channels:  conn.state.joined(),
+		})
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Server < rv[j].Server })
+	return rv
+}
+
+// Uptime returns how long the bot has been running
+func Uptime() time.Duration {
+	return time.Since(started)
+}
+
+var statusPage = web.Page(`{{define "title"}}Status{{end}}
+{{define "content"}}
+<p>Up for {{.Uptime}}, since {{.Started.Format "2006-01-02 15:04:05"}}</p>
+<h2>Servers</h2>
+<table>
+<tr><th>Server</th><th>Network</th><th>Nick</th><th>Connected</th><th>Channels</th></tr>
+{{range .Servers}}<tr><td>{{.Server}}</td><td>{{.Network}}</td><td>{{.Nick}}</td><td>{{if .Connected}}yes{{else}}no{{end}}</td><td>{{range $i, $c := .Channels}}{{if $i}}, {{end}}{{$c}}{{end}}</td></tr>
+{{end}}</table>
+<h2>Plugins</h2>
+<ul>{{range .Plugins}}<li>{{.}}</li>{{else}}<li>None</li>{{end}}</ul>
+{{end}}`)
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	web.Render(w, statusPage, struct {
+		Uptime  time.Duration
+		Started time.Time
+		Servers []ServerStatus
+		Plugins []string
+	}{Uptime().Round(time.Second), started, Status(), plugins.Loaded()})
+}
+
+func init() {
+	web.HandleFunc("/status", statusHandler)
+	web.AddNav("Status", "/status")
+}
@@ -4,6 +4,9 @@
 // signature:
 // func Example(args []string, e *irc.Event) (reply string, action bool)
 //
+// or, to get the arguments parsed according to the "args" and "flags" of the command definition:
+// func Example(args commands.Args, e *irc.Event) (reply string, action bool)
+//
 // Each plugin must have a config file which defines IRC commands and the functions, matching the signature above, that
 // implements them:
 //
@@ -11,4 +14,21 @@
 // command1: function1
 // command2: function2
 // ```
+//
+// Or, in the long form, which also describes the command for the help system:
+//
+//	command1:
+//	  function: function1
+//	  usage: "<argument>"
+//	  description: "Does something"
+//	  aliases: ["c1"]
+//	  role: admin
+//	  args:
+//	    - name: nick
+//	      type: nick
+//	    - name: text
+//	      rest: true
+//	  flags:
+//	    - name: all
+//	  triggers: ["^bender[:,] (.+)$"]
 package plugins
@@ -5,11 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"plugin"
+	"sort"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 	irc "github.com/thoj/go-ircevent"
 	"gopkg.in/yaml.v2"
+
+	"github.com/adamhassel/bender/internal/lib/commands"
 )
 
 type Plugin struct {
@@ -24,9 +29,16 @@ type PluginConf struct {
 	Config map[string]interface{} `yaml:"config"`
 }
 
-type pluginFunc func([]string, *irc.Event) (string, bool)
+type pluginFunc func(commands.Args, *irc.Event) (string, bool)
 type matchFunc func(string, *irc.Event) (string, bool)
 type matchFuncs []matchFunc
+type eventFunc func(*irc.Event)
+
+// eventHandler is an event handling function and the name of the plugin it's from
+type eventHandler struct {
+	plugin string
+	f      eventFunc
+}
 
 // Exported error vars
 var (
@@ -34,11 +46,17 @@ var (
 )
 
 var (
-	// commands holds all commands configured by plugins
-	commands map[string]pluginFunc
+	// pluginCommands holds all commands configured by plugins
+	pluginCommands map[string]pluginFunc
 	// matchers holds all matchers defined in plugins. The key is the plugin name, to make it possible to have name clasges
 	// in different plugins
 	matchers map[string]matchFuncs
+	// events holds the event handlers defined in plugins, by IRC event code
+	events map[string][]eventHandler
+	// loaded holds the names of loaded plugins
+	loaded []string
+	// shutdowns holds the Shutdown functions of plugins, by plugin name
+	shutdowns = make(map[string]func() error)
 )
 
 // loadPluginConf loads per-plugins configuration
@@ -65,31 +83,51 @@ func loadPlugins(pluginConf map[string]string) error {
 			return fmt.Errorf("error loading plugins config: %s: %w", confFile, err)
 		}
 		for command, f := range config {
+			spec := commands.Spec{Name: command, Group: Name(pluginFile)}
 			val, ok := f.(string)
 			if !ok {
+				m, ok := f.(map[interface{}]interface{})
+				if !ok {
+					continue
+				}
 				if command == "config" {
-					if err := setPluginConf(p, f.(map[interface{}]interface{})); err != nil {
+					if err := setPluginConf(p, m); err != nil {
 						log.Errorf("error configuring plugin %q: %s", pluginFile, err)
 					}
+					continue
+				}
+				if val, err = parseCommandSpec(m, &spec); err != nil {
+					return fmt.Errorf("error parsing command %q in %s: %w", command, confFile, err)
 				}
-				continue
 			}
 			sym, err := p.Lookup(val)
 			if err != nil {
-				return fmt.Errorf("symbol %q lookup error: %w", f, err)
+				return fmt.Errorf("symbol %q lookup error: %w", val, err)
 			}
-			if commands == nil {
-				commands = make(map[string]pluginFunc)
+			if pluginCommands == nil {
+				pluginCommands = make(map[string]pluginFunc)
 			}
-			if c, ok := sym.(func([]string, *irc.Event) (string, bool)); ok {
-				if _, ok := commands[command]; ok {
-					return fmt.Errorf("command name clash: %q is already defined", command)
-				}
-				commands[command] = c
-				continue
+			var c pluginFunc
+			switch f := sym.(type) {
+			case func([]string, *irc.Event) (string, bool):
+				c = func(args commands.Args, e *irc.Event) (string, bool) { return f(args.Tokens(), e) }
+			case func(commands.Args, *irc.Event) (string, bool):
+				c = f
+			default:
+				return fmt.Errorf("symbol %q does not match signature", val)
+			}
+			if err := commands.Register(spec); err != nil {
+				return err
 			}
-			return fmt.Errorf("symbol %q does not match signature", f)
+			pluginCommands[command] = c
+		}
+		if err := configureEvents(&Plugin{p, pluginFile}); err != nil {
+			return err
 		}
+		if err := configureShutdown(&Plugin{p, pluginFile}); err != nil {
+			return err
+		}
+		loaded = append(loaded, Name(pluginFile))
 		if err := configureMatchers(&Plugin{p, pluginFile}); err != nil {
 			if errors.Is(err, ErrNoExportedMatchers) {
 				continue
@@ -127,6 +165,50 @@ func configureMatchers(p *Plugin) error {
 	return nil
 }
 
+// configureEvents will configure a plugin that handles IRC events other than messages, like JOIN or KICK. The plugin
+// must export `Events`, a map[string]string from event codes to the names of functions handling them.
+func configureEvents(p *Plugin) error {
+	l, err := p.Lookup("Events")
+	if err != nil {
+		return nil
+	}
+	m, ok := l.(*map[string]string)
+	if !ok {
+		return fmt.Errorf("invalid events export")
+	}
+	for code, fName := range *m {
+		f, err := p.Lookup(fName)
+		if err != nil {
+			return fmt.Errorf("symbol %q lookup error: %w", fName, err)
+		}
+		h, ok := f.(func(*irc.Event))
+		if !ok {
+			return fmt.Errorf("event handler %q does not match signature", fName)
+		}
+		if events == nil {
+			events = make(map[string][]eventHandler)
+		}
+		code = strings.ToUpper(code)
+		events[code] = append(events[code], eventHandler{Name(p.path), h})
+	}
+	return nil
+}
+
+// configureShutdown registers the plugin's `Shutdown` function, if it has one. It's called when the bot shuts down, so
+// the plugin can save its state and close its files.
+func configureShutdown(p *Plugin) error {
+	sym, err := p.Lookup("Shutdown")
+	if err != nil {
+		return nil
+	}
+	f, ok := sym.(func() error)
+	if !ok {
+		return fmt.Errorf("\"Shutdown\" function has wrong signature: %T", sym)
+	}
+	shutdowns[Name(p.path)] = f
+	return nil
+}
+
 // setPluginConf is called if plugin-specific configuration is found
 func setPluginConf(p *plugin.Plugin, conf map[interface{}]interface{}) error {
 	//c, ok := conf.(map[interface{}]interface{})
@@ -146,6 +228,39 @@ func setPluginConf(p *plugin.Plugin, conf map[interface{}]interface{}) error {
 	return f(conf)
 }
 
+// Name returns the name of a plugin from the path of its file, e.g. "urlshort" for "plugins/urlshort.so"
+func Name(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
+
+// parseCommandSpec reads the long form of a command definition into spec, and returns the name of the function
+// implementing the command:
+//
+//	command:
+//	  function: Function
+//	  usage: "<argument>"
+//	  description: "Does something"
+//	  aliases: ["cmd"]
+//	  role: admin
+func parseCommandSpec(m map[interface{}]interface{}, spec *commands.Spec) (string, error) {
+	raw, err := yaml.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	def := struct {
+		Function      string `yaml:"function"`
+		commands.Spec `yaml:",inline"`
+	}{Spec: *spec}
+	if err := yaml.Unmarshal(raw, &def); err != nil {
+		return "", err
+	}
+	if def.Function == "" {
+		return "", errors.New("no function defined")
+	}
+	*spec = def.Spec
+	return def.Function, nil
+}
+
 // LoadPlugins loads plugins and their configuration into memory
 func LoadPlugins(config map[string]string) error {
 	return loadPlugins(config)
@@ -156,8 +271,9 @@ type Result struct {
 	Action  bool
 }
 
-func Execute(command string, args []string, e *irc.Event) (Result, error) {
-	c, ok := commands[command]
+// Execute runs the plugin command `command` with `args`
+func Execute(command string, args commands.Args, e *irc.Event) (Result, error) {
+	c, ok := pluginCommands[command]
 	if !ok {
 		return Result{}, fmt.Errorf("command %q not found in loaded plugins", command)
 	}
@@ -165,9 +281,14 @@ func Execute(command string, args []string, e *irc.Event) (Result, error) {
 	return Result{msg, action}, nil
 }
 
-func Matchers(msg string, e *irc.Event) ([]Result, error) {
+// Matchers runs all matchers from plugins for which `enabled` returns true on msg, and returns their non-empty replies.
+// If `enabled` is nil, all matchers are run.
+func Matchers(msg string, e *irc.Event, enabled func(plugin string) bool) ([]Result, error) {
 	var rv []Result
-	for _, funcs := range matchers {
+	for path, funcs := range matchers {
+		if enabled != nil && !enabled(Name(path)) {
+			continue
+		}
 		for _, f := range funcs {
 			msg, action := f(msg, e)
 			if msg == "" {
@@ -181,3 +302,39 @@ func Matchers(msg string, e *irc.Event) ([]Result, error) {
 	}
 	return rv, nil
 }
+
+// Loaded returns the names of the loaded plugins, sorted
+func Loaded() []string {
+	rv := append([]string(nil), loaded...)
+	sort.Strings(rv)
+	return rv
+}
+
+// EventCodes returns the IRC event codes that plugins want to handle
+func EventCodes() []string {
+	rv := make([]string, 0, len(events))
+	for code := range events {
+		rv = append(rv, code)
+	}
+	return rv
+}
+
+// HandleEvent passes e to all plugin handlers for its event code, from plugins for which `enabled` returns true. If
+// `enabled` is nil, all handlers are run.
+func HandleEvent(e *irc.Event, enabled func(plugin string) bool) {
+	for _, h := range events[e.Code] {
+		if enabled != nil && !enabled(h.plugin) {
+			continue
+		}
+		h.f(e)
+	}
+}
+
+// Shutdown calls the Shutdown function of every plugin that has one, and logs any errors
+func Shutdown() {
+	for name, f := range shutdowns {
+		if err := f(); err != nil {
+			log.Errorf("error shutting down plugin %q: %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package plugins
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	irc "github.com/thoj/go-ircevent"
+)
+
+func TestHandleEvent(t *testing.T) {
+	var got []string
+	handler := func(name string) eventHandler {
+		return eventHandler{plugin: name, f: func(e *irc.Event) { got = append(got, name+" "+e.Code) }}
+	}
+	events = map[string][]eventHandler{
+		"JOIN": {handler("chanlog"), handler("greeter")},
+		"KICK": {handler("chanlog")},
+	}
+	defer func() { events = nil }()
+
+	codes := EventCodes()
+	sort.Strings(codes)
+	if want := []string{"JOIN", "KICK"}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("EventCodes() = %v, want %v", codes, want)
+	}
+
+	tests := []struct {
+		name    string
+		code    string
+		enabled func(string) bool
+		want    []string
+	}{
+		{"all", "JOIN", nil, []string{"chanlog JOIN", "greeter JOIN"}},
+		{"enabled", "JOIN", func(p string) bool { return p == "greeter" }, []string{"greeter JOIN"}},
+		{"none enabled", "KICK", func(string) bool { return false }, nil},
+		{"no handlers", "PART", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			HandleEvent(&irc.Event{Code: tt.code}, tt.enabled)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("HandleEvent() ran %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
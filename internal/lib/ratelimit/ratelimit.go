@@ -0,0 +1,136 @@
+// Package ratelimit limits how often something may happen, with token buckets. A bucket holds up to Burst tokens, and
+// is refilled with Burst tokens per Per. Everything that happens takes a token.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// maxBuckets is how many buckets a limiter keeps before it forgets the full ones
+const maxBuckets = 1000
+
+// Limit is how often something may happen: Burst times in a row, and Burst times per Per on average. A zero Limit
+// doesn't limit anything.
+type Limit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// unlimited returns true if l doesn't limit anything
+func (l Limit) unlimited() bool {
+	return l.Burst <= 0 || l.Per <= 0
+}
+
+// bucket is the tokens left for one key
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+	// warned is true if someone was told the bucket is empty since it ran dry
+	warned bool
+}
+
+// refill adds the tokens earned since the bucket was last used, at now
+func (b *bucket) refill(now time.Time) {
+	if now.Before(b.last) {
+		return
+	}
+	rate := float64(b.limit.Burst) / float64(b.limit.Per)
+	b.tokens += float64(now.Sub(b.last)) * rate
+	if b.tokens > float64(b.limit.Burst) {
+		b.tokens = float64(b.limit.Burst)
+	}
+	b.last = now
+}
+
+// wait returns how long until b has a token
+func (b *bucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(b.limit.Per) / float64(b.limit.Burst))
+}
+
+// Check is a key, e.g. a user or channel, and its limit
+type Check struct {
+	Key   string
+	Limit Limit
+}
+
+// Limiter keeps the buckets of any number of keys
+type Limiter struct {
+	m       sync.Mutex
+	buckets map[string]*bucket
+}
+
+// New returns a limiter with all buckets full
+func New() *Limiter {
+	return &Limiter{buckets: make(map[string]*bucket)}
+}
+
+// Result is the outcome of Limiter.Allow
+type Result struct {
+	// OK is true if it may happen
+	OK bool
+	// Wait is how long until it may happen, if it may not now
+	Wait time.Duration
+	// Warn is true the first time it's not allowed since a bucket ran dry, so whoever's limited can be told once
+	Warn bool
+}
+
+// Allow takes a token from the buckets of all checks at now, if they all have one. If any doesn't, no tokens are
+// taken.
+func (l *Limiter) Allow(now time.Time, checks ...Check) Result {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if len(l.buckets) > maxBuckets {
+		l.forgetFull(now)
+	}
+	var r Result
+	buckets := make([]*bucket, 0, len(checks))
+	for _, c := range checks {
+		if c.Limit.unlimited() {
+			continue
+		}
+		b, ok := l.buckets[c.Key]
+		if !ok {
+			b = &bucket{tokens: float64(c.Limit.Burst), last: now}
+			l.buckets[c.Key] = b
+		}
+		b.limit = c.Limit
+		b.refill(now)
+		if b.tokens >= 1 {
+			b.warned = false
+		}
+		if w := b.wait(); w > r.Wait {
+			r.Wait = w
+		}
+		buckets = append(buckets, b)
+	}
+	if r.Wait > 0 {
+		for _, b := range buckets {
+			if b.tokens < 1 && !b.warned {
+				b.warned = true
+				r.Warn = true
+			}
+		}
+		return r
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	r.OK = true
+	return r
+}
+
+// forgetFull removes the buckets that have refilled completely at now. They're no different from new ones. The
+// caller must hold l.m.
+func (l *Limiter) forgetFull(now time.Time) {
+	for k, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(l.buckets, k)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	l := New()
+	now := time.Now()
+	perMinute := Limit{Burst: 3, Per: time.Minute}
+	user := Check{"alice", perMinute}
+
+	for i := 0; i < 3; i++ {
+		if r := l.Allow(now, user); !r.OK {
+			t.Fatalf("call %d wasn't allowed: %+v", i, r)
+		}
+	}
+	r := l.Allow(now, user)
+	if r.OK || !r.Warn || r.Wait != 20*time.Second {
+		t.Errorf("4th call = %+v, want a warning and 20s to wait", r)
+	}
+	if r := l.Allow(now.Add(10*time.Second), user); r.OK || r.Warn || r.Wait != 10*time.Second {
+		t.Errorf("5th call = %+v, want no warning and 10s to wait", r)
+	}
+	if r := l.Allow(now.Add(20*time.Second), user); !r.OK {
+		t.Errorf("call after a refill = %+v", r)
+	}
+	if r := l.Allow(now.Add(20*time.Second), user); r.OK || !r.Warn {
+		t.Errorf("call after running dry again = %+v, want a new warning", r)
+	}
+
+	// others have their own buckets, and unlimited checks are skipped
+	if r := l.Allow(now, Check{"bob", perMinute}, Check{"#chan", Limit{}}); !r.OK {
+		t.Errorf("bob = %+v", r)
+	}
+}
+
+func TestLimiter_Allow_all(t *testing.T) {
+	l := New()
+	now := time.Now()
+	channel := Check{"#chan", Limit{Burst: 1, Per: time.Minute}}
+	if r := l.Allow(now, Check{"alice", Limit{Burst: 5, Per: time.Minute}}, channel); !r.OK {
+		t.Fatalf("alice = %+v", r)
+	}
+	// the channel is out of tokens, so bob's aren't taken
+	bob := Check{"bob", Limit{Burst: 1, Per: time.Hour}}
+	if r := l.Allow(now, bob, channel); r.OK || r.Wait != time.Minute {
+		t.Errorf("bob in a busy channel = %+v", r)
+	}
+	if r := l.Allow(now, bob); !r.OK {
+		t.Errorf("bob lost a token to a call that wasn't allowed: %+v", r)
+	}
+}
+
+func TestLimiter_forgetFull(t *testing.T) {
+	l := New()
+	now := time.Now()
+	for i := 0; i <= maxBuckets; i++ {
+		l.Allow(now, Check{string(rune('a' + i)), Limit{Burst: 1, Per: time.Second}})
+	}
+	l.Allow(now.Add(time.Second), Check{"new", Limit{Burst: 1, Per: time.Second}})
+	if len(l.buckets) != 1 {
+		t.Errorf("%d buckets left, want only the new one", len(l.buckets))
+	}
+}
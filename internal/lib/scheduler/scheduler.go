@@ -0,0 +1,107 @@
+// Package scheduler runs jobs at a given time, or on a cron schedule. There's one scheduler, shared by the bot and its
+// plugins, so everything can be stopped together when the bot shuts down.
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron"
+)
+
+var ErrStopped = errors.New("scheduler is stopped")
+
+var (
+	m       sync.Mutex
+	s       *gocron.Scheduler
+	stopped bool
+)
+
+// Job is a scheduled job
+type Job struct {
+	j *gocron.Job
+}
+
+// NextRun returns when the job runs next
+func (j Job) NextRun() time.Time {
+	if j.j == nil {
+		return time.Time{}
+	}
+	return j.j.NextRun()
+}
+
+// scheduler returns the running scheduler, and starts it if it isn't already
+func scheduler() (*gocron.Scheduler, error) {
+	m.Lock()
+	defer m.Unlock()
+	if stopped {
+		return nil, ErrStopped
+	}
+	if s == nil {
+		s = gocron.NewScheduler(time.Local)
+		s.StartAsync()
+	}
+	return s, nil
+}
+
+// Cron runs f on the schedule in `expr`, a standard five field cron expression like "0 9 * * 1-5", in the time zone
+// loc. If loc is nil, local time is used.
+func Cron(expr string, loc *time.Location, f func()) (Job, error) {
+	sched, err := scheduler()
+	if err != nil {
+		return Job{}, err
+	}
+	if loc != nil {
+		expr = fmt.Sprintf("CRON_TZ=%s %s", loc, expr)
+	}
+	j, err := sched.Cron(expr).Do(f)
+	if err != nil {
+		return Job{}, fmt.Errorf("invalid schedule %q: %w", expr, err)
+	}
+	return Job{j}, nil
+}
+
+// At runs f once at t. If t has passed, f runs right away.
+func At(t time.Time, f func()) (Job, error) {
+	sched, err := scheduler()
+	if err != nil {
+		return Job{}, err
+	}
+	// a start in the past would be moved on by the interval, so run it a moment from now instead
+	if now := time.Now(); !t.After(now) {
+		t = now.Add(10 * time.Millisecond)
+	}
+	j, err := sched.Every(24 * time.Hour).StartAt(t).LimitRunsTo(1).Do(f)
+	if err != nil {
+		return Job{}, err
+	}
+	return Job{j}, nil
+}
+
+// Remove unschedules jobs
+func Remove(jobs ...Job) {
+	m.Lock()
+	sched := s
+	m.Unlock()
+	if sched == nil {
+		return
+	}
+	for _, j := range jobs {
+		if j.j != nil {
+			sched.RemoveByReference(j.j)
+		}
+	}
+}
+
+// Stop stops the scheduler, and waits for running jobs to finish. No jobs can be scheduled after that.
+func Stop() {
+	m.Lock()
+	defer m.Unlock()
+	stopped = true
+	if s != nil {
+		s.Stop()
+		s = nil
+	}
+}
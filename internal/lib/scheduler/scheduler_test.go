@@ -0,0 +1,67 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAt(t *testing.T) {
+	ran := make(chan time.Time, 2)
+	start := time.Now()
+	if _, err := At(start.Add(50*time.Millisecond), func() { ran <- time.Now() }); err != nil {
+		t.Fatal(err)
+	}
+	// the past is right away
+	if _, err := At(start.Add(-time.Hour), func() { ran <- time.Now() }); err != nil {
+		t.Fatal(err)
+	}
+	var times []time.Time
+	for len(times) < 2 {
+		select {
+		case at := <-ran:
+			times = append(times, at)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d of 2 jobs ran", len(times))
+		}
+	}
+	if times[1].Sub(start) < 50*time.Millisecond {
+		t.Errorf("job ran after %s, want at least 50ms", times[1].Sub(start))
+	}
+	select {
+	case <-ran:
+		t.Error("a job ran twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRemove(t *testing.T) {
+	ran := make(chan struct{}, 1)
+	j, err := At(time.Now().Add(100*time.Millisecond), func() { ran <- struct{}{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	Remove(j)
+	select {
+	case <-ran:
+		t.Error("removed job ran")
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestCron(t *testing.T) {
+	if _, err := Cron("not a schedule", nil, func() {}); err == nil {
+		t.Error("Cron() with an invalid expression succeeded")
+	}
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip(err)
+	}
+	j, err := Cron("0 9 * * *", loc, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Remove(j)
+	if next := j.NextRun().In(loc); next.Hour() != 9 || next.Minute() != 0 {
+		t.Errorf("NextRun() = %s, want 09:00 in Tokyo", next)
+	}
+}
@@ -0,0 +1,52 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/adamhassel/bender/internal/factoids"
+)
+
+var factoidsPage = Page(`{{define "title"}}Factoids{{end}}
+{{define "content"}}
+<form action="/factoids"><input name="prefix" value="{{.Prefix}}" placeholder="starts with"> <button>Filter</button></form>
+<p>{{len .Keys}} keywords</p>
+<ul>{{range .Keys}}<li><a href="/factoids/{{.}}">{{.}}</a></li>{{end}}</ul>
+{{end}}`)
+
+var factoidPage = Page(`{{define "title"}}{{.Key}}{{end}}
+{{define "content"}}
+<table>
+<tr><th>Fact</th><th>By</th><th>Created</th></tr>
+{{range .Facts}}<tr><td>{{.Value}}</td><td>{{.Origin}}</td><td>{{with .Created}}{{.Format "2006-01-02 15:04"}}{{end}}</td></tr>
+{{end}}</table>
+<p><a href="/factoids">All factoids</a></p>
+{{end}}`)
+
+// factoidsHandler lists keywords at /factoids, and shows the facts for a keyword at /factoids/<keyword>
+func factoidsHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/factoids/")
+	if r.URL.Path == "/factoids" || key == "" {
+		prefix := strings.ToLower(r.URL.Query().Get("prefix"))
+		Render(w, factoidsPage, struct {
+			Prefix string
+			Keys   []string
+		}{prefix, factoids.Keys(prefix)})
+		return
+	}
+	facts, err := factoids.Facts(key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	Render(w, factoidPage, struct {
+		Key   string
+		Facts any
+	}{key, facts})
+}
+
+func init() {
+	HandleFunc("/factoids", factoidsHandler)
+	HandleFunc("/factoids/", factoidsHandler)
+	AddNav("Factoids", "/factoids")
+}
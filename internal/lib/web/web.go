@@ -0,0 +1,237 @@
+// Package web implements the bot's built-in web server. The core and plugins register pages on it with Handle.
+package web
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/adamhassel/bender/internal/config"
+)
+
+// tokenCookie holds the token after logging in with the "token" query parameter, so links keep working
+const tokenCookie = "bender_token"
+
+// NavItem is a link in the menu on every page
+type NavItem struct {
+	Title string
+	Path  string
+}
+
+var (
+	mux = http.NewServeMux()
+	// public holds handlers that don't need authentication
+	public = http.NewServeMux()
+	pm     sync.Mutex
+	// private are the patterns registered on mux, which public ones may not overlap
+	private []string
+	nm      sync.RWMutex
+	nav     []NavItem
+)
+
+// Handle registers h for pattern, like http.Handle. Requests are authenticated before they reach h.
+func Handle(pattern string, h http.Handler) {
+	addPrivate(pattern)
+	mux.Handle(pattern, h)
+}
+
+// HandleFunc registers h for pattern, like http.HandleFunc. Requests are authenticated before they reach h.
+func HandleFunc(pattern string, h func(http.ResponseWriter, *http.Request)) {
+	addPrivate(pattern)
+	mux.HandleFunc(pattern, h)
+}
+
+// addPrivate remembers that pattern needs authentication
+func addPrivate(pattern string) {
+	pm.Lock()
+	defer pm.Unlock()
+	private = append(private, pattern)
+}
+
+// overlaps returns true if some paths match both patterns. "/" matches anything, and isn't counted.
+func overlaps(a, b string) bool {
+	subtree := func(p string) bool { return p != "/" && strings.HasSuffix(p, "/") }
+	return a == b || subtree(a) && strings.HasPrefix(b, a) || subtree(b) && strings.HasPrefix(a, b)
+}
+
+// HandlePublic registers h for pattern, like http.Handle, without authentication. It's for things like redirects that
+// have to work for anyone. Anything secret must use Handle. Patterns that would let pages that need authentication be
+// reached without it, like "/", or one overlapping a pattern registered with Handle, are refused.
+func HandlePublic(pattern string, h http.Handler) error {
+	if pattern == "/" || pattern == "" {
+		return fmt.Errorf("can't serve %q without authentication", pattern)
+	}
+	pm.Lock()
+	defer pm.Unlock()
+	for _, p := range private {
+		if overlaps(pattern, p) {
+			return fmt.Errorf("can't serve %s without authentication, it overlaps %s", pattern, p)
+		}
+	}
+	public.Handle(pattern, h)
+	return nil
+}
+
+// AddNav adds a link to the menu on every page
+func AddNav(title, path string) {
+	nm.Lock()
+	defer nm.Unlock()
+	nav = append(nav, NavItem{title, path})
+}
+
+func navItems() []NavItem {
+	nm.RLock()
+	defer nm.RUnlock()
+	return append([]NavItem(nil), nav...)
+}
+
+var layout = template.Must(template.New("layout").Funcs(template.FuncMap{"nav": navItems}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{template "title" .}} - bender</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+nav a { margin-right: 1em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.1em 0.6em; text-align: left; vertical-align: top; }
+.log td:first-child { white-space: nowrap; color: #888; }
+.log tr:target { background: #ffa; }
+</style>
+</head>
+<body>
+<nav>{{range nav}}<a href="{{.Path}}">{{.Title}}</a>{{end}}</nav>
+<h1>{{template "title" .}}</h1>
+{{template "content" .}}
+</body>
+</html>
+`))
+
+// Page returns a template for a page with the common layout. `content` must define the templates "title" and
+// "content".
+func Page(content string) *template.Template {
+	return template.Must(template.Must(layout.Clone()).Parse(content))
+}
+
+// Render writes the page t with data
+func Render(w http.ResponseWriter, t *template.Template, data any) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		log.WithError(err).Error("error rendering page")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// equal compares secrets in constant time. Nothing is equal to an empty secret.
+func equal(given, secret string) bool {
+	return secret != "" && subtle.ConstantTimeCompare([]byte(given), []byte(secret)) == 1
+}
+
+// authorized checks the token or basic auth credentials of r. It returns true, and whether the token was given as a
+// query parameter, if they're valid.
+func authorized(conf config.HTTP, r *http.Request) (ok bool, fromQuery bool) {
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found && equal(token, conf.Token) {
+		return true, false
+	}
+	if equal(r.URL.Query().Get("token"), conf.Token) {
+		return true, true
+	}
+	if c, err := r.Cookie(tokenCookie); err == nil && equal(c.Value, conf.Token) {
+		return true, false
+	}
+	if user, pass, found := r.BasicAuth(); found {
+		return equal(pass, conf.Users[user]), false
+	}
+	return false, false
+}
+
+// authenticate wraps h, and only lets authorized requests through
+func authenticate(conf config.HTTP, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, fromQuery := authorized(conf, r)
+		if !ok {
+			if len(conf.Users) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="bender", charset="UTF-8"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if fromQuery {
+			http.SetCookie(w, &http.Cookie{
+				Name:     tokenCookie,
+				Value:    conf.Token,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// router sends requests to public handlers, or to authenticated ones. If both match, the most specific pattern wins,
+// and authentication wins a tie. The catch-all "/" never beats a public handler.
+func router(conf config.HTTP) http.Handler {
+	authenticated := authenticate(conf, mux)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pp := public.Handler(r); pp != "" {
+			if _, mp := mux.Handler(r); mp == "" || mp == "/" || len(pp) > len(mp) {
+				public.ServeHTTP(w, r)
+				return
+			}
+		}
+		authenticated.ServeHTTP(w, r)
+	})
+}
+
+// Serve runs the web server configured in ctx until ctx is done. It does nothing if no listen address is configured.
+func Serve(ctx context.Context) error {
+	conf := config.FromContext(ctx).Main.HTTP
+	if conf.Listen == "" {
+		return nil
+	}
+	if conf.Token == "" && len(conf.Users) == 0 {
+		return errors.New("the web server needs a token or users to authenticate requests")
+	}
+	srv := &http.Server{
+		Addr:              conf.Listen,
+		Handler:           router(conf),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(sctx)
+	}()
+	log.Infof("web server listening on %s", conf.Listen)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+var indexPage = Page(`{{define "title"}}Bender{{end}}
+{{define "content"}}<ul>{{range nav}}<li><a href="{{.Path}}">{{.Title}}</a></li>{{end}}</ul>{{end}}`)
+
+func init() {
+	HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		Render(w, indexPage, nil)
+	})
+}
@@ -0,0 +1,137 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adamhassel/bender/internal/config"
+)
+
+func TestAuthenticate(t *testing.T) {
+	conf := config.HTTP{Token: "s3cret", Users: map[string]string{"alice": "pw"}}
+	h := authenticate(conf, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		name       string
+		req        func() *http.Request
+		want       int
+		wantCookie bool
+	}{
+		{
+			name: "nothing",
+			req:  func() *http.Request { return httptest.NewRequest("GET", "/status", nil) },
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "bearer",
+			req: func() *http.Request {
+				r := httptest.NewRequest("GET", "/status", nil)
+				r.Header.Set("Authorization", "Bearer s3cret")
+				return r
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "wrong bearer",
+			req: func() *http.Request {
+				r := httptest.NewRequest("GET", "/status", nil)
+				r.Header.Set("Authorization", "Bearer s3cre")
+				return r
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name:       "query",
+			req:        func() *http.Request { return httptest.NewRequest("GET", "/status?token=s3cret", nil) },
+			want:       http.StatusOK,
+			wantCookie: true,
+		},
+		{
+			name: "cookie",
+			req: func() *http.Request {
+				r := httptest.NewRequest("GET", "/status", nil)
+				r.AddCookie(&http.Cookie{Name: tokenCookie, Value: "s3cret"})
+				return r
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "basic auth",
+			req: func() *http.Request {
+				r := httptest.NewRequest("GET", "/status", nil)
+				r.SetBasicAuth("alice", "pw")
+				return r
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "unknown user",
+			req: func() *http.Request {
+				r := httptest.NewRequest("GET", "/status", nil)
+				r.SetBasicAuth("bob", "")
+				return r
+			},
+			want: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, tt.req())
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if got := len(w.Result().Cookies()) > 0; got != tt.wantCookie {
+				t.Errorf("cookie set = %v, want %v", got, tt.wantCookie)
+			}
+		})
+	}
+}
+
+func TestAuthenticate_noToken(t *testing.T) {
+	// without a token, an empty one mustn't let anyone in
+	h := authenticate(config.HTTP{Users: map[string]string{"alice": "pw"}}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/status?token=", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Error("no basic auth challenge")
+	}
+}
+
+func TestRouter(t *testing.T) {
+	teapot := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	if err := HandlePublic("/test-public/", teapot); err != nil {
+		t.Fatal(err)
+	}
+	HandleFunc("/test-private/", func(w http.ResponseWriter, r *http.Request) {})
+	for _, pattern := range []string{"/", "/test-private/", "/test-private/sub/", "/test-private/page"} {
+		if err := HandlePublic(pattern, teapot); err == nil {
+			t.Errorf("HandlePublic(%q) succeeded", pattern)
+		}
+	}
+	// registered after a public pattern it's under
+	HandleFunc("/test-public/private/", func(w http.ResponseWriter, r *http.Request) {})
+	h := router(config.HTTP{Token: "s3cret"})
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/test-public/abc", http.StatusTeapot},
+		{"/test-public", http.StatusMovedPermanently},
+		{"/factoids", http.StatusUnauthorized},
+		{"/test-private/abc", http.StatusUnauthorized},
+		{"/test-public/private/abc", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+}
@@ -3,71 +3,329 @@ package main
 import (
 	"errors"
 	"fmt"
-	"io"
 	"os"
-	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/go-co-op/gocron"
 	log "github.com/sirupsen/logrus"
 	irc "github.com/thoj/go-ircevent"
+
+	"github.com/adamhassel/bender/internal/helpers"
+	bot "github.com/adamhassel/bender/internal/lib/irc"
+	"github.com/adamhassel/bender/internal/lib/scheduler"
 )
 
-// Matchers lists exported matchers in the plugin
-var Matchers = []string{"Chanlog"}
+// Events lists the IRC events the plugin handles. Messages are handled as events rather than with a matcher, so
+// commands are logged too.
+var Events = map[string]string{
+	"PRIVMSG":     "Event",
+	"CTCP_ACTION": "Event",
+	"JOIN":        "Event",
+	"PART":        "Event",
+	"QUIT":        "Event",
+	"NICK":        "Event",
+	"KICK":        "Event",
+	"TOPIC":       "Event",
+	"MODE":        "Event",
+	"353":         "Event", // RPL_NAMREPLY, to know who's in a channel
+	"366":         "Event", // RPL_ENDOFNAMES
+	"001":         "Event", // RPL_WELCOME, to forget the channels when reconnecting
+}
+
+// logKey identifies a channel on a network
+type logKey struct {
+	network string
+	channel string
+}
 
-var loggers map[string]*log.Logger
+// channelLog is the logger for a channel, and the file it's currently logging to
+type channelLog struct {
+	*log.Logger
+	file *os.File
+	path string
+}
+
+var loggers map[logKey]*channelLog
 var lm sync.Mutex
-var activeRotator *gocron.Scheduler
+
+// rotatorJobs are the scheduled rotation and expiry jobs
+var rotatorJobs []scheduler.Job
 var logroot string
+var format string
 
-// A logrus formatter
-type IRCFormatter struct{}
-type IRCSystemFormatter struct{}
+// chanlist holds the channels to log, either as "#channel" on any network, or "network/#channel"
+var chanlist []string
 
-// Format implements the logrus.Formatter interface
-func (*IRCFormatter) Format(entry *log.Entry) ([]byte, error) {
-	// make sure all fields are present
-	user, userok := entry.Data["user"]
-	if !userok {
-		return nil, errors.New("required fields missing")
-	}
-	var msg string
-	action, ok := entry.Data["action"]
-	if ok {
-		if a, ok := action.(bool); ok && a {
-			msg = fmt.Sprintf("%s *** %s %s\n", entry.Time.Format("15:04:05"), user, entry.Message)
+// members holds the nicks in each logged channel, so quits and nick changes can be logged where they're seen
+var members = make(map[logKey]helpers.Set[string])
+
+// listing holds the channels whose NAMES reply is being read. The first 353 of a reply starts the channel's members
+// over, and 366 ends it.
+var listing = make(map[logKey]bool)
+
+// forget drops the members of the channel in k. The caller should lock!
+func forget(k logKey) {
+	delete(members, k)
+	delete(listing, k)
+}
+
+// isBot returns true if nick is the bot's own nick on the connection e came from
+func isBot(e *irc.Event, nick string) bool {
+	return strings.EqualFold(nick, e.Connection.GetNick())
+}
+
+// keyFor returns the log key for `channel` on the network c is connected to. Channel names are case insensitive.
+func keyFor(c *irc.Connection, channel string) logKey {
+	return logKey{network: bot.Network(c), channel: strings.ToLower(channel)}
+}
+
+// logged returns true if the channel in k is configured for logging
+func logged(k logKey) bool {
+	for _, entry := range chanlist {
+		network, channel, found := strings.Cut(entry, "/")
+		if !found || strings.ContainsRune("#&+!", rune(entry[0])) {
+			network, channel = "", entry
+		}
+		if strings.EqualFold(channel, k.channel) && (network == "" || strings.EqualFold(network, k.network)) {
+			return true
 		}
-	} else {
-		msg = fmt.Sprintf("%s < %s> %s\n", entry.Time.Format("15:04:05"), user, entry.Message)
 	}
-	return []byte(msg), nil
+	return false
 }
 
-// Format implements the logrus.Formatter interface
-func (*IRCSystemFormatter) Format(entry *log.Entry) ([]byte, error) {
-	return []byte(fmt.Sprintf("%s  *****  %s", entry.Time.Format("15:04:05"), entry.Message)), nil
+// getLogger returns the logger for a channel, opening its log file the first time. It returns false if the channel
+// isn't logged. The caller should lock!
+func getLogger(k logKey) (*channelLog, bool) {
+	if l, ok := loggers[k]; ok {
+		return l, true
+	}
+	if !logged(k) {
+		return nil, false
+	}
+	l := &channelLog{Logger: log.New()}
+	formatter, err := newFormatter(format)
+	if err != nil {
+		log.WithError(err).Error("couldn't open channel log")
+		return nil, false
+	}
+	l.SetFormatter(formatter)
+	if err := l.open(k, time.Now()); err != nil {
+		log.WithError(err).WithField("channel", k.channel).Error("couldn't open channel log")
+		return nil, false
+	}
+	if loggers == nil {
+		loggers = make(map[logKey]*channelLog)
+	}
+	loggers[k] = l
+	return l, true
 }
 
-// Chanlog is called for every message, and logs it if it's supposed to
-func Chanlog(msg string, e *irc.Event) (string, bool) {
-	channel := e.Arguments[0]
+// logOutbound logs messages sent by the bot itself
+func logOutbound(o bot.Outbound) {
+	lm.Lock()
+	defer lm.Unlock()
+	k := keyFor(o.Conn, o.Target)
+	logger, ok := getLogger(k)
+	if !ok {
+		return
+	}
+	event := evMessage
+	if o.Action {
+		event = evAction
+	}
+	now := time.Now()
+	msg := redact(o.Message)
+	logger.WithFields(log.Fields{
+		"event":   event,
+		"network": k.network,
+		"channel": o.Target,
+		"user":    o.Conn.GetNick(),
+	}).WithTime(now).Info(msg)
+	indexLine(record{Time: now, Network: k.network, Channel: k.channel, Nick: o.Conn.GetNick(), Text: msg,
+		Action: o.Action})
+}
 
-	// Check if we're configured to log this channel
+// indexLine adds a message to the search index, if it's on
+func indexLine(r record) {
+	if idx == nil {
+		return
+	}
+	if err := idx.add(r); err != nil {
+		log.WithError(err).Error("couldn't index line")
+	}
+}
+
+// Event logs messages, joins, parts, quits, nick changes, kicks, topic changes and modes in logged channels
+func Event(e *irc.Event) {
 	lm.Lock()
 	defer lm.Unlock()
-	logger, ok := loggers[channel]
+	switch e.Code {
+	case "PRIVMSG", "CTCP_ACTION":
+		channel := e.Arguments[0]
+		event := evMessage
+		if e.Code == "CTCP_ACTION" {
+			event = evAction
+		}
+		k := keyFor(e.Connection, channel)
+		if logEvent(k, e, event, channel, "", e.Message()) {
+			r := record{Time: eventTime(e), Network: k.network, Channel: k.channel, Nick: e.Nick,
+				Text: censor(k.network, e.Nick, event, e.Message()), Action: event == evAction}
+			// like in the logs, those who opted out are kept without their hostmask
+			if !optedOut(k.network, e.Nick) {
+				r.Host, r.Account = e.User+"@"+e.Host, e.Tags["account"]
+			}
+			indexLine(r)
+		}
+	case "001":
+		network := bot.Network(e.Connection)
+		for k := range members {
+			if k.network == network {
+				forget(k)
+			}
+		}
+	case "353":
+		if len(e.Arguments) < 4 {
+			return
+		}
+		k := keyFor(e.Connection, e.Arguments[2])
+		if !logged(k) {
+			return
+		}
+		if !listing[k] || members[k] == nil {
+			members[k], listing[k] = helpers.NewSet[string](), true
+		}
+		for _, entry := range strings.Fields(e.Message()) {
+			members[k].Add(namesNick(entry))
+		}
+	case "366":
+		if len(e.Arguments) > 1 {
+			delete(listing, keyFor(e.Connection, e.Arguments[1]))
+		}
+	case "JOIN":
+		channel := e.Arguments[0]
+		k := keyFor(e.Connection, channel)
+		if !logged(k) {
+			return
+		}
+		if isBot(e, e.Nick) {
+			forget(k)
+		}
+		if members[k] == nil {
+			members[k] = helpers.NewSet[string]()
+		}
+		members[k].Add(e.Nick)
+		logEvent(k, e, evJoin, channel, "", "")
+		announce(e)
+	case "PART":
+		channel := e.Arguments[0]
+		k := keyFor(e.Connection, channel)
+		if isBot(e, e.Nick) {
+			forget(k)
+		} else {
+			members[k].Delete(e.Nick)
+		}
+		logEvent(k, e, evPart, channel, "", reason(e, 1))
+	case "KICK":
+		if len(e.Arguments) < 2 {
+			return
+		}
+		channel, victim := e.Arguments[0], e.Arguments[1]
+		k := keyFor(e.Connection, channel)
+		if isBot(e, victim) {
+			forget(k)
+		} else {
+			members[k].Delete(victim)
+		}
+		logEvent(k, e, evKick, channel, victim, reason(e, 2))
+	case "TOPIC":
+		channel := e.Arguments[0]
+		logEvent(keyFor(e.Connection, channel), e, evTopic, channel, "", e.Message())
+	case "MODE":
+		channel := e.Arguments[0]
+		logEvent(keyFor(e.Connection, channel), e, evMode, channel, "", strings.Join(e.Arguments[1:], " "))
+	case "QUIT":
+		network := bot.Network(e.Connection)
+		for k, nicks := range members {
+			if k.network == network && nicks.Exists(e.Nick) {
+				nicks.Delete(e.Nick)
+				logEvent(k, e, evQuit, k.channel, "", reason(e, 0))
+			}
+		}
+	case "NICK":
+		network := bot.Network(e.Connection)
+		// opting out follows the user, and whoever takes the old nick next is logged
+		if optedOut(network, e.Nick) && !strings.EqualFold(e.Nick, e.Message()) {
+			if err := setOptout(network, e.Message(), true); err != nil {
+				log.WithError(err).Error("couldn't save log opt-out")
+			}
+			if err := setOptout(network, e.Nick, false); err != nil {
+				log.WithError(err).Error("couldn't save log opt-out")
+			}
+		}
+		for k, nicks := range members {
+			if k.network == network && nicks.Exists(e.Nick) {
+				nicks.Delete(e.Nick)
+				nicks.Add(e.Message())
+				logEvent(k, e, evNick, k.channel, e.Message(), "")
+			}
+		}
+	}
+}
+
+// namesNick returns the nick in an entry of a NAMES reply, without status prefixes, and without the user and host the
+// bot asks for with userhost-in-names. Members are tracked here, not with the bot's channel state, because the state
+// may already have forgotten who quit or changed nick by the time the event gets here.
+func namesNick(entry string) string {
+	nick := strings.TrimLeft(entry, "~&@%+")
+	if i := strings.IndexByte(nick, '!'); i >= 0 {
+		nick = nick[:i]
+	}
+	return nick
+}
+
+// reason returns the argument at position i of e, which is the reason given for a PART, KICK or QUIT, if present
+func reason(e *irc.Event, i int) string {
+	if len(e.Arguments) > i {
+		return e.Arguments[i]
+	}
+	return ""
+}
+
+// logEvent logs an event of kind `event` from e in the channel in k, and returns true if it's logged. Target is the
+// nick affected by a kick or nick change, and msg is the message, reason, topic or modes, depending on the event. The
+// caller should lock!
+func logEvent(k logKey, e *irc.Event, event, channel, target, msg string) bool {
+	logger, ok := getLogger(k)
 	if !ok {
-		return "", false
+		return false
+	}
+	fields := log.Fields{
+		"event":   event,
+		"network": k.network,
+		"channel": channel,
+		"user":    e.Nick,
 	}
-	fields := log.Fields{"user": e.Nick}
-	if e.Code == "CTCP_ACTION" {
-		fields["action"] = true
+	if !optedOut(k.network, e.Nick) {
+		fields["hostmask"] = e.Source
 	}
-	logger.WithFields(fields).Info(msg)
-	return "", false
+	if target != "" {
+		fields["target"] = target
+	}
+	if len(e.Tags) > 0 {
+		fields["tags"] = e.Tags
+	}
+	logger.WithFields(fields).WithTime(eventTime(e)).Info(censor(k.network, e.Nick, event, msg))
+	return true
+}
+
+// eventTime returns the time e happened, using the IRCv3 server-time tag if the server sent it
+func eventTime(e *irc.Event) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, e.Tags["time"]); err == nil {
+		return t.Local()
+	}
+	return time.Now()
 }
 
 // Configure configures the plugin
@@ -76,7 +334,7 @@ func Configure(c map[interface{}]interface{}) error {
 	if !ok {
 		return nil
 	}
-	var chanlist []string
+	chanlist = nil
 	for _, ci := range channels.([]interface{}) {
 		c, ok := ci.(string)
 		if !ok {
@@ -100,109 +358,128 @@ func Configure(c map[interface{}]interface{}) error {
 			return fmt.Errorf("expected string logroot, got %T", lr)
 		}
 	}
-
-	for _, channel := range chanlist {
-		if loggers == nil {
-			loggers = make(map[string]*log.Logger, len(chanlist))
-		}
-		logger := log.New()
-		if err := configureLogging(channel, logger); err != nil {
-			return err
+	if err := configurePaths(c); err != nil {
+		return err
+	}
+	format = ""
+	if f, ok := c["format"]; ok {
+		if format, ok = f.(string); !ok {
+			return fmt.Errorf("expected string format, got %T", f)
 		}
 	}
-	configureRotator()
-	dateChangeLogger()
-	return nil
-}
-
-// makeDatePath returns a path from the current date as "year/month"
-func makeDatePath() string {
-	now := time.Now()
-	return filepath.Join(now.Format("2006"), now.Format("01"))
-}
-
-// getLogFileHandle will return a handle to a file named and located for logging
-func getLogfilehandle(logroot, channel string) (*os.File, error) {
-	logfile := filepath.Join(logroot, makeDatePath(), channel+".log")
-	if err := os.MkdirAll(path.Dir(logfile), 0700); err != nil {
-		return nil, err
+	if _, err := newFormatter(format); err != nil {
+		return err
 	}
-	return os.OpenFile(logfile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-}
 
-func rotate(channel string) error {
-	lm.Lock()
-	logger, ok := loggers[channel]
-	if !ok || logger == nil {
-		log.WithField("channel", channel).Info("no logger defined for channel, so not stopping")
-		lm.Unlock()
-		return configureLogging(channel, logger)
+	if err := configurePrivacy(c); err != nil {
+		return err
 	}
-	if closer, ok := logger.Out.(io.Closer); ok {
-		closer.Close()
+	if err := configureIndex(c); err != nil {
+		return err
 	}
-	lm.Unlock()
-	// Replace the destination
-	return configureLogging(channel, logger)
+	go expire()
+
+	configureRotator()
+	dateChangeLogger()
+	bot.OnOutbound(logOutbound)
+	registerWeb()
+	return nil
 }
 
-// configure logging for a channel.
-func configureLogging(channel string, logger *log.Logger) error {
-	if logroot == "" {
-		return errors.New("logroot undefined. Did you run the \"Configure\" function?")
+// rotate closes the log file for a channel, and opens the one for the current rotation period. If compression is on,
+// the old file is gzipped. The caller should lock!
+func (l *channelLog) rotate(k logKey) error {
+	old := l.path
+	if l.file != nil {
+		l.file.Close()
 	}
-	file, err := getLogfilehandle(logroot, channel)
-	if err != nil {
-		return fmt.Errorf("couldn't open logfile at %s: %w", channel, err)
+	if err := l.open(k, time.Now()); err != nil {
+		return err
+	}
+	if compress && old != l.path {
+		go func() {
+			if err := gzipFile(old); err != nil {
+				log.WithError(err).WithField("file", old).Error("couldn't compress rotated log")
+			}
+		}()
 	}
-	lm.Lock()
-	logger.Out = file
-	logger.SetFormatter(new(IRCFormatter))
-	loggers[channel] = logger
-	lm.Unlock()
 	return nil
 }
 
 func rotateAll() error {
-	for channel := range loggers {
-		if e := rotate(channel); e != nil {
+	lm.Lock()
+	defer lm.Unlock()
+	for k, l := range loggers {
+		if e := l.rotate(k); e != nil {
 			return e
 		}
 	}
 	return nil
 }
 
-// configureRotator will monitor time and trigger the rotation at midnight on the 1st of a month
+// Shutdown flushes and closes all log files, and the index. It's called when the bot shuts down.
+func Shutdown() error {
+	lm.Lock()
+	defer lm.Unlock()
+	var errs []error
+	for k, l := range loggers {
+		if err := l.file.Sync(); err != nil {
+			errs = append(errs, err)
+		}
+		if err := l.file.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(loggers, k)
+	}
+	if idx != nil {
+		if err := idx.close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// configureRotator will monitor time and trigger the rotation at midnight at the start of each rotation period
 func configureRotator() {
-	if activeRotator != nil {
-		activeRotator.Stop()
-		activeRotator.Clear()
+	scheduler.Remove(rotatorJobs...)
+	rotatorJobs = nil
+	schedule := "0 0 1 * *"
+	switch rotateEvery {
+	case daily:
+		schedule = "0 0 * * *"
+	case weekly:
+		schedule = "0 0 * * 1"
 	}
-	activeRotator = gocron.NewScheduler(time.Local)
-	if _, err := activeRotator.Every(1).Month(1).At("00:00").Do(rotateAll); err != nil {
+	j, err := scheduler.Cron(schedule, nil, func() {
+		if err := rotateAll(); err != nil {
+			log.WithError(err).Error("couldn't rotate logs")
+		}
+	})
+	if err != nil {
 		log.WithError(err).Error("couldn't run rotator")
 		return
 	}
-	activeRotator.StartAsync()
+	rotatorJobs = append(rotatorJobs, j)
+	if j, err = scheduler.Cron("5 0 * * *", nil, expire); err != nil {
+		log.WithError(err).Error("couldn't run log expiry")
+		return
+	}
+	rotatorJobs = append(rotatorJobs, j)
 }
 
 // dateChangeLogger will make a note in the logfile whenever the date changes. Run once.
 func dateChangeLogger() {
-	dl := gocron.NewScheduler(time.Local)
-	if _, err := dl.Every(1).Day().At("00:00").Do(logDateChange); err != nil {
-		log.WithError(err).Error("couldn't run rotator")
-		return
+	if _, err := scheduler.Cron("0 0 * * *", nil, logDateChange); err != nil {
+		log.WithError(err).Error("couldn't run date change logger")
 	}
-	dl.StartAsync()
 }
 
-// logDateChange will log a date change for every configured channel
+// logDateChange will log a date change for every open channel log
 func logDateChange() {
 	lm.Lock()
-	for _, logger := range loggers {
-		logger.SetFormatter(new(IRCSystemFormatter))
-		logger.Infof("Date changed to %s", time.Now().Format("Jan 02 2006"))
-		logger.SetFormatter(new(IRCFormatter))
+	for k, l := range loggers {
+		l.WithFields(log.Fields{"event": evDayChange, "network": k.network, "channel": k.channel}).
+			Infof("Date changed to %s", time.Now().Format("Jan 02 2006"))
 	}
 	defer lm.Unlock()
 }
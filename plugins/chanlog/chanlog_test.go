@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	irc "github.com/thoj/go-ircevent"
+
+	"github.com/adamhassel/bender/internal/helpers"
+)
+
+func Test_namesNick(t *testing.T) {
+	for entry, want := range map[string]string{
+		"alice":                      "alice",
+		"@+bob":                      "bob",
+		"@carol!~carol@host.example": "carol",
+		"dave!dave@127.0.0.1":        "dave",
+	} {
+		if got := namesNick(entry); got != want {
+			t.Errorf("namesNick(%q) = %q, want %q", entry, got, want)
+		}
+	}
+}
+
+func TestEvent_members(t *testing.T) {
+	logroot, chanlist, notice = t.TempDir(), []string{"#go"}, ""
+	defer func() {
+		for _, l := range loggers {
+			l.file.Close()
+		}
+		logroot, chanlist, loggers = "", nil, nil
+		members, listing = make(map[logKey]helpers.Set[string]), make(map[logKey]bool)
+	}()
+	c := irc.IRC("Bender", "bender")
+	k := keyFor(c, "#go")
+	send := func(code, nick string, args ...string) {
+		Event(&irc.Event{Code: code, Nick: nick, Arguments: args, Connection: c})
+	}
+	in := func(nick string) bool {
+		return members[k].Exists(nick)
+	}
+
+	send("JOIN", "Bender", "#go")
+	send("353", "", "Bender", "=", "#go", "Bender @alice bob")
+	send("353", "", "Bender", "=", "#go", "carol")
+	send("366", "", "Bender", "#go", "End of /NAMES list.")
+	if !in("alice") || !in("bob") || !in("carol") {
+		t.Fatalf("members = %v after NAMES", members[k])
+	}
+
+	// bob leaves while the bot is kicked, and isn't there when it's back
+	send("KICK", "alice", "#go", "Bender", "out")
+	if _, ok := members[k]; ok {
+		t.Error("members kept after the bot was kicked")
+	}
+	send("JOIN", "Bender", "#go")
+	send("353", "", "Bender", "=", "#go", "Bender @alice carol")
+	send("366", "", "Bender", "#go", "End of /NAMES list.")
+	if in("bob") || !in("carol") {
+		t.Errorf("members = %v after rejoining", members[k])
+	}
+
+	// a new NAMES reply starts over
+	send("353", "", "Bender", "=", "#go", "Bender alice")
+	send("366", "", "Bender", "#go", "End of /NAMES list.")
+	if in("carol") || !in("alice") {
+		t.Errorf("members = %v after a new NAMES reply", members[k])
+	}
+
+	send("PART", "Bender", "#go", "bye")
+	if _, ok := members[k]; ok {
+		t.Error("members kept after the bot left")
+	}
+	send("JOIN", "Bender", "#go")
+	send("001", "", "Bender", "Welcome")
+	if _, ok := members[k]; ok {
+		t.Error("members kept after reconnecting")
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	irc "github.com/thoj/go-ircevent"
+
+	"github.com/adamhassel/bender/internal/lib/commands"
+	bot "github.com/adamhassel/bender/internal/lib/irc"
+)
+
+// maxGrepResults is the maximum number of lines !grep sends
+const maxGrepResults = 100
+
+// scope returns a filter for records from the channel e was sent to. In private, it's the channels on the network
+// that the caller is in, so no one reads the logs of channels they're not in. `channel` overrides the channel, if set,
+// and the caller is in it.
+func scope(e *irc.Event, channel string) (func(record) bool, error) {
+	var here string
+	if !strings.EqualFold(e.Arguments[0], e.Connection.GetNick()) {
+		here = e.Arguments[0]
+	}
+	return scopeFor(bot.Network(e.Connection), here, bot.UserChannels(e.Connection, e.Nick), channel)
+}
+
+// scopeFor returns a filter for records on network from channel, or `here`, where the command was given, or, if that's
+// empty because it was given in private, from the channels in `mine`, the channels the caller is in
+func scopeFor(network, here string, mine []string, channel string) (func(record) bool, error) {
+	var channels []string
+	switch {
+	case channel != "":
+		if !strings.EqualFold(channel, here) && !slices.ContainsFunc(mine, func(c string) bool {
+			return strings.EqualFold(c, channel)
+		}) {
+			return nil, fmt.Errorf("You're not in %s", channel)
+		}
+		channels = []string{channel}
+	case here != "":
+		channels = []string{here}
+	default:
+		channels = mine
+	}
+	allowed := make(map[string]bool, len(channels))
+	for _, c := range channels {
+		allowed[strings.ToLower(c)] = true
+	}
+	return func(r record) bool {
+		return r.Network == network && allowed[r.Channel]
+	}, nil
+}
+
+// parseSince parses a duration like "3h", "2d" or "1w" as that long ago, or a date as "2006-01-02"
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if len(s) < 2 {
+		return time.Time{}, fmt.Errorf("invalid since: %q", s)
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 {
+		return time.Time{}, fmt.Errorf("invalid since: %q", s)
+	}
+	switch s[len(s)-1] {
+	case 'm':
+		return now.Add(-time.Duration(n) * time.Minute), nil
+	case 'h':
+		return now.Add(-time.Duration(n) * time.Hour), nil
+	case 'd':
+		return now.AddDate(0, 0, -n), nil
+	case 'w':
+		return now.AddDate(0, 0, -7*n), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid since: %q, use e.g. 3h, 2d, 1w or 2006-01-02", s)
+}
+
+// Grep searches the channel log for a regular expression, and sends the matching lines to the caller in private.
+// Filters are "nick:<nick>", "since:<when>" and "channel:<channel>", for a channel the caller is in.
+func Grep(args commands.Args, e *irc.Event) (string, bool) {
+	if idx == nil {
+		return "Searching is turned off", false
+	}
+	re, err := regexp.Compile(args.Get("regex"))
+	if err != nil {
+		return fmt.Sprintf("Invalid regular expression: %s", err), false
+	}
+	filters, err := commands.Tokenize(args.Get("filters"))
+	if err != nil {
+		return err.Error(), false
+	}
+	var nick, channel string
+	var since time.Time
+	for _, f := range filters {
+		key, value, _ := strings.Cut(f, ":")
+		switch key {
+		case "nick":
+			nick = value
+		case "channel":
+			channel = value
+		case "since":
+			if since, err = parseSince(value, time.Now()); err != nil {
+				return err.Error(), false
+			}
+		default:
+			return fmt.Sprintf("Unknown filter %q. I know nick:, since: and channel:", f), false
+		}
+	}
+	inScope, err := scope(e, channel)
+	if err != nil {
+		return err.Error(), false
+	}
+	results, total := idx.grep(re, func(r record) bool {
+		// don't find the !grep itself
+		if r.Nick == e.Nick && r.Text == e.Message() {
+			return false
+		}
+		return inScope(r) && (nick == "" || strings.EqualFold(r.Nick, nick)) && !r.Time.Before(since)
+	}, maxGrepResults)
+	if total == 0 {
+		return "No matches", false
+	}
+	lines := make([]string, len(results))
+	for i, r := range results {
+		lines[i] = r.String()
+	}
+	if total > len(results) {
+		lines = append(lines, fmt.Sprintf("... and %d older matches not shown", total-len(results)))
+	}
+	go bot.SendPaged(e.Connection, e.Nick, e.Nick, lines)
+	if strings.EqualFold(e.Arguments[0], e.Connection.GetNick()) {
+		return "", false
+	}
+	return fmt.Sprintf("%s: found %d lines, sending them to you in private", e.Nick, total), false
+}
+
+// Last tells what a nick last said in the channel
+func Last(args commands.Args, e *irc.Event) (string, bool) {
+	if idx == nil {
+		return "Searching is turned off", false
+	}
+	nick := args.Get("nick")
+	inScope, err := scope(e, "")
+	if err != nil {
+		return err.Error(), false
+	}
+	r, ok := idx.last(func(r record) bool { return inScope(r) && strings.EqualFold(r.Nick, nick) })
+	if !ok {
+		return fmt.Sprintf("I haven't seen %s say anything", nick), false
+	}
+	return r.String(), false
+}
+
+// Quote tells a random line from the channel log, either from anyone (no nick, or "random") or from a nick
+func Quote(args commands.Args, e *irc.Event) (string, bool) {
+	if idx == nil {
+		return "Searching is turned off", false
+	}
+	who := args.Get("who")
+	inScope, err := scope(e, "")
+	if err != nil {
+		return err.Error(), false
+	}
+	r, ok := idx.random(func(r record) bool {
+		return inScope(r) && (who == "" || who == "random" || strings.EqualFold(r.Nick, who))
+	})
+	if !ok {
+		return "I have nothing to quote", false
+	}
+	return r.String(), false
+}
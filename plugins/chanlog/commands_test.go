@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func Test_scopeFor(t *testing.T) {
+	records := []record{
+		{Network: "libera", Channel: "#go"},
+		{Network: "libera", Channel: "#secret"},
+		{Network: "libera", Channel: "#rust"},
+		{Network: "oftc", Channel: "#go"},
+	}
+	tests := []struct {
+		name    string
+		here    string
+		mine    []string
+		channel string
+		want    []string
+		wantErr bool
+	}{
+		{"in a channel", "#go", []string{"#go", "#secret"}, "", []string{"#go"}, false},
+		{"in private", "", []string{"#Go", "#rust"}, "", []string{"#go", "#rust"}, false},
+		{"in private, in no channels", "", nil, "", nil, false},
+		{"another channel the caller is in", "#go", []string{"#go", "#rust"}, "#RUST", []string{"#rust"}, false},
+		{"a channel the caller isn't in", "", []string{"#go"}, "#secret", nil, true},
+		{"a channel the caller isn't in, from a channel", "#go", []string{"#go"}, "#secret", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inScope, err := scopeFor("libera", tt.here, tt.mine, tt.channel)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var got []string
+			for _, r := range records {
+				if inScope(r) {
+					got = append(got, r.Channel)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("in scope: %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("in scope: %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
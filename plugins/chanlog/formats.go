@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of log entries, found in the "event" field
+const (
+	evMessage   = "message"
+	evAction    = "action"
+	evJoin      = "join"
+	evPart      = "part"
+	evQuit      = "quit"
+	evNick      = "nick"
+	evKick      = "kick"
+	evTopic     = "topic"
+	evMode      = "mode"
+	evDayChange = "daychange"
+)
+
+// A logrus formatter
+type IRCFormatter struct{}
+type IRCSystemFormatter struct{}
+
+// JSONFormatter writes one JSON object per line, with all metadata
+type JSONFormatter struct{}
+
+// WeechatFormatter writes logs like weechat does
+type WeechatFormatter struct{}
+
+// ZNCFormatter writes logs like ZNC's log module does
+type ZNCFormatter struct{}
+
+// newFormatter returns the formatter for the configured format
+func newFormatter(format string) (log.Formatter, error) {
+	switch strings.ToLower(format) {
+	case "irssi", "":
+		return new(IRCFormatter), nil
+	case "json", "jsonl":
+		return new(JSONFormatter), nil
+	case "weechat":
+		return new(WeechatFormatter), nil
+	case "znc":
+		return new(ZNCFormatter), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, expected irssi, json, weechat or znc", format)
+}
+
+// field returns the string field `name` from entry, or an empty string
+func field(entry *log.Entry, name string) string {
+	s, _ := entry.Data[name].(string)
+	return s
+}
+
+// userhost returns the user@host part of the hostmask in entry
+func userhost(entry *log.Entry) string {
+	hostmask := field(entry, "hostmask")
+	if _, uh, ok := strings.Cut(hostmask, "!"); ok {
+		return uh
+	}
+	return hostmask
+}
+
+// Format implements the logrus.Formatter interface
+func (*IRCFormatter) Format(entry *log.Entry) ([]byte, error) {
+	// make sure all fields are present
+	user, userok := entry.Data["user"]
+	var msg string
+	switch field(entry, "event") {
+	case evMessage, "":
+		if !userok {
+			return nil, errors.New("required fields missing")
+		}
+		msg = fmt.Sprintf("%s < %s> %s\n", entry.Time.Format("15:04:05"), user, entry.Message)
+	case evAction:
+		if !userok {
+			return nil, errors.New("required fields missing")
+		}
+		msg = fmt.Sprintf("%s *** %s %s\n", entry.Time.Format("15:04:05"), user, entry.Message)
+	default:
+		return new(IRCSystemFormatter).Format(entry)
+	}
+	return []byte(msg), nil
+}
+
+// Format implements the logrus.Formatter interface
+func (*IRCSystemFormatter) Format(entry *log.Entry) ([]byte, error) {
+	user, channel, target := field(entry, "user"), field(entry, "channel"), field(entry, "target")
+	var msg string
+	switch field(entry, "event") {
+	case evJoin:
+		msg = fmt.Sprintf("%s [%s] has joined %s", user, userhost(entry), channel)
+	case evPart:
+		msg = fmt.Sprintf("%s [%s] has left %s [%s]", user, userhost(entry), channel, entry.Message)
+	case evQuit:
+		msg = fmt.Sprintf("%s [%s] has quit [%s]", user, userhost(entry), entry.Message)
+	case evNick:
+		msg = fmt.Sprintf("%s is now known as %s", user, target)
+	case evKick:
+		msg = fmt.Sprintf("%s was kicked from %s by %s [%s]", target, channel, user, entry.Message)
+	case evTopic:
+		msg = fmt.Sprintf("%s changed the topic of %s to: %s", user, channel, entry.Message)
+	case evMode:
+		msg = fmt.Sprintf("mode/%s [%s] by %s", channel, entry.Message, user)
+	default:
+		msg = entry.Message
+	}
+	return []byte(fmt.Sprintf("%s -!- %s\n", entry.Time.Format("15:04:05"), msg)), nil
+}
+
+// jsonLine is what JSONFormatter writes
+type jsonLine struct {
+	Time     time.Time         `json:"time"`
+	Event    string            `json:"event"`
+	Network  string            `json:"network,omitempty"`
+	Channel  string            `json:"channel,omitempty"`
+	Nick     string            `json:"nick,omitempty"`
+	Hostmask string            `json:"hostmask,omitempty"`
+	Target   string            `json:"target,omitempty"`
+	Message  string            `json:"message,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+// Format implements the logrus.Formatter interface
+func (*JSONFormatter) Format(entry *log.Entry) ([]byte, error) {
+	event := field(entry, "event")
+	if event == "" {
+		event = evMessage
+	}
+	tags, _ := entry.Data["tags"].(map[string]string)
+	raw, err := json.Marshal(jsonLine{
+		Time:     entry.Time,
+		Event:    event,
+		Network:  field(entry, "network"),
+		Channel:  field(entry, "channel"),
+		Nick:     field(entry, "user"),
+		Hostmask: field(entry, "hostmask"),
+		Target:   field(entry, "target"),
+		Message:  entry.Message,
+		Tags:     tags,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(raw, '\n'), nil
+}
+
+// Format implements the logrus.Formatter interface
+func (*WeechatFormatter) Format(entry *log.Entry) ([]byte, error) {
+	user, channel, target := field(entry, "user"), field(entry, "channel"), field(entry, "target")
+	prefix, msg := "--", entry.Message
+	switch field(entry, "event") {
+	case evMessage, "":
+		prefix = user
+	case evAction:
+		prefix, msg = " *", user+" "+entry.Message
+	case evJoin:
+		prefix, msg = "-->", fmt.Sprintf("%s (%s) has joined %s", user, userhost(entry), channel)
+	case evPart:
+		prefix, msg = "<--", fmt.Sprintf("%s (%s) has left %s (%s)", user, userhost(entry), channel, entry.Message)
+	case evQuit:
+		prefix, msg = "<--", fmt.Sprintf("%s (%s) has quit (%s)", user, userhost(entry), entry.Message)
+	case evNick:
+		msg = fmt.Sprintf("%s is now known as %s", user, target)
+	case evKick:
+		prefix, msg = "<--", fmt.Sprintf("%s has kicked %s (%s)", user, target, entry.Message)
+	case evTopic:
+		msg = fmt.Sprintf("%s has changed topic for %s to \"%s\"", user, channel, entry.Message)
+	case evMode:
+		msg = fmt.Sprintf("Mode %s [%s] by %s", channel, entry.Message, user)
+	}
+	return []byte(fmt.Sprintf("%s\t%s\t%s\n", entry.Time.Format("2006-01-02 15:04:05"), prefix, msg)), nil
+}
+
+// Format implements the logrus.Formatter interface
+func (*ZNCFormatter) Format(entry *log.Entry) ([]byte, error) {
+	user, target := field(entry, "user"), field(entry, "target")
+	var msg string
+	switch field(entry, "event") {
+	case evMessage, "":
+		msg = fmt.Sprintf("<%s> %s", user, entry.Message)
+	case evAction:
+		msg = fmt.Sprintf("* %s %s", user, entry.Message)
+	case evJoin:
+		msg = fmt.Sprintf("*** Joins: %s (%s)", user, userhost(entry))
+	case evPart:
+		msg = fmt.Sprintf("*** Parts: %s (%s) (%s)", user, userhost(entry), entry.Message)
+	case evQuit:
+		msg = fmt.Sprintf("*** Quits: %s (%s) (%s)", user, userhost(entry), entry.Message)
+	case evNick:
+		msg = fmt.Sprintf("*** %s is now known as %s", user, target)
+	case evKick:
+		msg = fmt.Sprintf("*** %s was kicked by %s (%s)", target, user, entry.Message)
+	case evTopic:
+		msg = fmt.Sprintf("*** %s changes topic to '%s'", user, entry.Message)
+	case evMode:
+		msg = fmt.Sprintf("*** %s sets mode: %s", user, entry.Message)
+	default:
+		msg = "*** " + entry.Message
+	}
+	return []byte(fmt.Sprintf("[%s] %s\n", entry.Time.Format("15:04:05"), msg)), nil
+}
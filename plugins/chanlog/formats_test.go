@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestFormatters(t *testing.T) {
+	when := time.Date(2024, time.March, 7, 15, 4, 5, 0, time.UTC)
+	msg := &log.Entry{
+		Time:    when,
+		Message: "hello there",
+		Data: log.Fields{
+			"event":    evMessage,
+			"network":  "libera",
+			"channel":  "#go",
+			"user":     "adam",
+			"hostmask": "adam!~adam@example.com",
+			"tags":     map[string]string{"account": "adam"},
+		},
+	}
+	join := &log.Entry{
+		Time: when,
+		Data: log.Fields{
+			"event":    evJoin,
+			"channel":  "#go",
+			"user":     "adam",
+			"hostmask": "adam!~adam@example.com",
+		},
+	}
+	kick := &log.Entry{
+		Time:    when,
+		Message: "bye",
+		Data: log.Fields{
+			"event":   evKick,
+			"channel": "#go",
+			"user":    "op",
+			"target":  "adam",
+		},
+	}
+	quit := &log.Entry{
+		Time:    when,
+		Message: "Leaving",
+		Data:    log.Fields{"event": evQuit, "user": "adam", "hostmask": "adam!~adam@example.com"},
+	}
+	dayChange := &log.Entry{
+		Time:    when,
+		Message: "Date changed to Mar 07 2024",
+		Data:    log.Fields{"event": evDayChange, "channel": "#go"},
+	}
+	tests := []struct {
+		name      string
+		formatter log.Formatter
+		entry     *log.Entry
+		want      string
+	}{
+		{"irssi message", new(IRCFormatter), msg, "15:04:05 < adam> hello there\n"},
+		{"irssi join", new(IRCFormatter), join, "15:04:05 -!- adam [~adam@example.com] has joined #go\n"},
+		{"irssi kick", new(IRCFormatter), kick, "15:04:05 -!- adam was kicked from #go by op [bye]\n"},
+		{"system quit", new(IRCSystemFormatter), quit, "15:04:05 -!- adam [~adam@example.com] has quit [Leaving]\n"},
+		{"system day change", new(IRCSystemFormatter), dayChange, "15:04:05 -!- Date changed to Mar 07 2024\n"},
+		{"weechat message", new(WeechatFormatter), msg, "2024-03-07 15:04:05\tadam\thello there\n"},
+		{"weechat join", new(WeechatFormatter), join, "2024-03-07 15:04:05\t-->\tadam (~adam@example.com) has joined #go\n"},
+		{"znc message", new(ZNCFormatter), msg, "[15:04:05] <adam> hello there\n"},
+		{"znc kick", new(ZNCFormatter), kick, "[15:04:05] *** adam was kicked by op (bye)\n"},
+		{
+			"json message", new(JSONFormatter), msg,
+			`{"time":"2024-03-07T15:04:05Z","event":"message","network":"libera","channel":"#go","nick":"adam",` +
+				`"hostmask":"adam!~adam@example.com","message":"hello there","tags":{"account":"adam"}}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.formatter.Format(tt.entry)
+			if err != nil {
+				t.Fatalf("Format() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Format() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// record is a logged line in the search index
+type record struct {
+	// ID identifies the record. IDs are given in order, starting at 1, and kept when older records are removed.
+	ID      int       `json:"id"`
+	Time    time.Time `json:"time"`
+	Network string    `json:"network"`
+	Channel string    `json:"channel"`
+	Nick    string    `json:"nick"`
+	// Host is the user@host, and Account the services account, of who said it, so !nolog can find their lines
+	Host    string `json:"host,omitempty"`
+	Account string `json:"account,omitempty"`
+	Text    string `json:"text"`
+	Action  bool   `json:"action,omitempty"`
+}
+
+// String formats r for replies
+func (r record) String() string {
+	if r.Action {
+		return fmt.Sprintf("[%s] * %s %s", r.Time.Format("2006-01-02 15:04"), r.Nick, r.Text)
+	}
+	return fmt.Sprintf("[%s] <%s> %s", r.Time.Format("2006-01-02 15:04"), r.Nick, r.Text)
+}
+
+// index is an in memory full text index of logged lines, kept on disk as JSON lines. Lines are indexed by the
+// trigrams of their lowercased text, so regular expression searches only need to look at lines containing the
+// literal parts of the expression.
+type index struct {
+	m        sync.RWMutex
+	records  []record
+	trigrams map[string][]int
+	next     int
+	filename string
+	file     *os.File
+}
+
+// indexFile is the name of the index file in logroot
+const indexFile = "index.jsonl"
+
+// idx is the search index. It's nil if indexing is turned off.
+var idx *index
+
+// openIndex loads the index kept in `filename`, and opens it for appending
+func openIndex(filename string) (*index, error) {
+	ix := &index{trigrams: make(map[string][]int), next: 1, filename: filename}
+	if f, err := os.Open(filename); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var r record
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				log.WithError(err).WithField("file", filename).Warn("skipping bad line in index")
+				continue
+			}
+			ix.insert(r)
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading index %s: %w", filename, err)
+		}
+	}
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening index %s: %w", filename, err)
+	}
+	ix.file = file
+	return ix, nil
+}
+
+// add adds r to the index, and appends it to the index file
+func (ix *index) add(r record) error {
+	ix.m.Lock()
+	defer ix.m.Unlock()
+	r.ID = ix.next
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	ix.insert(r)
+	_, err = ix.file.Write(append(raw, '\n'))
+	return err
+}
+
+// insert adds r to the in memory index, and gives it an ID if it has none. The caller should lock!
+func (ix *index) insert(r record) {
+	if r.ID == 0 {
+		r.ID = ix.next
+	}
+	if r.ID >= ix.next {
+		ix.next = r.ID + 1
+	}
+	pos := len(ix.records)
+	ix.records = append(ix.records, r)
+	for _, t := range trigramsOf(r.Text) {
+		ix.trigrams[t] = append(ix.trigrams[t], pos)
+	}
+}
+
+// rewrite passes every record to f, which may change it, or return false to remove it, and then rewrites the index
+// file
+func (ix *index) rewrite(f func(r *record) bool) error {
+	ix.m.Lock()
+	defer ix.m.Unlock()
+	records := ix.records
+	ix.records, ix.trigrams = nil, make(map[string][]int)
+	for _, r := range records {
+		if f(&r) {
+			ix.insert(r)
+		}
+	}
+
+	tmp := ix.filename + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("error rewriting index: %w", err)
+	}
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	for _, r := range ix.records {
+		if err := enc.Encode(r); err != nil {
+			out.Close()
+			return fmt.Errorf("error rewriting index: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return fmt.Errorf("error rewriting index: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error rewriting index: %w", err)
+	}
+	ix.file.Close()
+	if err := os.Rename(tmp, ix.filename); err != nil {
+		return fmt.Errorf("error rewriting index: %w", err)
+	}
+	if ix.file, err = os.OpenFile(ix.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return fmt.Errorf("error opening index %s: %w", ix.filename, err)
+	}
+	return nil
+}
+
+// trigramsOf returns the unique trigrams of the lowercased s
+func trigramsOf(s string) []string {
+	runes := []rune(strings.ToLower(s))
+	seen := make(map[string]struct{})
+	var rv []string
+	for i := 0; i+3 <= len(runes); i++ {
+		t := string(runes[i : i+3])
+		if _, ok := seen[t]; ok {
+			continue
+		}
+		seen[t] = struct{}{}
+		rv = append(rv, t)
+	}
+	return rv
+}
+
+// literals returns strings that any match of the regular expression must contain
+func literals(expr string) []string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	var rv []string
+	var walk func(re *syntax.Regexp)
+	walk = func(re *syntax.Regexp) {
+		switch re.Op {
+		case syntax.OpLiteral:
+			rv = append(rv, string(re.Rune))
+		case syntax.OpCapture, syntax.OpPlus:
+			walk(re.Sub[0])
+		case syntax.OpConcat:
+			for _, sub := range re.Sub {
+				walk(sub)
+			}
+		}
+	}
+	walk(re.Simplify())
+	return rv
+}
+
+// candidates returns the positions of records that may match the regular expression, in ascending order, or nil and false
+// if all records have to be checked. The caller should lock!
+func (ix *index) candidates(expr string) ([]int, bool) {
+	var rv []int
+	narrowed := false
+	for _, lit := range literals(expr) {
+		if utf8.RuneCountInString(lit) < 3 {
+			continue
+		}
+		for _, t := range trigramsOf(lit) {
+			ids := ix.trigrams[t]
+			if !narrowed {
+				rv, narrowed = ids, true
+				continue
+			}
+			rv = intersect(rv, ids)
+		}
+	}
+	return rv, narrowed
+}
+
+// intersect returns the ids found in both a and b, which must be sorted
+func intersect(a, b []int) []int {
+	var rv []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			rv = append(rv, a[i])
+			i++
+			j++
+		}
+	}
+	return rv
+}
+
+// grep returns up to `max` records, newest first, that match re and `filter`, and the total number of matches
+func (ix *index) grep(re *regexp.Regexp, filter func(record) bool, max int) ([]record, int) {
+	ix.m.RLock()
+	defer ix.m.RUnlock()
+	ids, narrowed := ix.candidates(re.String())
+	n := len(ix.records)
+	if narrowed {
+		n = len(ids)
+	}
+	var rv []record
+	total := 0
+	for i := n - 1; i >= 0; i-- {
+		id := i
+		if narrowed {
+			id = ids[i]
+		}
+		r := ix.records[id]
+		if !filter(r) || !re.MatchString(r.Text) {
+			continue
+		}
+		total++
+		if len(rv) < max {
+			rv = append(rv, r)
+		}
+	}
+	return rv, total
+}
+
+// last returns the newest record matching `filter`
+func (ix *index) last(filter func(record) bool) (record, bool) {
+	ix.m.RLock()
+	defer ix.m.RUnlock()
+	for i := len(ix.records) - 1; i >= 0; i-- {
+		if filter(ix.records[i]) {
+			return ix.records[i], true
+		}
+	}
+	return record{}, false
+}
+
+// random returns a random record matching `filter`
+func (ix *index) random(filter func(record) bool) (record, bool) {
+	ix.m.RLock()
+	defer ix.m.RUnlock()
+	var matches []int
+	for i, r := range ix.records {
+		if filter(r) {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) == 0 {
+		return record{}, false
+	}
+	return ix.records[matches[rand.Intn(len(matches))]], true
+}
+
+// channels returns the channels in the index, sorted by network and channel
+func (ix *index) channels() []logKey {
+	ix.m.RLock()
+	defer ix.m.RUnlock()
+	seen := make(map[logKey]struct{})
+	var rv []logKey
+	for _, r := range ix.records {
+		k := logKey{network: r.Network, channel: r.Channel}
+		if _, ok := seen[k]; !ok {
+			seen[k] = struct{}{}
+			rv = append(rv, k)
+		}
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].network != rv[j].network {
+			return rv[i].network < rv[j].network
+		}
+		return rv[i].channel < rv[j].channel
+	})
+	return rv
+}
+
+// days returns the dates, as 2006-01-02, that the channel in k has lines from, newest first
+func (ix *index) days(k logKey) []string {
+	ix.m.RLock()
+	defer ix.m.RUnlock()
+	seen := make(map[string]struct{})
+	var rv []string
+	for _, r := range ix.records {
+		if r.Network != k.network || r.Channel != k.channel {
+			continue
+		}
+		day := r.Time.Local().Format("2006-01-02")
+		if _, ok := seen[day]; !ok {
+			seen[day] = struct{}{}
+			rv = append(rv, day)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(rv)))
+	return rv
+}
+
+// day returns the lines from the channel in k on the date `day`, as 2006-01-02
+func (ix *index) day(k logKey, day string) []record {
+	ix.m.RLock()
+	defer ix.m.RUnlock()
+	var rv []record
+	for _, r := range ix.records {
+		if r.Network == k.network && r.Channel == k.channel && r.Time.Local().Format("2006-01-02") == day {
+			rv = append(rv, r)
+		}
+	}
+	return rv
+}
+
+// close closes the index file
+func (ix *index) close() error {
+	ix.m.Lock()
+	defer ix.m.Unlock()
+	return ix.file.Close()
+}
+
+// configureIndex reads the "index" directive, and opens the index in logroot if it's on, which it is by default
+func configureIndex(c map[interface{}]interface{}) error {
+	on := true
+	if i, ok := c["index"]; ok {
+		if on, ok = i.(bool); !ok {
+			return fmt.Errorf("expected bool index, got %T", i)
+		}
+	}
+	if idx != nil {
+		idx.close()
+		idx = nil
+	}
+	if !on {
+		return nil
+	}
+	if err := os.MkdirAll(logroot, 0700); err != nil {
+		return err
+	}
+	ix, err := openIndex(filepath.Join(logroot, indexFile))
+	if err != nil {
+		return err
+	}
+	idx = ix
+	return nil
+}
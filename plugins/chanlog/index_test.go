@@ -0,0 +1,119 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func Test_literals(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"hello", []string{"hello"}},
+		{"foo.*bar", []string{"foo", "bar"}},
+		{"(abc)+d", []string{"abc", "d"}},
+		{"foo|bar", nil},
+		{"a?bcd", []string{"bcd"}},
+		{"(", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			if got := literals(tt.expr); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("literals() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_intersect(t *testing.T) {
+	if got := intersect([]int{1, 3, 5, 7}, []int{2, 3, 4, 7, 9}); !reflect.DeepEqual(got, []int{3, 7}) {
+		t.Errorf("intersect() = %v, want [3 7]", got)
+	}
+}
+
+func Test_index(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "index.jsonl")
+	ix, err := openIndex(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, time.March, 7, 12, 0, 0, 0, time.UTC)
+	lines := []record{
+		{Nick: "alice", Channel: "#go", Text: "Generics are here"},
+		{Nick: "bob", Channel: "#go", Text: "I prefer interfaces"},
+		{Nick: "alice", Channel: "#rust", Text: "generic traits"},
+		{Nick: "carol", Channel: "#go", Text: "what about GENERICS?"},
+		{Nick: "bob", Channel: "#go", Text: "hi"},
+	}
+	for i, r := range lines {
+		r.Time = start.Add(time.Duration(i) * time.Minute)
+		r.Network = "libera"
+		if err := ix.add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ix.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen, to check it's read back from disk
+	ix, err = openIndex(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.close()
+	inGo := func(r record) bool { return r.Channel == "#go" }
+
+	got, total := ix.grep(regexp.MustCompile("(?i)generics"), inGo, 10)
+	if total != 2 || len(got) != 2 || got[0].Nick != "carol" || got[1].Nick != "alice" {
+		t.Errorf("grep() = %v, %d, want carol's and alice's lines, newest first", got, total)
+	}
+	got, total = ix.grep(regexp.MustCompile("(?i)generics"), inGo, 1)
+	if total != 2 || len(got) != 1 {
+		t.Errorf("grep() with max 1 = %v, %d, want 1 line of 2", got, total)
+	}
+	// too short for trigrams, so every line is checked
+	if _, total = ix.grep(regexp.MustCompile("^hi$"), inGo, 10); total != 1 {
+		t.Errorf("grep() for a short expression found %d lines, want 1", total)
+	}
+	if r, ok := ix.last(func(r record) bool { return r.Nick == "alice" }); !ok || r.Channel != "#rust" {
+		t.Errorf("last() = %v, %v, want alice's line in #rust", r, ok)
+	}
+	if r, ok := ix.random(func(r record) bool { return r.Nick == "bob" }); !ok || r.Nick != "bob" {
+		t.Errorf("random() = %v, %v, want one of bob's lines", r, ok)
+	}
+	if _, ok := ix.random(func(r record) bool { return r.Nick == "dave" }); ok {
+		t.Error("random() found a line by a nick that never spoke")
+	}
+}
+
+func Test_parseSince(t *testing.T) {
+	now := time.Date(2024, time.March, 7, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		s       string
+		want    time.Time
+		wantErr bool
+	}{
+		{s: "3h", want: now.Add(-3 * time.Hour)},
+		{s: "2d", want: time.Date(2024, time.March, 5, 12, 0, 0, 0, time.Local)},
+		{s: "1w", want: time.Date(2024, time.February, 29, 12, 0, 0, 0, time.Local)},
+		{s: "2024-01-02", want: time.Date(2024, time.January, 2, 0, 0, 0, 0, time.Local)},
+		{s: "2y", wantErr: true},
+		{s: "h", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseSince(tt.s, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSince() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseSince() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultPathTemplate is where logs go, relative to logroot, unless configured otherwise
+const defaultPathTemplate = "{network}/{year}/{month}/{channel}.log"
+
+// rotation is how often log files are rotated
+type rotation int
+
+const (
+	monthly rotation = iota
+	weekly
+	daily
+)
+
+var (
+	pathTemplate = defaultPathTemplate
+	rotateEvery  = monthly
+	compress     bool
+	// logFiles matches the paths of log files, relative to logroot
+	logFiles = logPattern(defaultPathTemplate)
+)
+
+// parseRotation parses the "rotate" configuration directive
+func parseRotation(s string) (rotation, error) {
+	switch strings.ToLower(s) {
+	case "monthly", "":
+		return monthly, nil
+	case "weekly":
+		return weekly, nil
+	case "daily":
+		return daily, nil
+	}
+	return monthly, fmt.Errorf("unknown rotation %q, expected daily, weekly or monthly", s)
+}
+
+// periodStart returns the start of the rotation period that t is in. Weeks start on mondays.
+func (r rotation) periodStart(t time.Time) time.Time {
+	y, m, d := t.Date()
+	switch r {
+	case daily:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	case weekly:
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+}
+
+// configurePaths reads the "path", "rotate" and "compress" directives
+func configurePaths(c map[interface{}]interface{}) error {
+	pathTemplate = defaultPathTemplate
+	if p, ok := c["path"]; ok {
+		if pathTemplate, ok = p.(string); !ok {
+			return fmt.Errorf("expected string path, got %T", p)
+		}
+	}
+	rotateEvery = monthly
+	if r, ok := c["rotate"]; ok {
+		rs, ok := r.(string)
+		if !ok {
+			return fmt.Errorf("expected string rotate, got %T", r)
+		}
+		var err error
+		if rotateEvery, err = parseRotation(rs); err != nil {
+			return err
+		}
+	}
+	logFiles = logPattern(pathTemplate)
+	compress = false
+	if cp, ok := c["compress"]; ok {
+		if compress, ok = cp.(bool); !ok {
+			return fmt.Errorf("expected bool compress, got %T", cp)
+		}
+	}
+	return nil
+}
+
+// expandPath fills in the placeholders in tmpl for the channel in k at time t, and returns the resulting path under
+// root. Placeholders are {network}, {channel}, {year}, {month} and {day}.
+func expandPath(root, tmpl string, k logKey, t time.Time) string {
+	r := strings.NewReplacer(
+		"{network}", sanitise(k.network),
+		"{channel}", sanitise(k.channel),
+		"{year}", t.Format("2006"),
+		"{month}", t.Format("01"),
+		"{day}", t.Format("02"),
+	)
+	return filepath.Join(root, filepath.FromSlash(r.Replace(tmpl)))
+}
+
+// placeholder matches the placeholders in a path template
+var placeholder = regexp.MustCompile(`\{(network|channel|year|month|day)\}`)
+
+// logPattern returns a regular expression matching the paths that tmpl produces, relative to logroot and with forward
+// slashes, compressed or not
+func logPattern(tmpl string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	last := 0
+	for _, m := range placeholder.FindAllStringSubmatchIndex(tmpl, -1) {
+		b.WriteString(regexp.QuoteMeta(tmpl[last:m[0]]))
+		switch tmpl[m[2]:m[3]] {
+		case "network", "channel":
+			b.WriteString(`[^/]+`)
+		case "year":
+			b.WriteString(`\d{4}`)
+		default:
+			b.WriteString(`\d{2}`)
+		}
+		last = m[1]
+	}
+	b.WriteString(regexp.QuoteMeta(tmpl[last:]))
+	b.WriteString(`(\.gz)?$`)
+	return regexp.MustCompile(b.String())
+}
+
+// isLogFile returns true if path is a log file under logroot: it must be where the path template puts logs, and end
+// in .log or .log.gz, so nothing else in logroot is ever deleted or served
+func isLogFile(path string) bool {
+	if logroot == "" {
+		return false
+	}
+	rel, err := filepath.Rel(logroot, path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if !strings.HasSuffix(rel, ".log") && !strings.HasSuffix(rel, ".log.gz") {
+		return false
+	}
+	return logFiles.MatchString(rel)
+}
+
+// sanitise makes s safe to use as a single file name, by replacing path separators and other troublesome characters
+func sanitise(s string) string {
+	switch s {
+	case "":
+		return "_"
+	case ".", "..":
+		return strings.Repeat("_", len(s))
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// open opens the log file for the channel in k for the rotation period that t is in. The caller should lock!
+func (l *channelLog) open(k logKey, t time.Time) error {
+	if logroot == "" {
+		return fmt.Errorf("logroot undefined. Did you run the \"Configure\" function?")
+	}
+	logfile := expandPath(logroot, pathTemplate, k, rotateEvery.periodStart(t))
+	if err := os.MkdirAll(filepath.Dir(logfile), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(logfile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("couldn't open logfile at %s: %w", logfile, err)
+	}
+	l.Out = file
+	l.file = file
+	l.path = logfile
+	return nil
+}
+
+// gzipFile compresses the file at path to path.gz, and removes the original
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
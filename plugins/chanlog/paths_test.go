@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_expandPath(t *testing.T) {
+	now := time.Date(2024, time.March, 7, 15, 4, 5, 0, time.UTC) // a thursday
+	tests := []struct {
+		name     string
+		tmpl     string
+		k        logKey
+		rotation rotation
+		want     string
+	}{
+		{
+			name:     "default monthly",
+			tmpl:     defaultPathTemplate,
+			k:        logKey{network: "IRCNet", channel: "#linux"},
+			rotation: monthly,
+			want:     "logs/IRCNet/2024/03/#linux.log",
+		},
+		{
+			name:     "daily",
+			tmpl:     "{network}/{channel}/{year}-{month}-{day}.log",
+			k:        logKey{network: "libera", channel: "#go"},
+			rotation: daily,
+			want:     "logs/libera/#go/2024-03-07.log",
+		},
+		{
+			name:     "weekly starts on monday",
+			tmpl:     "{channel}-{year}{month}{day}.log",
+			k:        logKey{network: "libera", channel: "#go"},
+			rotation: weekly,
+			want:     "logs/#go-20240304.log",
+		},
+		{
+			name:     "unsafe channel",
+			tmpl:     defaultPathTemplate,
+			k:        logKey{network: "net", channel: "#../../etc/passwd"},
+			rotation: monthly,
+			want:     "logs/net/2024/03/#.._.._etc_passwd.log",
+		},
+		{
+			name:     "dot network",
+			tmpl:     defaultPathTemplate,
+			k:        logKey{network: "..", channel: "#x"},
+			rotation: monthly,
+			want:     "logs/__/2024/03/#x.log",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := expandPath("logs", tt.tmpl, tt.k, tt.rotation.periodStart(now))
+			if got != tt.want {
+				t.Errorf("expandPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_logged(t *testing.T) {
+	chanlist = []string{"#linux", "libera/#go"}
+	tests := []struct {
+		k    logKey
+		want bool
+	}{
+		{logKey{"IRCNet", "#linux"}, true},
+		{logKey{"libera", "#linux"}, true},
+		{logKey{"libera", "#go"}, true},
+		{logKey{"Libera", "#go"}, true},
+		{logKey{"IRCNet", "#go"}, false},
+		{logKey{"IRCNet", "#other"}, false},
+	}
+	for _, tt := range tests {
+		if got := logged(tt.k); got != tt.want {
+			t.Errorf("logged(%v) = %v, want %v", tt.k, got, tt.want)
+		}
+	}
+}
+
+func Test_isLogFile(t *testing.T) {
+	defer func() { logroot, logFiles = "", logPattern(defaultPathTemplate) }()
+	logroot = "/srv/bender"
+	tests := []struct {
+		tmpl, path string
+		want       bool
+	}{
+		{defaultPathTemplate, "/srv/bender/IRCNet/2024/03/#linux.log", true},
+		{defaultPathTemplate, "/srv/bender/IRCNet/2024/03/#linux.log.gz", true},
+		{defaultPathTemplate, "/srv/bender/IRCNet/2024/03/#linux.txt", false},
+		{defaultPathTemplate, "/srv/bender/IRCNet/2024/3/#linux.log", false},
+		{defaultPathTemplate, "/srv/bender/notes.log", false},
+		{defaultPathTemplate, "/srv/bender/conf/conf.yml", false},
+		{defaultPathTemplate, "/srv/bender/index.jsonl", false},
+		{defaultPathTemplate, "/srv/bender/optout.json", false},
+		{defaultPathTemplate, "/srv/IRCNet/2024/03/#linux.log", false},
+		{"{network}/{channel}/{year}-{month}-{day}.log", "/srv/bender/libera/#go/2024-03-07.log", true},
+		{"{network}/{channel}/{year}-{month}-{day}.log", "/srv/bender/libera/#go/2024-03.log", false},
+		// templates that don't make .log files never match anything
+		{"{network}/{channel}", "/srv/bender/conf/conf.yml", false},
+	}
+	for _, tt := range tests {
+		logFiles = logPattern(tt.tmpl)
+		if got := isLogFile(tt.path); got != tt.want {
+			t.Errorf("isLogFile(%q) with %q = %t, want %t", tt.path, tt.tmpl, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	irc "github.com/thoj/go-ircevent"
+
+	"github.com/adamhassel/bender/internal/helpers"
+	"github.com/adamhassel/bender/internal/lib/commands"
+	bot "github.com/adamhassel/bender/internal/lib/irc"
+)
+
+// redacted replaces what isn't logged
+const redacted = "[redacted]"
+
+// defaultNotice is sent to a channel when the bot starts logging it, unless configured otherwise
+const defaultNotice = "This channel is logged. Say !nolog to keep your messages out of the logs"
+
+var (
+	// redactPatterns are replaced by `redacted` in everything logged
+	redactPatterns []*regexp.Regexp
+	// retention is how long logs are kept. Zero keeps them forever.
+	retention time.Duration
+	// notice is sent to a channel when the bot joins it, if it's logged
+	notice = defaultNotice
+
+	om sync.Mutex
+	// optouts holds the users who don't want to be logged, as "network/nick", lowercased
+	optouts = make(map[string]struct{})
+)
+
+// optoutKey returns the key in optouts for nick on network
+func optoutKey(network, nick string) string {
+	return strings.ToLower(network + "/" + nick)
+}
+
+// optedOut returns true if nick on network doesn't want to be logged
+func optedOut(network, nick string) bool {
+	om.Lock()
+	defer om.Unlock()
+	_, ok := optouts[optoutKey(network, nick)]
+	return ok
+}
+
+// optoutFile is where opt-outs are kept
+func optoutFile() string {
+	return filepath.Join(logroot, "optout.json")
+}
+
+// loadOptouts reads the opt-outs from disk
+func loadOptouts() error {
+	om.Lock()
+	defer om.Unlock()
+	optouts = make(map[string]struct{})
+	raw, err := os.ReadFile(optoutFile())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var keys []string
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return fmt.Errorf("error parsing %s: %w", optoutFile(), err)
+	}
+	for _, k := range keys {
+		optouts[k] = struct{}{}
+	}
+	return nil
+}
+
+// setOptout opts nick on network out of logging, or back in, and saves the opt-outs
+func setOptout(network, nick string, out bool) error {
+	om.Lock()
+	defer om.Unlock()
+	k := optoutKey(network, nick)
+	if out {
+		optouts[k] = struct{}{}
+	} else {
+		delete(optouts, k)
+	}
+	keys := make([]string, 0, len(optouts))
+	for k := range optouts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	raw, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return helpers.WriteFileAtomic(optoutFile(), raw, 0600)
+}
+
+// redact replaces anything matching the redaction patterns in s
+func redact(s string) string {
+	for _, re := range redactPatterns {
+		s = re.ReplaceAllString(s, redacted)
+	}
+	return s
+}
+
+// NoLog opts the caller out of logging. From now on, what they say is redacted in the logs and the search index. What
+// they've said before is redacted in the search index, but stays in the log files.
+func NoLog(_ commands.Args, e *irc.Event) (string, bool) {
+	network := bot.Network(e.Connection)
+	if err := setOptout(network, e.Nick, true); err != nil {
+		log.WithError(err).Error("couldn't save log opt-out")
+		return "Sorry, something went wrong", false
+	}
+	if idx != nil {
+		err := idx.rewrite(func(r *record) bool {
+			if saidBy(*r, network, e.User+"@"+e.Host, e.Tags["account"]) {
+				r.Text = redacted
+			}
+			return true
+		})
+		if err != nil {
+			log.WithError(err).Error("couldn't redact the search index")
+		}
+	}
+	return fmt.Sprintf("OK %s, I won't log what you say. Say !log to change your mind", e.Nick), false
+}
+
+// saidBy returns true if r was said on network by someone with the user@host `host`, or logged in to `account`. The
+// nick isn't enough, since others may have used it.
+func saidBy(r record, network, host, account string) bool {
+	if r.Network != network {
+		return false
+	}
+	return r.Host == host || account != "" && r.Account == account
+}
+
+// Log opts the caller back in to logging
+func Log(_ commands.Args, e *irc.Event) (string, bool) {
+	if err := setOptout(bot.Network(e.Connection), e.Nick, false); err != nil {
+		log.WithError(err).Error("couldn't save log opt-out")
+		return "Sorry, something went wrong", false
+	}
+	return fmt.Sprintf("OK %s, I'll log what you say again", e.Nick), false
+}
+
+// configurePrivacy reads the "redact", "retention" and "notice" directives, and loads the opt-outs
+func configurePrivacy(c map[interface{}]interface{}) error {
+	redactPatterns = nil
+	if r, ok := c["redact"]; ok {
+		patterns, ok := r.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected a list of regular expressions in redact, got %T", r)
+		}
+		for _, p := range patterns {
+			ps, ok := p.(string)
+			if !ok {
+				return fmt.Errorf("expected string in redact, got %T", p)
+			}
+			re, err := regexp.Compile(ps)
+			if err != nil {
+				return fmt.Errorf("invalid redact pattern %q: %w", ps, err)
+			}
+			redactPatterns = append(redactPatterns, re)
+		}
+	}
+	retention = 0
+	if r, ok := c["retention"]; ok {
+		days, ok := r.(int)
+		if !ok || days < 0 {
+			return fmt.Errorf("expected retention as a number of days, got %v", r)
+		}
+		// logroot defaults to the bot's own directory, which is no place to go deleting files
+		if _, ok := c["logroot"]; !ok && days > 0 {
+			return errors.New("retention needs logroot to be set")
+		}
+		retention = time.Duration(days) * 24 * time.Hour
+	}
+	notice = defaultNotice
+	if n, ok := c["notice"]; ok {
+		if notice, ok = n.(string); !ok {
+			return fmt.Errorf("expected string notice, got %T", n)
+		}
+	}
+	return loadOptouts()
+}
+
+// expire deletes log files, and lines in the search index, older than the retention period
+func expire() {
+	if retention == 0 {
+		return
+	}
+	cutoff := time.Now().Add(-retention)
+	lm.Lock()
+	open := make(map[string]struct{}, len(loggers))
+	for _, l := range loggers {
+		open[l.path] = struct{}{}
+	}
+	lm.Unlock()
+	err := filepath.WalkDir(logroot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if _, ok := open[path]; ok || !isLogFile(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(cutoff) {
+			log.WithField("file", path).Info("deleting expired log")
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("couldn't delete expired logs")
+	}
+	if idx != nil {
+		if err := idx.rewrite(func(r *record) bool { return !r.Time.Before(cutoff) }); err != nil {
+			log.WithError(err).Error("couldn't remove expired lines from the search index")
+		}
+	}
+}
+
+// censor returns what to log of msg, which is an `event` by nick on network. The messages, reasons and topics of users
+// who have opted out are redacted, as is anything matching the redaction patterns.
+func censor(network, nick, event, msg string) string {
+	switch event {
+	case evMessage, evAction, evPart, evQuit, evTopic:
+		if optedOut(network, nick) {
+			return redacted
+		}
+	}
+	return redact(msg)
+}
+
+// announce sends the logging notice to a channel when the bot joins it, if it's logged
+func announce(e *irc.Event) {
+	if notice == "" || !isBot(e, e.Nick) {
+		return
+	}
+	if logged(keyFor(e.Connection, e.Arguments[0])) {
+		e.Connection.Notice(e.Arguments[0], notice)
+	}
+}
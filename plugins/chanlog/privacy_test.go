@@ -0,0 +1,195 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	irc "github.com/thoj/go-ircevent"
+)
+
+func Test_censor(t *testing.T) {
+	logroot = t.TempDir()
+	defer func() { logroot, redactPatterns = "", nil }()
+	if err := loadOptouts(); err != nil {
+		t.Fatal(err)
+	}
+	redactPatterns = []*regexp.Regexp{regexp.MustCompile(`token=\S+`)}
+	if err := setOptout("libera", "Shy", true); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, nick, event, msg, want string
+	}{
+		{"opted out message", "shy", evMessage, "hello", redacted},
+		{"opted out quit", "SHY", evQuit, "bye", redacted},
+		{"opted out nick change", "shy", evNick, "", ""},
+		{"pattern", "bob", evMessage, "oops token=abc123 sorry", "oops [redacted] sorry"},
+		{"nothing", "bob", evAction, "waves", "waves"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := censor("libera", tt.nick, tt.event, tt.msg); got != tt.want {
+				t.Errorf("censor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if censor("oftc", "shy", evMessage, "hello") != "hello" {
+		t.Error("opt-out applies on another network")
+	}
+
+	// opt-outs are persisted
+	if err := loadOptouts(); err != nil {
+		t.Fatal(err)
+	}
+	if !optedOut("libera", "shy") {
+		t.Error("opt-out was not persisted")
+	}
+	if err := setOptout("libera", "shy", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadOptouts(); err != nil {
+		t.Fatal(err)
+	}
+	if optedOut("libera", "shy") {
+		t.Error("opting back in was not persisted")
+	}
+}
+
+func Test_saidBy(t *testing.T) {
+	r := record{Network: "libera", Nick: "shy", Host: "~shy@home.example", Account: "shy"}
+	tests := []struct {
+		name, network, host, account string
+		want                         bool
+	}{
+		{"same host", "libera", "~shy@home.example", "", true},
+		{"same account", "libera", "~shy@work.example", "shy", true},
+		{"same nick only", "libera", "~other@elsewhere.example", "", false},
+		{"other account", "libera", "~other@elsewhere.example", "other", false},
+		{"other network", "oftc", "~shy@home.example", "shy", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := saidBy(r, tt.network, tt.host, tt.account); got != tt.want {
+				t.Errorf("saidBy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if saidBy(record{Network: "libera", Nick: "shy"}, "libera", "~shy@home.example", "") {
+		t.Error("saidBy() matched a record without a host")
+	}
+}
+
+func TestEvent_nickOptout(t *testing.T) {
+	logroot = t.TempDir()
+	defer func() { logroot = "" }()
+	if err := loadOptouts(); err != nil {
+		t.Fatal(err)
+	}
+	if err := setOptout("", "shy", true); err != nil {
+		t.Fatal(err)
+	}
+	Event(&irc.Event{Code: "NICK", Nick: "shy", Arguments: []string{"shy_"}, Connection: irc.IRC("Bender", "bender")})
+	if !optedOut("", "shy_") {
+		t.Error("opt-out didn't follow the nick change")
+	}
+	if optedOut("", "shy") {
+		t.Error("opt-out stayed with the old nick")
+	}
+}
+
+func Test_expire(t *testing.T) {
+	logroot = t.TempDir()
+	retention = 30 * 24 * time.Hour
+	defer func() { logroot, retention = "", 0 }()
+
+	old := filepath.Join(logroot, "libera", "2020", "01", "#go.log")
+	recent := filepath.Join(logroot, "libera", "2024", "03", "#go.log")
+	for _, f := range []string{old, recent} {
+		if err := os.MkdirAll(filepath.Dir(f), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, []byte("log\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	longAgo := time.Now().AddDate(0, 0, -31)
+	// logroot may be the bot's own directory. Nothing but old logs may go.
+	keep := []string{
+		filepath.Join(logroot, "conf", "conf.yml"),
+		filepath.Join(logroot, "bender"),
+		filepath.Join(logroot, "chanlog.so"),
+		filepath.Join(logroot, "db", "reminders.json"),
+		filepath.Join(logroot, "notes.log"),
+		filepath.Join(logroot, "libera", "2020", "01", "#go.txt"),
+		optoutFile(),
+	}
+	for _, f := range append(keep, old) {
+		if err := os.MkdirAll(filepath.Dir(f), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, []byte("keep\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(f, longAgo, longAgo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ix, err := openIndex(filepath.Join(logroot, indexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx = ix
+	defer func() { ix.close(); idx = nil }()
+	ix.add(record{Time: longAgo, Network: "libera", Channel: "#go", Nick: "alice", Text: "old"})
+	ix.add(record{Time: time.Now(), Network: "libera", Channel: "#go", Nick: "alice", Text: "new"})
+	// the index file is old too, but must be kept
+	if err := os.Chtimes(ix.filename, longAgo, longAgo); err != nil {
+		t.Fatal(err)
+	}
+
+	expire()
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("old log wasn't deleted: %v", err)
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("recent log was deleted: %v", err)
+	}
+	for _, f := range keep {
+		if _, err := os.Stat(f); err != nil {
+			t.Errorf("%s isn't a log, but was deleted: %v", f, err)
+		}
+	}
+	if len(ix.records) != 1 || ix.records[0].Text != "new" || ix.records[0].ID != 2 {
+		t.Errorf("index after expiry = %v, want only the new line, with its ID", ix.records)
+	}
+	reopened, err := openIndex(ix.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.close()
+	if len(reopened.records) != 1 || reopened.records[0].ID != 2 {
+		t.Errorf("index file after expiry = %v, want only the new line, with its ID", reopened.records)
+	}
+	if reopened.next != 3 {
+		t.Errorf("next ID = %d, want 3", reopened.next)
+	}
+}
+
+func Test_configurePrivacy_retention(t *testing.T) {
+	defer func() { logroot, retention = "", 0 }()
+	logroot = t.TempDir()
+	if err := configurePrivacy(map[interface{}]interface{}{"retention": 30}); err == nil {
+		t.Error("retention was enabled without logroot")
+	}
+	if err := configurePrivacy(map[interface{}]interface{}{"retention": 30, "logroot": logroot}); err != nil {
+		t.Errorf("retention with logroot: %s", err)
+	}
+	if retention != 30*24*time.Hour {
+		t.Errorf("retention = %s", retention)
+	}
+}
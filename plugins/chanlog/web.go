@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/adamhassel/bender/internal/lib/web"
+)
+
+// maxSearchResults is the maximum number of lines a search on the web shows
+const maxSearchResults = 500
+
+// webLine is a log line as shown on the web
+type webLine struct {
+	record
+	// Anchor is the id of the line on its day page, and Link its permalink
+	Anchor string
+	Link   string
+}
+
+// channelPath returns the path of the log viewer page for the channel in k
+func channelPath(k logKey) string {
+	return "/logs/" + url.PathEscape(k.network) + "/" + url.PathEscape(k.channel) + "/"
+}
+
+// webLines prepares records for showing on the web
+func webLines(records []record) []webLine {
+	rv := make([]webLine, len(records))
+	for i, r := range records {
+		anchor := fmt.Sprintf("l%d", r.ID)
+		day := channelPath(logKey{network: r.Network, channel: r.Channel}) + r.Time.Local().Format("2006-01-02")
+		rv[i] = webLine{record: r, Anchor: anchor, Link: day + "#" + anchor}
+	}
+	return rv
+}
+
+// logTemplates are the templates shared by the log viewer pages
+const logTemplates = `{{define "search"}}<form action="/logs/search">
+<input name="q" value="{{.Query}}" placeholder="regular expression">
+<input name="nick" value="{{.Nick}}" placeholder="nick">
+<input type="hidden" name="network" value="{{.Network}}">
+<input type="hidden" name="channel" value="{{.Channel}}">
+<button>Search{{with .Channel}} {{.}}{{end}}</button>
+</form>{{end}}
+{{define "line"}}{{if .Action}}* {{.Nick}} {{.Text}}{{else}}&lt;{{.Nick}}&gt; {{.Text}}{{end}}{{end}}
+`
+
+var channelsPage = web.Page(logTemplates + `{{define "title"}}Channel logs{{end}}
+{{define "content"}}
+{{template "search" .}}
+<ul>{{range .Channels}}<li><a href="{{.Link}}">{{.Network}} {{.Channel}}</a></li>{{else}}<li>Nothing logged yet</li>{{end}}</ul>
+<p><a href="/logs/files/">Log files</a></p>
+{{end}}`)
+
+var daysPage = web.Page(logTemplates + `{{define "title"}}{{.Channel}} on {{.Network}}{{end}}
+{{define "content"}}
+{{template "search" .}}
+<ul>{{range .Days}}<li><a href="{{$.Link}}{{.}}">{{.}}</a></li>{{end}}</ul>
+{{end}}`)
+
+var dayPage = web.Page(logTemplates + `{{define "title"}}{{.Channel}} on {{.Network}}, {{.Day}}{{end}}
+{{define "content"}}
+{{template "search" .}}
+<p><a href="{{.Link}}">All days</a></p>
+<table class="log">
+{{range .Lines}}<tr id="{{.Anchor}}"><td><a href="{{.Link}}">{{.Time.Local.Format "15:04:05"}}</a></td><td>{{template "line" .}}</td></tr>
+{{end}}</table>
+{{end}}`)
+
+var searchPage = web.Page(logTemplates + `{{define "title"}}Search{{with .Channel}} {{.}}{{end}}{{end}}
+{{define "content"}}
+{{template "search" .}}
+{{with .Error}}<p>{{.}}</p>{{end}}
+{{if .Query}}<p>{{.Total}} matches{{if gt .Total (len .Lines)}}, showing the newest {{len .Lines}}{{end}}</p>{{end}}
+<table class="log">
+{{range .Lines}}<tr><td><a href="{{.Link}}">{{.Time.Local.Format "2006-01-02 15:04:05"}}</a></td><td>{{.Channel}}</td><td>{{template "line" .}}</td></tr>
+{{end}}</table>
+{{end}}`)
+
+var filesPage = web.Page(`{{define "title"}}Log files{{end}}
+{{define "content"}}
+<ul>{{range .}}<li><a href="{{.Link}}">{{.Name}}</a></li>{{else}}<li>No log files</li>{{end}}</ul>
+{{end}}`)
+
+// logFile is a log file as listed on the web
+type logFile struct {
+	Name, Link string
+}
+
+// logFileList returns the log files under logroot, sorted by name
+func logFileList() ([]logFile, error) {
+	var files []logFile
+	err := filepath.WalkDir(logroot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() || !isLogFile(p) {
+			return err
+		}
+		rel, err := filepath.Rel(logroot, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		parts := strings.Split(name, "/")
+		for i := range parts {
+			parts[i] = url.PathEscape(parts[i])
+		}
+		files = append(files, logFile{Name: name, Link: "/logs/files/" + strings.Join(parts, "/")})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, err
+}
+
+// filesHandler lists the log files at /logs/files/, and serves them at /logs/files/<path>. Nothing else in logroot,
+// like the search index or the opt-outs, is served.
+func filesHandler(w http.ResponseWriter, r *http.Request) {
+	rel := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(r.URL.Path, "/logs/files/")), "/")
+	if rel == "" {
+		files, err := logFileList()
+		if err != nil {
+			http.Error(w, "couldn't list the log files", http.StatusInternalServerError)
+			return
+		}
+		web.Render(w, filesPage, files)
+		return
+	}
+	file := filepath.Join(logroot, filepath.FromSlash(rel))
+	if !isLogFile(file) {
+		http.NotFound(w, r)
+		return
+	}
+	// no symlinks or directories
+	if fi, err := os.Lstat(file); err != nil || !fi.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, file)
+}
+
+// page is the data common to log viewer pages
+type page struct {
+	Network, Channel, Query, Nick string
+}
+
+// logsHandler serves the log viewer:
+//
+//	/logs/                          lists logged channels
+//	/logs/<network>/<channel>/      lists the days a channel has logs from
+//	/logs/<network>/<channel>/<day> shows the lines from a day, with permalinks to each line
+//	/logs/search                    searches the logs
+func logsHandler(w http.ResponseWriter, r *http.Request) {
+	if idx == nil {
+		http.Error(w, "The search index is off. Log files are at /logs/files/", http.StatusNotFound)
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/logs"), "/"), "/")
+	switch {
+	case parts[0] == "":
+		type channel struct {
+			page
+			Link string
+		}
+		var channels []channel
+		for _, k := range idx.channels() {
+			channels = append(channels, channel{page{Network: k.network, Channel: k.channel}, channelPath(k)})
+		}
+		web.Render(w, channelsPage, struct {
+			page
+			Channels []channel
+		}{Channels: channels})
+	case parts[0] == "search" && len(parts) == 1:
+		searchHandler(w, r)
+	case len(parts) == 2:
+		k := logKey{network: parts[0], channel: strings.ToLower(parts[1])}
+		days := idx.days(k)
+		if len(days) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		web.Render(w, daysPage, struct {
+			page
+			Link string
+			Days []string
+		}{page{Network: k.network, Channel: k.channel}, channelPath(k), days})
+	case len(parts) == 3:
+		k := logKey{network: parts[0], channel: strings.ToLower(parts[1])}
+		lines := idx.day(k, parts[2])
+		if len(lines) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		web.Render(w, dayPage, struct {
+			page
+			Day   string
+			Link  string
+			Lines []webLine
+		}{page{Network: k.network, Channel: k.channel}, parts[2], channelPath(k), webLines(lines)})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// searchHandler searches the logs for the regular expression in the "q" parameter, optionally limited to the
+// "network", "channel" and "nick" parameters
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	data := struct {
+		page
+		Error string
+		Total int
+		Lines []webLine
+	}{page: page{
+		Network: q.Get("network"),
+		Channel: strings.ToLower(q.Get("channel")),
+		Query:   q.Get("q"),
+		Nick:    q.Get("nick"),
+	}}
+	if data.Query != "" {
+		re, err := regexp.Compile(data.Query)
+		if err != nil {
+			data.Error = fmt.Sprintf("Invalid regular expression: %s", err)
+		} else {
+			results, total := idx.grep(re, func(r record) bool {
+				return (data.Network == "" || r.Network == data.Network) &&
+					(data.Channel == "" || r.Channel == data.Channel) &&
+					(data.Nick == "" || strings.EqualFold(r.Nick, data.Nick))
+			}, maxSearchResults)
+			data.Total, data.Lines = total, webLines(results)
+		}
+	}
+	web.Render(w, searchPage, data)
+}
+
+// registerWeb adds the log viewer to the bot's web server
+func registerWeb() {
+	web.HandleFunc("/logs/", logsHandler)
+	web.HandleFunc("/logs/files/", filesHandler)
+	web.AddNav("Logs", "/logs/")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_logsHandler(t *testing.T) {
+	ix, err := openIndex(filepath.Join(t.TempDir(), "index.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ix.close()
+	idx = ix
+	defer func() { idx = nil }()
+	day := time.Date(2024, time.March, 7, 12, 0, 0, 0, time.Local)
+	for i, text := range []string{"hello <world>", "bye"} {
+		ix.add(record{Time: day.Add(time.Duration(i) * time.Minute), Network: "libera", Channel: "#go", Nick: "alice", Text: text})
+	}
+
+	tests := []struct {
+		path     string
+		wantCode int
+		want     []string
+	}{
+		{"/logs/", 200, []string{`href="/logs/libera/%23go/"`}},
+		{"/logs/libera/%23go/", 200, []string{`href="/logs/libera/%23go/2024-03-07"`}},
+		{"/logs/libera/%23GO/2024-03-07", 200, []string{`id="l1"`, "hello &lt;world&gt;", `href="/logs/libera/%23go/2024-03-07#l2"`}},
+		{"/logs/libera/%23go/2024-03-08", 404, nil},
+		{"/logs/search?q=b.e", 200, []string{"1 matches", "bye"}},
+		{"/logs/search?q=(", 200, []string{"Invalid regular expression"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			logsHandler(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("page doesn't contain %q:\n%s", want, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func Test_filesHandler(t *testing.T) {
+	logroot = t.TempDir()
+	defer func() { logroot = "" }()
+	for name, content := range map[string]string{
+		"libera/2024/03/#go.log":    "a log",
+		"libera/2024/02/#go.log.gz": "an old log",
+		"conf/conf.yml":             "password: secret",
+		"index.jsonl":               "opted out lines",
+		"optout.json":               "[]",
+		"notes.log":                 "not where logs go",
+	} {
+		file := filepath.Join(logroot, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		path     string
+		wantCode int
+		want     string
+	}{
+		{"/logs/files/", 200, `href="/logs/files/libera/2024/03/%23go.log"`},
+		{"/logs/files/libera/2024/03/%23go.log", 200, "a log"},
+		{"/logs/files/libera/2024/02/%23go.log.gz", 200, "an old log"},
+		{"/logs/files/conf/conf.yml", 404, ""},
+		{"/logs/files/index.jsonl", 404, ""},
+		{"/logs/files/optout.json", 404, ""},
+		{"/logs/files/notes.log", 404, ""},
+		{"/logs/files/libera/2024/03/", 404, ""},
+		{"/logs/files/../../etc/passwd", 404, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			filesHandler(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("response doesn't contain %q:\n%s", tt.want, w.Body.String())
+			}
+		})
+	}
+	w := httptest.NewRecorder()
+	filesHandler(w, httptest.NewRequest("GET", "/logs/files/", nil))
+	for _, secret := range []string{"conf.yml", "index.jsonl", "optout.json", "notes.log"} {
+		if strings.Contains(w.Body.String(), secret) {
+			t.Errorf("the file list shows %s", secret)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	url2 "net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultRulesFile = "plugins/urlshort/rules.json"
+
+// maxRedirections is how many redirect wrappers are unwrapped from a URL, at most
+const maxRedirections = 5
+
+// rules are the ClearURLs providers used to clean URLs
+var rules []ruleProvider
+
+// ruleProvider is a set of rules for cleaning URLs from some site, in the format of ClearURLs
+// (https://docs.clearurls.xyz/latest/specs/rules/).
+type ruleProvider struct {
+	name       string
+	urlPattern *regexp.Regexp
+	// rules match the names of query parameters to remove. referralMarketing rules are included.
+	rules []*regexp.Regexp
+	// rawRules match parts of the URL to remove
+	rawRules []*regexp.Regexp
+	// exceptions match URLs the provider doesn't apply to
+	exceptions []*regexp.Regexp
+	// redirections match redirect wrappers, with the URL they wrap in the first group
+	redirections []*regexp.Regexp
+}
+
+// rulesFile is the JSON format of ClearURLs rules
+type rulesFile struct {
+	Providers map[string]struct {
+		URLPattern        string   `json:"urlPattern"`
+		CompleteProvider  bool     `json:"completeProvider"`
+		Rules             []string `json:"rules"`
+		RawRules          []string `json:"rawRules"`
+		ReferralMarketing []string `json:"referralMarketing"`
+		Exceptions        []string `json:"exceptions"`
+		Redirections      []string `json:"redirections"`
+	} `json:"providers"`
+}
+
+// loadRules reads ClearURLs rules from filename. Rules that aren't valid Go regular expressions are skipped with a
+// warning, so the official ClearURLs rules can be used as they are. Providers that block URLs altogether
+// (completeProvider) don't make sense for the bot, and are skipped.
+func loadRules(filename string) ([]ruleProvider, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var f rulesFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", filename, err)
+	}
+	compile := func(provider string, exprs []string, wrap func(string) string) []*regexp.Regexp {
+		res := make([]*regexp.Regexp, 0, len(exprs))
+		for _, expr := range exprs {
+			re, err := regexp.Compile(wrap(expr))
+			if err != nil {
+				log.Warnf("skipping rule %q of provider %s in %s: %s", expr, provider, filename, err)
+				continue
+			}
+			res = append(res, re)
+		}
+		return res
+	}
+	// parameter rules match whole parameter names, and ClearURLs compares them case insensitively
+	param := func(expr string) string { return "(?i)^(?:" + expr + ")$" }
+	asis := func(expr string) string { return "(?i)" + expr }
+
+	names := make([]string, 0, len(f.Providers))
+	for name := range f.Providers {
+		names = append(names, name)
+	}
+	// globalRules is applied last by ClearURLs, so site specific redirections are unwrapped first
+	sort.Slice(names, func(i, j int) bool {
+		if (names[i] == "globalRules") != (names[j] == "globalRules") {
+			return names[j] == "globalRules"
+		}
+		return names[i] < names[j]
+	})
+	providers := make([]ruleProvider, 0, len(names))
+	for _, name := range names {
+		def := f.Providers[name]
+		if def.CompleteProvider {
+			continue
+		}
+		pattern, err := regexp.Compile(asis(def.URLPattern))
+		if err != nil {
+			log.Warnf("skipping provider %s in %s: %s", name, filename, err)
+			continue
+		}
+		providers = append(providers, ruleProvider{
+			name:         name,
+			urlPattern:   pattern,
+			rules:        compile(name, append(def.Rules, def.ReferralMarketing...), param),
+			rawRules:     compile(name, def.RawRules, asis),
+			exceptions:   compile(name, def.Exceptions, asis),
+			redirections: compile(name, def.Redirections, asis),
+		})
+	}
+	return providers, nil
+}
+
+// matches returns whether p applies to url
+func (p ruleProvider) matches(url string) bool {
+	if !p.urlPattern.MatchString(url) {
+		return false
+	}
+	for _, re := range p.exceptions {
+		if re.MatchString(url) {
+			return false
+		}
+	}
+	return true
+}
+
+// unwrap returns the URL wrapped in url, if url is a redirect wrapper known to p
+func (p ruleProvider) unwrap(url string) (string, bool) {
+	for _, re := range p.redirections {
+		m := re.FindStringSubmatch(url)
+		if len(m) < 2 || m[1] == "" {
+			continue
+		}
+		target, err := url2.QueryUnescape(m[1])
+		if err != nil {
+			target = m[1]
+		}
+		// AMP caches leave out the scheme
+		if !strings.Contains(target, "://") {
+			target = "https://" + target
+		}
+		return target, true
+	}
+	return url, false
+}
+
+// removeParams removes the query parameters of values matching any of p's rules, and returns whether any were removed
+func (p ruleProvider) removeParams(values url2.Values) bool {
+	removed := false
+	for k := range values {
+		for _, re := range p.rules {
+			if re.MatchString(k) {
+				values.Del(k)
+				removed = true
+				log.Infof("removed tracking parameter %q from url", k)
+				break
+			}
+		}
+	}
+	return removed
+}
+
+// applyRules cleans url with the ClearURLs rules in providers: redirect wrappers are unwrapped, and then raw rules and
+// parameter rules of the providers matching the URL are applied.
+func applyRules(url string, providers []ruleProvider) (string, error) {
+	for i := 0; i < maxRedirections; i++ {
+		unwrapped := false
+		for _, p := range providers {
+			if !p.matches(url) {
+				continue
+			}
+			if url, unwrapped = p.unwrap(url); unwrapped {
+				log.Infof("unwrapped redirect to %q", url)
+				break
+			}
+		}
+		if !unwrapped {
+			break
+		}
+	}
+	var matching []ruleProvider
+	for _, p := range providers {
+		if !p.matches(url) {
+			continue
+		}
+		matching = append(matching, p)
+		for _, re := range p.rawRules {
+			url = re.ReplaceAllString(url, "")
+		}
+	}
+	u, err := url2.Parse(url)
+	if err != nil {
+		return "", err
+	}
+	if u.RawQuery == "" {
+		return url, nil
+	}
+	v := u.Query()
+	removed := false
+	for _, p := range matching {
+		removed = p.removeParams(v) || removed
+	}
+	if !removed {
+		return url, nil
+	}
+	u.RawQuery = v.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func Test_applyRules(t *testing.T) {
+	providers, err := loadRules("rules.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"untouched", "https://example.com/page?id=42", "https://example.com/page?id=42"},
+		{"utm prefix", "https://example.com/page?utm_source=x&id=42&utm_whatever=y", "https://example.com/page?id=42"},
+		{"youtube si", "https://youtu.be/dQw4w9WgXcQ?si=abcdef", "https://youtu.be/dQw4w9WgXcQ"},
+		{"si elsewhere", "https://example.com/?si=abcdef", "https://example.com/?si=abcdef"},
+		{"facebook redirect", "https://l.facebook.com/l.php?u=https%3A%2F%2Fexample.com%2Fpage%3Fid%3D42%26fbclid%3Dabc&h=AT0",
+			"https://example.com/page?id=42"},
+		{"google amp", "https://www.google.com/amp/s/example.com/news/story.amp", "https://example.com/news/story.amp"},
+		{"amp cache", "https://example-com.cdn.ampproject.org/c/s/example.com/news/story", "https://example.com/news/story"},
+		{"raw rule", "https://www.amazon.com/dp/B000000000/ref=sr_1_1?qid=123&keywords=thing", "https://www.amazon.com/dp/B000000000"},
+		{"exception", "https://github.com/owner/repo/blob/main/x.go?ref=v1", "https://github.com/owner/repo/blob/main/x.go?ref=v1"},
+		{"referral elsewhere", "https://example.com/?ref=newsletter", "https://example.com/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyRules(tt.url, providers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("applyRules(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loadRules_invalid(t *testing.T) {
+	// lookaheads are fine in ClearURLs' JavaScript, but not in Go. They're skipped.
+	dir := t.TempDir()
+	file := dir + "/rules.json"
+	if err := saveJSON(file, map[string]interface{}{"providers": map[string]interface{}{
+		"site": map[string]interface{}{"urlPattern": "^https?://site", "rules": []string{"(?!keep)x", "tracker"}},
+		"gone": map[string]interface{}{"urlPattern": "^https?://gone", "completeProvider": true},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	providers, err := loadRules(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(providers) != 1 || len(providers[0].rules) != 1 {
+		t.Fatalf("loadRules() = %+v, want one provider with one rule", providers)
+	}
+	if got, _ := applyRules("https://site/?tracker=1&x=2", providers); got != "https://site/?x=2" {
+		t.Errorf("applyRules() = %q", got)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	url2 "net/url"
+	"time"
+)
+
+const (
+	defaultTimeout   = 10 * time.Second
+	defaultUserAgent = "Mozilla/5.0 (compatible; bender IRC bot)"
+)
+
+var (
+	// httpClient is used to talk to shortening services
+	httpClient = newClient(defaultTimeout, nil)
+	// userAgent is sent with every request
+	userAgent = defaultUserAgent
+	// proxyURL is the configured proxy, for titles too. If it's nil, the proxy is taken from the environment.
+	proxyURL *url2.URL
+)
+
+// newClient returns an HTTP client with a timeout. If proxy is nil, the proxy is taken from the environment
+// (HTTPS_PROXY, HTTP_PROXY and NO_PROXY).
+func newClient(timeout time.Duration, proxy *url2.URL) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// configureClient reads the "timeout", "proxy" and "user_agent" directives, and sets up the HTTP client used to talk
+// to shortening services
+func configureClient(c map[interface{}]interface{}) error {
+	timeout := defaultTimeout
+	if t, ok := c["timeout"]; ok {
+		n, ok := t.(int)
+		if !ok || n <= 0 {
+			return errors.New("invalid timeout format, expected seconds")
+		}
+		timeout = time.Duration(n) * time.Second
+	}
+	var proxy *url2.URL
+	if p, ok := c["proxy"]; ok {
+		ps, ok := p.(string)
+		if !ok {
+			return errors.New("invalid proxy format")
+		}
+		var err error
+		if proxy, err = url2.Parse(ps); err != nil || proxy.Host == "" {
+			return fmt.Errorf("invalid proxy %q", ps)
+		}
+	}
+	userAgent = defaultUserAgent
+	if ua, ok := c["user_agent"]; ok {
+		if userAgent, ok = ua.(string); !ok {
+			return errors.New("invalid user_agent format")
+		}
+	}
+	httpClient = newClient(timeout, proxy)
+	proxyURL = proxy
+	return nil
+}
+
+// endpoint returns the API endpoint of the built-in service `name`. It's def, unless it's overridden in the
+// "endpoints" section of the configuration.
+func endpoint(c map[interface{}]interface{}, name, def string) (string, error) {
+	eps, ok := c["endpoints"]
+	if !ok {
+		return def, nil
+	}
+	m, ok := eps.(map[interface{}]interface{})
+	if !ok {
+		return "", errors.New("invalid endpoints format")
+	}
+	ep, ok := m[name]
+	if !ok {
+		return def, nil
+	}
+	s, ok := ep.(string)
+	if !ok {
+		return "", fmt.Errorf("invalid endpoint format for %s", name)
+	}
+	return s, nil
+}
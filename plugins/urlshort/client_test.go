@@ -0,0 +1,227 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	url2 "net/url"
+	"testing"
+	"time"
+
+	"github.com/valyala/fastjson"
+)
+
+const longURL = "https://example.com/a/rather/long/path?with=query&and=more"
+
+// upstream is a fake shortening service. It checks the request, and replies with status and body.
+func upstream(t *testing.T, check func(r *http.Request, body string), status int, reply string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		check(r, string(raw))
+		w.WriteHeader(status)
+		w.Write([]byte(reply))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func Test_builtinProviders(t *testing.T) {
+	apikey, customDomain, userAgent = "k3y", "", "test agent"
+	defer func() { apikey, customDomain, userAgent = "", "", defaultUserAgent }()
+
+	// jsonField checks that the JSON body has field set to want
+	jsonField := func(t *testing.T, body, field, want string) {
+		t.Helper()
+		if got := fastjson.GetString([]byte(body), field); got != want {
+			t.Errorf("%s = %q in body %s, want %q", field, got, body, want)
+		}
+	}
+	tests := []struct {
+		service string
+		domain  string
+		// auth is the expected Authorization header
+		auth  string
+		check func(t *testing.T, r *http.Request, body string)
+		reply string
+		want  string
+	}{
+		{
+			service: "bitly",
+			auth:    "Bearer k3y",
+			check: func(t *testing.T, r *http.Request, body string) {
+				jsonField(t, body, "long_url", longURL)
+				jsonField(t, body, "domain", "bit.ly")
+			},
+			reply: `{"link": "https://bit.ly/abc", "id": "bit.ly/abc"}`,
+			want:  "https://bit.ly/abc",
+		},
+		{
+			service: "bitly",
+			domain:  "sho.rt",
+			auth:    "Bearer k3y",
+			check: func(t *testing.T, r *http.Request, body string) {
+				jsonField(t, body, "domain", "sho.rt")
+			},
+			reply: `{"link": "https://sho.rt/abc"}`,
+			want:  "https://sho.rt/abc",
+		},
+		{
+			service: "tinyurl",
+			auth:    "Bearer k3y",
+			check: func(t *testing.T, r *http.Request, body string) {
+				jsonField(t, body, "url", longURL)
+			},
+			reply: `{"data": {"tiny_url": "https://tinyurl.com/abc"}, "code": 0}`,
+			want:  "https://tinyurl.com/abc",
+		},
+		{
+			service: "cleanuri",
+			auth:    "Bearer k3y",
+			check: func(t *testing.T, r *http.Request, body string) {
+				jsonField(t, body, "url", longURL)
+			},
+			reply: `{"result_url": "https://cleanuri.com/abc"}`,
+			want:  "https://cleanuri.com/abc",
+		},
+		{
+			service: "isgd",
+			auth:    "Bearer k3y",
+			check: func(t *testing.T, r *http.Request, body string) {
+				if ct := r.Header.Get("Content-Type"); ct != "application/x-www-form-urlencoded" {
+					t.Errorf("Content-Type = %q", ct)
+				}
+				form, err := url2.ParseQuery(body)
+				if err != nil || form.Get("url") != longURL || form.Get("format") != "json" {
+					t.Errorf("form = %v, %v", form, err)
+				}
+			},
+			reply: `{"shorturl": "https://is.gd/abc"}`,
+			want:  "https://is.gd/abc",
+		},
+		{
+			service: "shortio",
+			domain:  "sho.rt",
+			auth:    "k3y",
+			check: func(t *testing.T, r *http.Request, body string) {
+				jsonField(t, body, "originalURL", longURL)
+				jsonField(t, body, "domain", "sho.rt")
+			},
+			reply: `{"shortURL": "https://sho.rt/abc"}`,
+			want:  "https://sho.rt/abc",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.service, func(t *testing.T) {
+			customDomain = tt.domain
+			srv := upstream(t, func(r *http.Request, body string) {
+				if r.Method != http.MethodPost {
+					t.Errorf("method = %s, want POST", r.Method)
+				}
+				if got := r.Header.Get("Authorization"); got != tt.auth {
+					t.Errorf("Authorization = %q, want %q", got, tt.auth)
+				}
+				if got := r.Header.Get("User-Agent"); got != "test agent" {
+					t.Errorf("User-Agent = %q", got)
+				}
+				tt.check(t, r, body)
+			}, http.StatusOK, tt.reply)
+			p, err := newProvider(tt.service, conf(t, "endpoints: {"+tt.service+": "+srv.URL+"}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err := p.shorten(longURL); err != nil || got != tt.want {
+				t.Errorf("shorten() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_builtinProviders_errors(t *testing.T) {
+	customDomain = "sho.rt"
+	defer func() { customDomain = "" }()
+	replies := []struct {
+		name   string
+		status int
+		reply  string
+	}{
+		{"error status", http.StatusBadRequest, `{"message": "INVALID_ARG_LONG_URL"}`},
+		{"server error", http.StatusInternalServerError, "oops"},
+		{"no link", http.StatusOK, `{"something": "else"}`},
+		{"not json", http.StatusOK, "<html>hello</html>"},
+		{"empty", http.StatusOK, ""},
+	}
+	for _, service := range []string{"bitly", "tinyurl", "cleanuri", "isgd", "shortio"} {
+		for _, r := range replies {
+			t.Run(service+"/"+r.name, func(t *testing.T) {
+				srv := upstream(t, func(*http.Request, string) {}, r.status, r.reply)
+				p, err := newProvider(service, conf(t, "endpoints: {"+service+": "+srv.URL+"}"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, err := p.shorten(longURL); err == nil {
+					t.Errorf("shorten() = %q, want an error", got)
+				}
+			})
+		}
+	}
+	// nothing listening
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	p, err := newProvider("cleanuri", conf(t, "endpoints: {cleanuri: "+srv.URL+"}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.shorten(longURL); err == nil {
+		t.Error("shorten() with the service down succeeded")
+	}
+}
+
+func Test_configureClient(t *testing.T) {
+	defer func() {
+		httpClient, userAgent, proxyURL = newClient(defaultTimeout, nil), defaultUserAgent, nil
+	}()
+	for _, bad := range []string{"timeout: soon", "timeout: -1", "proxy: 42", "proxy: '::'", "user_agent: [x]"} {
+		if err := configureClient(conf(t, bad)); err == nil {
+			t.Errorf("configureClient(%s) succeeded", bad)
+		}
+	}
+	if _, err := endpoint(conf(t, "endpoints: [x]"), "bitly", bitlyAPIUrl); err == nil {
+		t.Error("endpoint() with a list of endpoints succeeded")
+	}
+	if ep, err := endpoint(conf(t, "endpoints: {tinyurl: x}"), "bitly", bitlyAPIUrl); err != nil || ep != bitlyAPIUrl {
+		t.Errorf("endpoint() = %q, %v, want the default", ep, err)
+	}
+
+	// requests go through the proxy
+	var proxied string
+	proxy := upstream(t, func(r *http.Request, _ string) { proxied = r.URL.String() }, http.StatusOK,
+		`{"result_url": "https://cleanuri.com/abc"}`)
+	if err := configureClient(conf(t, "{proxy: "+proxy.URL+", user_agent: agent}")); err != nil {
+		t.Fatal(err)
+	}
+	p, err := newProvider("cleanuri", conf(t, "endpoints: {cleanuri: 'http://shortener.invalid/api'}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.shorten(longURL); err != nil || got != "https://cleanuri.com/abc" {
+		t.Errorf("shorten() through a proxy = %q, %v", got, err)
+	}
+	if proxied != "http://shortener.invalid/api" {
+		t.Errorf("proxy got a request for %q", proxied)
+	}
+
+	// slow services time out
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer slow.Close()
+	httpClient = newClient(50*time.Millisecond, nil)
+	p, err = newProvider("cleanuri", conf(t, "endpoints: {cleanuri: "+slow.URL+"}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.shorten(longURL); err == nil {
+		t.Error("shorten() of a slow service didn't time out")
+	}
+}
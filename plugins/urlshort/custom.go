@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	url2 "net/url"
+	"strings"
+	"text/template"
+
+	"github.com/valyala/fastjson"
+	"gopkg.in/yaml.v2"
+)
+
+// templateProvider is a shortening service defined in the configuration:
+//
+//	providers:
+//	  myshortener:
+//	    url: "https://short.example.com/api?url={{query .URL}}"
+//	    method: POST
+//	    headers:
+//	      X-API-Key: "{{.APIKey}}"
+//	    body: '{"long": {{json .URL}}, "domain": {{json .Domain}}}'
+//	    content_type: application/json
+//	    result: "data.short"
+//
+// The url, headers and body are templates, with the URL to shorten, the API key and the custom domain. Result is the
+// dot separated path to the short link in the JSON reply. If it's empty, the whole reply is the short link.
+type templateProvider struct {
+	name        string
+	method      string
+	url         *template.Template
+	body        *template.Template
+	headers     map[string]*template.Template
+	contentType string
+	result      []string
+	client      *http.Client
+}
+
+// templateDef is the configuration of a templateProvider
+type templateDef struct {
+	URL         string            `yaml:"url"`
+	Method      string            `yaml:"method"`
+	Headers     map[string]string `yaml:"headers"`
+	Body        string            `yaml:"body"`
+	ContentType string            `yaml:"content_type"`
+	Result      string            `yaml:"result"`
+}
+
+// templateData is what the templates of a templateProvider are executed with
+type templateData struct {
+	URL    string
+	APIKey string
+	Domain string
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(s string) (string, error) {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		err := enc.Encode(s)
+		return strings.TrimSuffix(buf.String(), "\n"), err
+	},
+	"query": url2.QueryEscape,
+}
+
+// newTemplateProvider makes a templateProvider from its definition in the configuration
+func newTemplateProvider(name string, def interface{}) (provider, error) {
+	raw, err := yaml.Marshal(def)
+	if err != nil {
+		return nil, err
+	}
+	var d templateDef
+	if err := yaml.Unmarshal(raw, &d); err != nil {
+		return nil, fmt.Errorf("invalid definition of provider %q: %w", name, err)
+	}
+	if d.URL == "" {
+		return nil, fmt.Errorf("provider %q has no url", name)
+	}
+	p := &templateProvider{
+		name:        name,
+		method:      strings.ToUpper(d.Method),
+		contentType: d.ContentType,
+		headers:     make(map[string]*template.Template),
+		client:      httpClient,
+	}
+	if p.method == "" {
+		p.method = http.MethodPost
+		if d.Body == "" {
+			p.method = http.MethodGet
+		}
+	}
+	if d.Result != "" {
+		p.result = strings.Split(d.Result, ".")
+	}
+	parse := func(what, text string) (*template.Template, error) {
+		t, err := template.New(what).Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s template in provider %q: %w", what, name, err)
+		}
+		return t, nil
+	}
+	if p.url, err = parse("url", d.URL); err != nil {
+		return nil, err
+	}
+	if d.Body != "" {
+		if p.body, err = parse("body", d.Body); err != nil {
+			return nil, err
+		}
+	}
+	for header, value := range d.Headers {
+		if p.headers[header], err = parse(header, value); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// execute executes t with data, and returns the result
+func execute(t *template.Template, data templateData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (p *templateProvider) shorten(url string) (string, error) {
+	data := templateData{URL: url, APIKey: apikey, Domain: customDomain}
+	endpoint, err := execute(p.url, data)
+	if err != nil {
+		return "", err
+	}
+	var body io.Reader
+	if p.body != nil {
+		b, err := execute(p.body, data)
+		if err != nil {
+			return "", err
+		}
+		body = strings.NewReader(b)
+	}
+	req, err := http.NewRequest(p.method, endpoint, body)
+	if err != nil {
+		return "", err
+	}
+	if p.contentType != "" {
+		req.Header.Set("Content-Type", p.contentType)
+	}
+	for header, t := range p.headers {
+		value, err := execute(t, data)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set(header, value)
+	}
+	result, err := doRequest(p.client, req)
+	if err != nil {
+		return "", err
+	}
+	if len(p.result) == 0 {
+		if link := strings.TrimSpace(string(result)); link != "" {
+			return link, nil
+		}
+		return "", fmt.Errorf("empty reply from %s", p.name)
+	}
+	if link := fastjson.GetString(result, p.result...); link != "" {
+		return link, nil
+	}
+	return "", fmt.Errorf("no short link at %q in reply from %s: %q", strings.Join(p.result, "."), p.name, string(result))
+}
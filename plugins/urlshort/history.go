@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adamhassel/bender/internal/helpers"
+)
+
+const (
+	defaultCacheDB    = "db/urlcache.json"
+	defaultHistoryDB  = "db/urlhistory.json"
+	defaultRepostDays = 30
+)
+
+var (
+	// cacheDB is where short links are cached, by the cleaned URL. If it's empty, caching is off.
+	cacheDB string
+	cm      sync.Mutex
+	cache   map[string]string
+
+	// reposts turns repost detection on
+	reposts bool
+	// repostWindow is how long links are remembered
+	repostWindow = defaultRepostDays * 24 * time.Hour
+	historyDB    string
+	hm           sync.Mutex
+	// history holds who first posted each cleaned URL, by "network/channel"
+	history map[string]map[string]post
+)
+
+// post is the first time a link was posted in a channel
+type post struct {
+	Nick string    `json:"nick"`
+	Time time.Time `json:"time"`
+}
+
+// loadJSON reads the JSON in filename into v. It's not an error if the file doesn't exist.
+func loadJSON(filename string, v interface{}) error {
+	raw, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("error parsing %s: %w", filename, err)
+	}
+	return nil
+}
+
+// saveJSON writes v as JSON to filename
+func saveJSON(filename string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	return helpers.WriteFileAtomic(filename, raw, 0600)
+}
+
+// cachedLink returns the cached short link for url
+func cachedLink(url string) (string, bool) {
+	cm.Lock()
+	defer cm.Unlock()
+	link, ok := cache[url]
+	return link, ok
+}
+
+// cacheLink caches the short link for url, and saves the cache
+func cacheLink(url, link string) error {
+	if cacheDB == "" {
+		return nil
+	}
+	cm.Lock()
+	defer cm.Unlock()
+	cache[url] = link
+	return saveJSON(cacheDB, cache)
+}
+
+// channelKey returns the key in history for channel on network
+func channelKey(network, channel string) string {
+	return strings.ToLower(network + "/" + channel)
+}
+
+// seen records that nick posted url in channel on network at t, unless it has been posted there within the repost
+// window. Then it returns the first post, and true.
+func seen(network, channel, url, nick string, t time.Time) (post, bool, error) {
+	hm.Lock()
+	defer hm.Unlock()
+	k := channelKey(network, channel)
+	if p, ok := history[k][url]; ok && t.Sub(p.Time) < repostWindow {
+		return p, true, nil
+	}
+	if history[k] == nil {
+		history[k] = make(map[string]post)
+	}
+	history[k][url] = post{Nick: nick, Time: t}
+	// forget links older than the window while we're at it
+	for _, posts := range history {
+		for u, p := range posts {
+			if t.Sub(p.Time) >= repostWindow {
+				delete(posts, u)
+			}
+		}
+	}
+	return post{}, false, saveJSON(historyDB, history)
+}
+
+// ago formats how long ago t was, roughly
+func ago(t, now time.Time) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	}
+	return plural(int(d/(24*time.Hour)), "day")
+}
+
+// configureHistory reads the "cache", "cache_db", "reposts", "repost_days" and "history_db" directives, and loads the
+// cache and the history
+func configureHistory(c map[interface{}]interface{}) error {
+	cacheDB = defaultCacheDB
+	if db, ok := c["cache_db"]; ok {
+		if cacheDB, ok = db.(string); !ok {
+			return errors.New("invalid cache_db format")
+		}
+	}
+	if on, ok := c["cache"]; ok {
+		b, ok := on.(bool)
+		if !ok {
+			return errors.New("invalid cache format")
+		}
+		if !b {
+			cacheDB = ""
+		}
+	}
+	cache = make(map[string]string)
+	if cacheDB != "" {
+		if err := loadJSON(cacheDB, &cache); err != nil {
+			return err
+		}
+	}
+
+	reposts = false
+	if r, ok := c["reposts"]; ok {
+		if reposts, ok = r.(bool); !ok {
+			return errors.New("invalid reposts format")
+		}
+	}
+	repostWindow = defaultRepostDays * 24 * time.Hour
+	if d, ok := c["repost_days"]; ok {
+		days, ok := d.(int)
+		if !ok || days <= 0 {
+			return errors.New("invalid repost_days format")
+		}
+		repostWindow = time.Duration(days) * 24 * time.Hour
+	}
+	historyDB = defaultHistoryDB
+	if db, ok := c["history_db"]; ok {
+		if historyDB, ok = db.(string); !ok {
+			return errors.New("invalid history_db format")
+		}
+	}
+	history = make(map[string]map[string]post)
+	if reposts {
+		return loadJSON(historyDB, &history)
+	}
+	return nil
+}
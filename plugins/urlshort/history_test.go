@@ -0,0 +1,93 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// countingProvider counts the links it's asked for
+type countingProvider struct{ calls int }
+
+func (p *countingProvider) shorten(url string) (string, error) {
+	p.calls++
+	return "https://sho.rt/x", nil
+}
+
+func Test_shortenUrl_cache(t *testing.T) {
+	dir := t.TempDir()
+	if err := configureHistory(conf(t, "cache_db: "+filepath.Join(dir, "cache.json"))); err != nil {
+		t.Fatal(err)
+	}
+	cleanup = false
+	var p countingProvider
+	for i := 0; i < 3; i++ {
+		if link, err := shortenUrl("https://example.com/some/long/path", &p); err != nil || link != "https://sho.rt/x" {
+			t.Fatalf("shortenUrl() = %q, %v", link, err)
+		}
+	}
+	if p.calls != 1 {
+		t.Errorf("provider asked %d times, want 1", p.calls)
+	}
+	// the cache survives a restart
+	if err := configureHistory(conf(t, "cache_db: "+filepath.Join(dir, "cache.json"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shortenUrl("https://example.com/some/long/path", &p); err != nil || p.calls != 1 {
+		t.Errorf("provider asked %d times after reloading, want 1 (error %v)", p.calls, err)
+	}
+	if err := configureHistory(conf(t, "cache: false")); err != nil {
+		t.Fatal(err)
+	}
+	shortenUrl("https://example.com/some/long/path", &p)
+	shortenUrl("https://example.com/some/long/path", &p)
+	if p.calls != 3 {
+		t.Errorf("provider asked %d times with the cache off, want 3", p.calls)
+	}
+}
+
+func Test_seen(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "history.json")
+	if err := configureHistory(conf(t, "{cache: false, reposts: true, repost_days: 7, history_db: "+db+"}")); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if _, ok, err := seen("net", "#chan", "https://example.com/", "alice", start); ok || err != nil {
+		t.Fatalf("first post: repost = %v, error %v", ok, err)
+	}
+	first, ok, _ := seen("net", "#Chan", "https://example.com/", "bob", start.Add(3*24*time.Hour))
+	if !ok || first.Nick != "alice" || !first.Time.Equal(start) {
+		t.Errorf("repost: got %+v, %v, want alice's post", first, ok)
+	}
+	if _, ok, _ := seen("net", "#other", "https://example.com/", "bob", start.Add(time.Hour)); ok {
+		t.Error("a post in another channel is a repost")
+	}
+	// history survives a restart
+	if err := configureHistory(conf(t, "{cache: false, reposts: true, repost_days: 7, history_db: "+db+"}")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := seen("net", "#chan", "https://example.com/", "carol", start.Add(6*24*time.Hour)); !ok {
+		t.Error("history was lost on reload")
+	}
+	if _, ok, _ := seen("net", "#chan", "https://example.com/", "carol", start.Add(8*24*time.Hour)); ok {
+		t.Error("a post older than the window is a repost")
+	}
+}
+
+func Test_ago(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{10 * time.Second, "just now"},
+		{time.Minute, "1 minute ago"},
+		{5 * time.Hour, "5 hours ago"},
+		{3*24*time.Hour + time.Hour, "3 days ago"},
+	}
+	for _, tt := range tests {
+		if got := ago(now.Add(-tt.d), now); got != tt.want {
+			t.Errorf("ago(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
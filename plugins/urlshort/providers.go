@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	url2 "net/url"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fastjson"
+)
+
+// provider is a URL shortening service
+type provider interface {
+	// shorten returns a short link for url
+	shorten(url string) (string, error)
+}
+
+// providerFactory makes a provider from the plugin configuration
+type providerFactory func(c map[interface{}]interface{}) (provider, error)
+
+// providerFactories holds the built-in providers by name
+var providerFactories = make(map[string]providerFactory)
+
+// registerProvider makes a built-in provider available by its names
+func registerProvider(f providerFactory, names ...string) {
+	for _, name := range names {
+		providerFactories[name] = f
+	}
+}
+
+// newProvider returns the provider called `name`. It's either built in, or defined in the "providers" section of the
+// configuration.
+func newProvider(name string, c map[interface{}]interface{}) (provider, error) {
+	name = strings.ToLower(name)
+	if defs, ok := c["providers"]; ok {
+		m, ok := defs.(map[interface{}]interface{})
+		if !ok {
+			return nil, errors.New("invalid providers format")
+		}
+		if def, ok := m[name]; ok {
+			return newTemplateProvider(name, def)
+		}
+	}
+	if f, ok := providerFactories[name]; ok {
+		return f(c)
+	}
+	names := make([]string, 0, len(providerFactories))
+	for n := range providerFactories {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown service %q, expected one of %s, or one defined in providers", name,
+		strings.Join(names, ", "))
+}
+
+// apiProvider is a shortening service with a JSON API
+type apiProvider struct {
+	endpoint string
+	client   *http.Client
+	// request makes the request to shorten url
+	request func(endpoint, url string) (*http.Request, error)
+	// resultKey is the path to the short link in the JSON reply
+	resultKey []string
+	// rawToken sends the API key as is in the Authorization header, rather than as a bearer token
+	rawToken bool
+}
+
+func (p apiProvider) shorten(url string) (string, error) {
+	req, err := p.request(p.endpoint, url)
+	if err != nil {
+		return "", err
+	}
+	if len(apikey) > 0 {
+		if p.rawToken {
+			req.Header.Set("Authorization", apikey)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+apikey)
+		}
+	}
+	result, err := doRequest(p.client, req)
+	if err != nil {
+		return "", err
+	}
+	if link := fastjson.GetString(result, p.resultKey...); link != "" {
+		return link, nil
+	}
+	return "", fmt.Errorf("no short link in reply %q", string(result))
+}
+
+// doRequest sends req with client, and returns the body of the reply. Replies with an error status are errors.
+func doRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	if req.Header.Get("Content-Type") == "" && req.Body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if dout, err := httputil.DumpRequest(req, true); err == nil {
+		log.Debugf("Request: %s", string(dout))
+	} else {
+		log.Debug(err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	result, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug(string(result))
+	if res.StatusCode >= 300 {
+		return nil, fmt.Errorf("got error reply from upstream: %s, body %q", res.Status, string(result))
+	}
+	return result, nil
+}
+
+// builtin returns the factory of a built-in apiProvider. Its endpoint is def, unless it's overridden for `name` in
+// the "endpoints" section of the configuration.
+func builtin(name, def string, p apiProvider) providerFactory {
+	return func(c map[interface{}]interface{}) (provider, error) {
+		ep, err := endpoint(c, name, def)
+		if err != nil {
+			return nil, err
+		}
+		p.endpoint, p.client = ep, httpClient
+		return p, nil
+	}
+}
+
+func init() {
+	registerProvider(builtin("bitly", bitlyAPIUrl, apiProvider{request: bitlyShortUrl, resultKey: []string{"link"}}),
+		"bitly", "bit.ly")
+	registerProvider(builtin("tinyurl", tinyurlAPIUrl, apiProvider{request: tinyURLShortUrl, resultKey: []string{"data", "tiny_url"}}),
+		"tinyurl", "tinyurl.com")
+	registerProvider(builtin("cleanuri", cleanuriAPIUrl, apiProvider{request: cleanuriShortUrl, resultKey: []string{"result_url"}}),
+		"cleanuri", "clean")
+	registerProvider(builtin("isgd", isgdAPIUrl, apiProvider{request: isgdShortUrl, resultKey: []string{"shorturl"}}),
+		"isgd", "is.gd")
+	shortio := builtin("shortio", shortioAPIUrl, apiProvider{request: shortioShortUrl, resultKey: []string{"shortURL"}, rawToken: true})
+	registerProvider(func(c map[interface{}]interface{}) (provider, error) {
+		if customDomain == "" {
+			return nil, ErrNoCustomDomain
+		}
+		return shortio(c)
+	}, "shortio", "short.io")
+}
+
+func bitlyShortUrl(endpoint, url string) (*http.Request, error) {
+	cd := `"domain":"bit.ly",`
+	if customDomain != "" {
+		cd = `"domain":"` + customDomain + `",`
+	}
+	body := fmt.Sprintf(` {`+cd+`
+		"long_url" : %q
+		}`, url)
+	return http.NewRequest("POST", endpoint, bytes.NewBufferString(body))
+}
+
+func shortioShortUrl(endpoint, url string) (*http.Request, error) {
+	if customDomain == "" {
+		return nil, ErrNoCustomDomain
+	}
+	cd := `"domain":"` + customDomain + `",`
+	body := fmt.Sprintf(` {`+cd+`
+		"originalURL" : %q
+		}`, url)
+	return http.NewRequest("POST", endpoint, bytes.NewBufferString(body))
+}
+
+func tinyURLShortUrl(endpoint, url string) (*http.Request, error) {
+	var cd string
+	if customDomain != "" {
+		cd = `"domain":"` + customDomain + `",`
+	}
+	body := fmt.Sprintf(` {`+cd+`
+		"url" : %q
+		}`, url)
+	return http.NewRequest("POST", endpoint, bytes.NewBufferString(body))
+}
+
+func cleanuriShortUrl(endpoint, url string) (*http.Request, error) {
+	body := fmt.Sprintf(` { "url" : %q }`, url)
+	return http.NewRequest("POST", endpoint, bytes.NewBufferString(body))
+}
+
+func isgdShortUrl(endpoint, url string) (*http.Request, error) {
+	body := fmt.Sprintf("format=json&url=%s", url2.QueryEscape(url))
+	req, err := http.NewRequest("POST", endpoint, bytes.NewBufferString(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
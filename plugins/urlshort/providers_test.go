@@ -0,0 +1,166 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// conf parses plugin configuration like the bot does
+func conf(t *testing.T, s string) map[interface{}]interface{} {
+	t.Helper()
+	c := make(map[interface{}]interface{})
+	if err := yaml.Unmarshal([]byte(s), &c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func Test_newProvider(t *testing.T) {
+	for _, name := range []string{"bitly", "TinyURL", "cleanuri", "is.gd"} {
+		if _, err := newProvider(name, nil); err != nil {
+			t.Errorf("newProvider(%q) error = %v", name, err)
+		}
+	}
+	customDomain = ""
+	if _, err := newProvider("shortio", nil); err != ErrNoCustomDomain {
+		t.Errorf("newProvider(shortio) without a custom domain, error = %v, want %v", err, ErrNoCustomDomain)
+	}
+	if _, err := newProvider("nosuchthing", nil); err == nil || !strings.Contains(err.Error(), "tinyurl") {
+		t.Errorf("newProvider() of an unknown service, error = %v, want a list of services", err)
+	}
+	if _, err := newProvider("mine", conf(t, "providers: {mine: {method: POST}}")); err == nil {
+		t.Error("newProvider() of a provider without a url succeeded")
+	}
+}
+
+func Test_templateProvider(t *testing.T) {
+	var gotBody, gotKey, gotQuery, gotType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		gotBody, gotKey, gotQuery, gotType = string(raw), r.Header.Get("X-Key"), r.URL.Query().Get("u"), r.Header.Get("Content-Type")
+		switch r.URL.Path {
+		case "/json":
+			w.Write([]byte(`{"data": {"short": "https://sho.rt/abc"}}`))
+		case "/plain":
+			w.Write([]byte("https://sho.rt/def\n"))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "nope"}`))
+		}
+	}))
+	defer srv.Close()
+	apikey, customDomain = "k3y", "sho.rt"
+	defer func() { apikey, customDomain = "", "" }()
+
+	c := conf(t, `
+providers:
+  json:
+    url: "`+srv.URL+`/json"
+    headers: {X-Key: "{{.APIKey}}"}
+    body: '{"long": {{json .URL}}, "domain": {{json .Domain}}}'
+    content_type: application/json
+    result: data.short
+  plain:
+    url: "`+srv.URL+`/plain?u={{query .URL}}"
+  broken:
+    url: "`+srv.URL+`/broken"
+    body: "x"
+    result: data.short
+  missing:
+    url: "`+srv.URL+`/json"
+    result: data.long
+`)
+	long := `https://example.com/a "quoted" path?x=1&y=2`
+
+	p, err := newProvider("json", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.shorten(long); err != nil || got != "https://sho.rt/abc" {
+		t.Errorf("shorten() = %q, %v", got, err)
+	}
+	if want := `{"long": "https://example.com/a \"quoted\" path?x=1&y=2", "domain": "sho.rt"}`; gotBody != want {
+		t.Errorf("body = %s, want %s", gotBody, want)
+	}
+	if gotKey != "k3y" || gotType != "application/json" {
+		t.Errorf("headers X-Key = %q, Content-Type = %q", gotKey, gotType)
+	}
+
+	p, err = newProvider("plain", c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.shorten(long); err != nil || got != "https://sho.rt/def" {
+		t.Errorf("shorten() = %q, %v", got, err)
+	}
+	if gotQuery != long || gotBody != "" {
+		t.Errorf("query = %q, body = %q, want the URL in the query and no body", gotQuery, gotBody)
+	}
+
+	for _, name := range []string{"broken", "missing"} {
+		p, err := newProvider(name, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := p.shorten(long); err == nil {
+			t.Errorf("%s: shorten() = %q, want an error", name, got)
+		}
+	}
+}
+
+func Test_newSelfProvider_path(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "shorturls.json")
+	for _, bad := range []string{"https://bot.example.com", "https://bot.example.com/"} {
+		if _, err := newSelfProvider(conf(t, "{self_url: \""+bad+"\", self_db: \""+db+"\"}")); err == nil {
+			t.Errorf("self_url %q without a path was accepted", bad)
+		}
+	}
+	if _, err := newSelfProvider(conf(t, "{self_url: \"https://bot.example.com/go\", self_db: \""+db+"\"}")); err != nil {
+		t.Errorf("self_url with a path: %s", err)
+	}
+	// the factoid browser needs authentication
+	if _, err := newSelfProvider(conf(t, "{self_url: \"https://bot.example.com/factoids/\", self_db: \""+db+"\"}")); err == nil {
+		t.Error("self_url over the factoid browser was accepted")
+	}
+}
+
+func Test_selfProvider(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "db", "shorturls.json")
+	p := &selfProvider{base: "https://bot.example.com/s/", db: db, codes: make(map[string]string), urls: make(map[string]string)}
+	a, err := p.shorten("https://example.com/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(a, p.base) || len(a) != len(p.base)+selfCodeLength {
+		t.Errorf("shorten() = %q, want a code under %s", a, p.base)
+	}
+	if again, _ := p.shorten("https://example.com/a"); again != a {
+		t.Errorf("shorten() of the same URL = %q, want %q", again, a)
+	}
+	if b, _ := p.shorten("https://example.com/b"); b == a {
+		t.Error("two URLs got the same code")
+	}
+
+	// reload from disk, and follow the link
+	loaded := &selfProvider{db: db, codes: make(map[string]string), urls: make(map[string]string)}
+	if err := loaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	h := http.StripPrefix("/s/", loaded)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/s/"+strings.TrimPrefix(a, p.base), nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "https://example.com/a" {
+		t.Errorf("redirect = %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/s/nope", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown code status = %d, want 404", w.Code)
+	}
+}
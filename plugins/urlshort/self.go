@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net/http"
+	url2 "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/adamhassel/bender/internal/helpers"
+	"github.com/adamhassel/bender/internal/lib/web"
+)
+
+const (
+	defaultSelfDB  = "db/shorturls.json"
+	selfCodeLength = 6
+	selfCodeChars  = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// selfProvider is the built-in shortener. It keeps short codes in a file, and serves redirects from the bot's web
+// server.
+type selfProvider struct {
+	// base is the public URL short codes are appended to, e.g. "https://bot.example.com/s/"
+	base string
+	db   string
+	m    sync.RWMutex
+	// codes maps short codes to URLs, and urls the other way
+	codes map[string]string
+	urls  map[string]string
+}
+
+// newSelfProvider makes the built-in shortener, loads its database and registers its redirects on the web server.
+// `self_url` is the public URL of the redirects, and `self_db` the database file.
+func newSelfProvider(c map[interface{}]interface{}) (provider, error) {
+	base, ok := c["self_url"].(string)
+	if !ok || base == "" {
+		return nil, errors.New("the self service needs self_url, the public URL of the bot's web server to put links under")
+	}
+	u, err := url2.Parse(base)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid self_url %q", base)
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	db := defaultSelfDB
+	if d, ok := c["self_db"]; ok {
+		if db, ok = d.(string); !ok {
+			return nil, errors.New("invalid self_db format")
+		}
+	}
+	p := &selfProvider{base: base, db: db, codes: make(map[string]string), urls: make(map[string]string)}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	path := strings.TrimSuffix(u.Path, "/") + "/"
+	if path == "/" {
+		return nil, fmt.Errorf("self_url %q needs a path for the links, like https://bot.example.com/s/", base)
+	}
+	if err := web.HandlePublic(path, http.StripPrefix(path, p)); err != nil {
+		return nil, fmt.Errorf("can't serve links under self_url: %w", err)
+	}
+	return p, nil
+}
+
+func init() {
+	registerProvider(newSelfProvider, "self")
+}
+
+// load reads the database, if there is one
+func (p *selfProvider) load() error {
+	raw, err := os.ReadFile(p.db)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, &p.codes); err != nil {
+		return fmt.Errorf("error parsing %s: %w", p.db, err)
+	}
+	for code, url := range p.codes {
+		p.urls[url] = code
+	}
+	return nil
+}
+
+// save writes the database. The caller should lock!
+func (p *selfProvider) save() error {
+	raw, err := json.MarshalIndent(p.codes, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.db), 0700); err != nil {
+		return err
+	}
+	return helpers.WriteFileAtomic(p.db, raw, 0600)
+}
+
+// newCode returns a random short code
+func newCode() (string, error) {
+	code := make([]byte, selfCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(selfCodeChars))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = selfCodeChars[n.Int64()]
+	}
+	return string(code), nil
+}
+
+func (p *selfProvider) shorten(url string) (string, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if code, ok := p.urls[url]; ok {
+		return p.base + code, nil
+	}
+	for {
+		code, err := newCode()
+		if err != nil {
+			return "", err
+		}
+		if _, taken := p.codes[code]; taken {
+			continue
+		}
+		p.codes[code], p.urls[url] = url, code
+		if err := p.save(); err != nil {
+			return "", err
+		}
+		return p.base + code, nil
+	}
+}
+
+// ServeHTTP redirects a short code to its URL
+func (p *selfProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.m.RLock()
+	url, ok := p.codes[r.URL.Path]
+	p.m.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, url, http.StatusMovedPermanently)
+}
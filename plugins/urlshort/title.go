@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	url2 "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// Defaults for fetching titles
+const (
+	defaultTitleMaxBytes = 512 * 1024
+	defaultTitleTimeout  = 5 * time.Second
+	titleCacheTTL        = time.Hour
+	titleCacheSize       = 1000
+	maxTitleLen          = 200
+)
+
+var ErrPrivateAddress = errors.New("refusing to fetch from a private address")
+
+var (
+	// titles turns fetching page titles on
+	titles        bool
+	titleMaxBytes int64 = defaultTitleMaxBytes
+	titleTimeout        = defaultTitleTimeout
+	// titleIgnore are domains not to fetch titles from. Subdomains are ignored too.
+	titleIgnore []string
+	// titlePrivate allows fetching titles from loopback and private addresses, which is off by default, so links in a
+	// channel can't be used to probe the network the bot runs in
+	titlePrivate bool
+)
+
+// preview is what's shown for a link
+type preview struct {
+	Title       string
+	Description string
+	Duration    time.Duration
+}
+
+// String formats p for the channel. Videos get their length, and for some sites the description is added.
+func (p preview) String(host string) string {
+	s := p.Title
+	if p.Duration > 0 {
+		s = fmt.Sprintf("%s [%s]", s, formatDuration(p.Duration))
+	}
+	if describe(host) && p.Description != "" && !strings.Contains(p.Title, p.Description) {
+		s = fmt.Sprintf("%s: %s", s, p.Description)
+	}
+	return truncate(s, maxTitleLen)
+}
+
+// describe returns true for sites where the description says more than the title, like the description of a repository
+func describe(host string) bool {
+	for _, d := range []string{"github.com", "gitlab.com", "codeberg.org"} {
+		if domainMatch(host, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatDuration formats d like a video player does, e.g. 4:13 or 1:02:03
+func formatDuration(d time.Duration) string {
+	s := int(d.Round(time.Second).Seconds())
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// truncate shortens s to max runes, with an ellipsis if it was cut
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return strings.TrimSpace(string(r[:max-1])) + "…"
+}
+
+// domainMatch returns true if host is domain, or a subdomain of it
+func domainMatch(host, domain string) bool {
+	host, domain = strings.ToLower(host), strings.ToLower(strings.TrimPrefix(domain, "."))
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// ignored returns true if titles shouldn't be fetched from host
+func ignored(host string) bool {
+	for _, d := range titleIgnore {
+		if domainMatch(host, d) {
+			return true
+		}
+	}
+	return false
+}
+
+type cachedTitle struct {
+	title   string
+	fetched time.Time
+}
+
+var (
+	tcm        sync.Mutex
+	titleCache = make(map[string]cachedTitle)
+)
+
+// cachedPageTitle returns the title of the page at url from the cache, if it's there and fresh
+func cachedPageTitle(url string) (string, bool) {
+	tcm.Lock()
+	defer tcm.Unlock()
+	c, ok := titleCache[url]
+	if !ok || time.Since(c.fetched) > titleCacheTTL {
+		return "", false
+	}
+	return c.title, true
+}
+
+// cacheTitle saves the title of the page at url in the cache. When the cache is full, stale entries are dropped, and if
+// that's not enough, the oldest one.
+func cacheTitle(url, title string) {
+	tcm.Lock()
+	defer tcm.Unlock()
+	if len(titleCache) >= titleCacheSize {
+		var oldest string
+		for u, c := range titleCache {
+			if time.Since(c.fetched) > titleCacheTTL {
+				delete(titleCache, u)
+				continue
+			}
+			if oldest == "" || c.fetched.Before(titleCache[oldest].fetched) {
+				oldest = u
+			}
+		}
+		if len(titleCache) >= titleCacheSize {
+			delete(titleCache, oldest)
+		}
+	}
+	titleCache[url] = cachedTitle{title, time.Now()}
+}
+
+// pageTitle returns the formatted title of the page at url, or an empty string if it has none, or shouldn't be fetched.
+// Titles, and the lack of them, are cached.
+func pageTitle(url string) (string, error) {
+	if title, ok := cachedPageTitle(url); ok {
+		return title, nil
+	}
+	u, err := url2.Parse(url)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || ignored(u.Hostname()) {
+		return "", nil
+	}
+	p, err := fetchPreview(url)
+	if err != nil {
+		return "", err
+	}
+	title := ""
+	if p.Title != "" {
+		title = p.String(u.Hostname())
+	}
+	cacheTitle(url, title)
+	return title, nil
+}
+
+// noPrivate refuses connections to loopback, private, link local and unspecified addresses. It's a net.Dialer Control
+// function, so it sees the address actually connected to, after DNS resolution and redirects.
+func noPrivate(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || private(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+	return nil
+}
+
+// private tells if ip is a loopback, private, link local or unspecified address
+func private(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified()
+}
+
+// titleProxy returns the proxy to fetch a title through: the configured `proxy`, or the one from the environment
+func titleProxy(req *http.Request) (*url2.URL, error) {
+	if proxyURL != nil {
+		return proxyURL, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// titleTransport fetches titles without going to private addresses. Direct fetches are checked by noPrivate when
+// connecting. Through a proxy, the connection is to the proxy, which may well be on a private address itself, so the
+// page's host is looked up and checked before the request is sent instead.
+type titleTransport struct {
+	direct, proxied http.RoundTripper
+}
+
+func (t titleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	proxy, err := titleProxy(req)
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil {
+		return t.direct.RoundTrip(req)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(req.Context(), req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if private(a.IP) {
+			return nil, fmt.Errorf("%w: %s", ErrPrivateAddress, req.URL.Hostname())
+		}
+	}
+	return t.proxied.RoundTrip(req)
+}
+
+// newTitleClient returns the HTTP client used to fetch titles. It uses the same proxy as the shortening services.
+func newTitleClient() *http.Client {
+	dialer := &net.Dialer{Timeout: titleTimeout}
+	proxied := http.DefaultTransport.(*http.Transport).Clone()
+	proxied.Proxy = titleProxy
+	proxied.DialContext = dialer.DialContext
+	var transport http.RoundTripper = proxied
+	if !titlePrivate {
+		direct := proxied.Clone()
+		direct.Proxy = nil
+		direct.DialContext = (&net.Dialer{Timeout: titleTimeout, Control: noPrivate}).DialContext
+		transport = titleTransport{direct: direct, proxied: proxied}
+	}
+	return &http.Client{
+		Timeout:   titleTimeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return nil
+		},
+	}
+}
+
+var titleClient = newTitleClient()
+
+// fetchPreview fetches the page at url, and reads its title and metadata. Only the first titleMaxBytes bytes are read,
+// and only HTML pages are parsed.
+func fetchPreview(url string) (preview, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), titleTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return preview{}, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.1")
+	req.Header.Set("User-Agent", userAgent)
+	res, err := titleClient.Do(req)
+	if err != nil {
+		return preview{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return preview{}, fmt.Errorf("got %s fetching title", res.Status)
+	}
+	contentType := res.Header.Get("Content-Type")
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil && mt != "text/html" && mt != "application/xhtml+xml" {
+		return preview{}, nil
+	}
+	body, err := charset.NewReader(io.LimitReader(res.Body, titleMaxBytes), contentType)
+	if err != nil {
+		return preview{}, err
+	}
+	return parsePreview(body), nil
+}
+
+// parsePreview reads the title and metadata of an HTML page. OpenGraph titles are preferred over <title>. It stops at
+// <body>, or when r is exhausted.
+func parsePreview(r io.Reader) preview {
+	var p preview
+	var title string
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return p.withTitle(title)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.DataAtom {
+			case atom.Body:
+				return p.withTitle(title)
+			case atom.Title:
+				if z.Next() == html.TextToken && title == "" {
+					title = string(z.Text())
+				}
+			case atom.Meta:
+				p.meta(t)
+			}
+		}
+	}
+}
+
+// withTitle sets the title of p from <title>, unless it has one from metadata, and tidies it up
+func (p preview) withTitle(title string) preview {
+	if p.Title == "" {
+		p.Title = title
+	}
+	p.Title = strings.Join(strings.Fields(p.Title), " ")
+	p.Description = strings.Join(strings.Fields(p.Description), " ")
+	return p
+}
+
+// meta reads a <meta> tag into p
+func (p *preview) meta(t html.Token) {
+	var key, content string
+	for _, a := range t.Attr {
+		switch a.Key {
+		case "property", "name", "itemprop":
+			if key == "" {
+				key = strings.ToLower(a.Val)
+			}
+		case "content":
+			content = a.Val
+		}
+	}
+	switch key {
+	case "og:title", "twitter:title":
+		if p.Title == "" {
+			p.Title = content
+		}
+	case "og:description", "twitter:description", "description":
+		if p.Description == "" {
+			p.Description = content
+		}
+	case "og:video:duration", "video:duration":
+		if s, err := strconv.Atoi(content); err == nil && p.Duration == 0 {
+			p.Duration = time.Duration(s) * time.Second
+		}
+	case "duration":
+		if d, err := parseISODuration(content); err == nil && p.Duration == 0 {
+			p.Duration = d
+		}
+	}
+}
+
+// configureTitles reads the "titles", "title_maxbytes", "title_timeout", "title_ignore" and "title_private" directives
+func configureTitles(c map[interface{}]interface{}) error {
+	titles = false
+	if t, ok := c["titles"]; ok {
+		if titles, ok = t.(bool); !ok {
+			return errors.New("invalid titles format")
+		}
+	}
+	titleMaxBytes = defaultTitleMaxBytes
+	if m, ok := c["title_maxbytes"]; ok {
+		n, ok := m.(int)
+		if !ok || n <= 0 {
+			return errors.New("invalid title_maxbytes format")
+		}
+		titleMaxBytes = int64(n)
+	}
+	titleTimeout = defaultTitleTimeout
+	if t, ok := c["title_timeout"]; ok {
+		n, ok := t.(int)
+		if !ok || n <= 0 {
+			return errors.New("invalid title_timeout format, expected seconds")
+		}
+		titleTimeout = time.Duration(n) * time.Second
+	}
+	titleIgnore = nil
+	if i, ok := c["title_ignore"]; ok {
+		domains, ok := i.([]interface{})
+		if !ok {
+			return errors.New("invalid title_ignore format")
+		}
+		for _, d := range domains {
+			ds, ok := d.(string)
+			if !ok {
+				return errors.New("invalid title_ignore format")
+			}
+			titleIgnore = append(titleIgnore, ds)
+		}
+	}
+	titlePrivate = false
+	if p, ok := c["title_private"]; ok {
+		if titlePrivate, ok = p.(bool); !ok {
+			return errors.New("invalid title_private format")
+		}
+	}
+	titleClient = newTitleClient()
+	return nil
+}
+
+// parseISODuration parses the time part of an ISO 8601 duration, like PT1H4M13S, which is how YouTube and others give
+// the length of a video
+func parseISODuration(s string) (time.Duration, error) {
+	rest, ok := strings.CutPrefix(strings.ToUpper(s), "PT")
+	if !ok || rest == "" {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	var d time.Duration
+	for rest != "" {
+		i := strings.IndexAny(rest, "HMS")
+		if i < 1 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		n, err := strconv.Atoi(rest[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		switch rest[i] {
+		case 'H':
+			d += time.Duration(n) * time.Hour
+		case 'M':
+			d += time.Duration(n) * time.Minute
+		case 'S':
+			d += time.Duration(n) * time.Second
+		}
+		rest = rest[i+1:]
+	}
+	return d, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parsePreview(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want preview
+	}{
+		{
+			name: "title",
+			page: "<html><head><title>\n  Hello,\n  world </title></head><body><title>not this</title></body></html>",
+			want: preview{Title: "Hello, world"},
+		},
+		{
+			name: "opengraph",
+			page: `<head><title>Plain</title><meta property="og:title" content="Rich &amp; fancy">
+				<meta property="og:description" content="A thing"></head>`,
+			want: preview{Title: "Rich & fancy", Description: "A thing"},
+		},
+		{
+			name: "video",
+			page: `<head><meta property="og:title" content="A video"><meta itemprop="duration" content="PT1H4M3S"></head>`,
+			want: preview{Title: "A video", Duration: time.Hour + 4*time.Minute + 3*time.Second},
+		},
+		{
+			name: "no title",
+			page: `<p>hello</p>`,
+			want: preview{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePreview(strings.NewReader(tt.page)); got != tt.want {
+				t.Errorf("parsePreview() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_preview_String(t *testing.T) {
+	p := preview{Title: "owner/repo", Description: "Does things", Duration: 253 * time.Second}
+	if got, want := p.String("github.com"), "owner/repo [4:13]: Does things"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got, want := p.String("example.com"), "owner/repo [4:13]"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	long := preview{Title: strings.Repeat("x", 300)}
+	if got := []rune(long.String("example.com")); len(got) != maxTitleLen || got[len(got)-1] != '…' {
+		t.Errorf("String() of a long title wasn't truncated: %q", string(got))
+	}
+}
+
+func Test_pageTitle(t *testing.T) {
+	pages := map[string]func(w http.ResponseWriter){
+		"/latin1": func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+			w.Write([]byte("<title>Bl\xe5b\xe6rgr\xf8d</title>"))
+		},
+		"/meta-charset": func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<meta charset=\"windows-1252\"><title>caf\xe9</title>"))
+		},
+		"/image": func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("<title>not a page</title>"))
+		},
+		"/huge": func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(strings.Repeat(" ", 2048) + "<title>too far in</title>"))
+		},
+		"/gone": func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusNotFound)
+		},
+	}
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		pages[r.URL.Path](w)
+	}))
+	defer srv.Close()
+
+	titlePrivate, titleMaxBytes = true, 1024
+	titleClient = newTitleClient()
+	defer func() {
+		titlePrivate, titleMaxBytes, titleIgnore = false, defaultTitleMaxBytes, nil
+		titleClient = newTitleClient()
+	}()
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "/latin1", want: "Blåbærgrød"},
+		{path: "/meta-charset", want: "café"},
+		{path: "/image", want: ""},
+		{path: "/huge", want: ""},
+		{path: "/gone", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := pageTitle(srv.URL + tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pageTitle() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("pageTitle() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// cached
+	before := fetches
+	if got, _ := pageTitle(srv.URL + "/latin1"); got != "Blåbærgrød" || fetches != before {
+		t.Errorf("pageTitle() = %q with %d fetches, want the cached title", got, fetches-before)
+	}
+	// ignored
+	titleIgnore = []string{"127.0.0.1"}
+	if got, _ := pageTitle(srv.URL + "/meta-charset?again"); got != "" || fetches != before {
+		t.Errorf("pageTitle() fetched %q from an ignored domain", got)
+	}
+}
+
+func Test_noPrivate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<title>secret</title>"))
+	}))
+	defer srv.Close()
+	titleClient = newTitleClient()
+	if _, err := fetchPreview(srv.URL); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("fetchPreview() error = %v, want %v", err, ErrPrivateAddress)
+	}
+}
+
+func Test_titleProxy(t *testing.T) {
+	var fetched []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = append(fetched, r.URL.String())
+		w.Write([]byte("<title>proxied</title>"))
+	}))
+	defer proxy.Close()
+	if err := configureClient(map[interface{}]interface{}{"proxy": proxy.URL}); err != nil {
+		t.Fatal(err)
+	}
+	titleClient = newTitleClient()
+	defer func() {
+		proxyURL, httpClient = nil, newClient(defaultTimeout, nil)
+		titleClient = newTitleClient()
+	}()
+
+	// the proxy is on loopback, but the page isn't
+	if p, err := fetchPreview("http://192.0.2.1/page"); err != nil || p.Title != "proxied" {
+		t.Errorf("fetchPreview() = %v, %v, want the title through the proxy", p, err)
+	}
+	if _, err := fetchPreview("http://10.0.0.1/"); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("fetchPreview() error = %v, want %v", err, ErrPrivateAddress)
+	}
+	if len(fetched) != 1 || fetched[0] != "http://192.0.2.1/page" {
+		t.Errorf("proxy fetched %q", fetched)
+	}
+}
+
+func Test_domainMatch(t *testing.T) {
+	tests := []struct {
+		host, domain string
+		want         bool
+	}{
+		{"example.com", "example.com", true},
+		{"www.Example.com", "example.com", true},
+		{"notexample.com", "example.com", false},
+		{"example.com", ".example.com", true},
+	}
+	for _, tt := range tests {
+		if got := domainMatch(tt.host, tt.domain); got != tt.want {
+			t.Errorf("domainMatch(%q, %q) = %v, want %v", tt.host, tt.domain, got, tt.want)
+		}
+	}
+}
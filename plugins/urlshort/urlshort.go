@@ -1,66 +1,30 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
-	"net/http/httputil"
 	url2 "net/url"
 	"os"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	irc "github.com/thoj/go-ircevent"
-	"github.com/valyala/fastjson"
 	"mvdan.cc/xurls/v2"
 
 	"github.com/adamhassel/bender/internal/helpers"
+	bot "github.com/adamhassel/bender/internal/lib/irc"
 )
 
-type service int
-
-const (
-	unknown service = iota
-	bitly
-	tinyurl
-	cleanuri
-	isgd
-	shortio
-)
-
-func ParseService(s string) service {
-	switch strings.ToLower(s) {
-	case "bitly", "bit.ly":
-		return bitly
-	case "tinyurl", "tinyurl.com":
-		return tinyurl
-	case "clean", "cleanuri":
-		return cleanuri
-	case "is.gd", "isgd":
-		return isgd
-	case "short.io", "shortio":
-		return shortio
-	default:
-		return unknown
-	}
-}
-
-func authString(s service, token string) string {
-	switch s {
-	case shortio:
-		return token
-	}
-	return "Bearer " + token
-}
-
+// default API endpoints of the built-in services. They can be changed under "endpoints" in the configuration.
 const bitlyAPIUrl = "https://api-ssl.bitly.com/v4/shorten"
 const tinyurlAPIUrl = "https://api.tinyurl.com/create"
 const cleanuriAPIUrl = "https://cleanuri.com/api/v1/shorten"
 const isgdAPIUrl = "https://is.gd/create.php"
 const shortioAPIUrl = "https://api.short.io/links/public"
+
 const cleanparamfile = "plugins/urlshort/tracking.json"
 
 var Matchers = []string{"UrlShort"}
@@ -68,26 +32,59 @@ var Matchers = []string{"UrlShort"}
 var ErrNoCustomDomain = errors.New("custom domain undefined")
 
 var apikey, customDomain string
-var cleanup bool
+var cleanup, postCleaned bool
 var minlen int
-var serv service
+var prov provider
 var cleanlist helpers.Set[string]
 
-// UrlShort asks a shortener to shorten any link in `msg` longer than `minlen`
+// UrlShort asks a shortener to shorten any link in `msg` longer than `minlen`. If post_cleaned is on, links that are
+// shorter than that after cleanup are posted cleaned instead. If titles are on, the title of each page is added, and
+// if reposts are on, who posted the link first.
 func UrlShort(msg string, e *irc.Event) (string, bool) {
 	m := xurls.Strict()
 	urls := m.FindAllString(msg, -1)
 	shorts := make([]string, 0, len(urls))
 	for _, url := range urls {
-		if len(url) < minlen {
-			continue
+		var link, title, repost string
+		clean := canonicalURL(url)
+		if reposts && len(e.Arguments) > 0 && strings.HasPrefix(e.Arguments[0], "#") {
+			now := time.Now()
+			first, ok, err := seen(bot.Network(e.Connection), e.Arguments[0], clean, e.Nick, now)
+			if err != nil {
+				log.Errorf("error saving url history: %s", err)
+			}
+			if ok && !strings.EqualFold(first.Nick, e.Nick) {
+				repost = fmt.Sprintf("repost detected: first posted by %s %s", first.Nick, ago(first.Time, now))
+			}
+		}
+		switch {
+		case postCleaned && clean != url && len(clean) < minlen:
+			// cleaning made it short enough already
+			link = clean
+		case len(url) >= minlen:
+			var err error
+			if link, err = shortenUrl(clean, prov); err != nil {
+				log.Infof("error looking up url %q: %s", url, err)
+			}
+		}
+		if titles {
+			var err error
+			if title, err = pageTitle(url); err != nil {
+				log.Infof("error fetching title of %q: %s", url, err)
+			}
 		}
-		link, err := shortenUrl(url, serv)
-		if err != nil {
-			log.Infof("error looking up url %q: %s", url, err)
-			continue
+		var parts []string
+		for _, part := range []string{link, title, repost} {
+			if part != "" {
+				parts = append(parts, part)
+			}
 		}
-		shorts = append(shorts, link)
+		if len(parts) > 0 {
+			shorts = append(shorts, strings.Join(parts, " - "))
+		}
+	}
+	if titles || reposts {
+		return strings.Join(shorts, " | "), false
 	}
 	return strings.Join(shorts, " "), false
 }
@@ -100,13 +97,11 @@ func Configure(c map[interface{}]interface{}) error {
 			return errors.New("invalid custom_domain format")
 		}
 	}
-	key, ok := c["apikey"]
-	if !ok {
-		return errors.New("no apikey found")
-	}
-	apikey, ok = key.(string)
-	if !ok {
-		return errors.New("invalid apikey format")
+	apikey = ""
+	if key, ok := c["apikey"]; ok {
+		if apikey, ok = key.(string); !ok {
+			return errors.New("invalid apikey format")
+		}
 	}
 	len, ok := c["minlen"]
 	if ok { // not configured? No min len
@@ -115,14 +110,18 @@ func Configure(c map[interface{}]interface{}) error {
 	if !ok {
 		return errors.New("invalid minlen format")
 	}
-	serv = cleanuri // default to cleanuri
-	s, ok := c["service"]
-	if ok {
-		ss, ok := s.(string)
-		if !ok {
+	service := "cleanuri" // default to cleanuri
+	if s, ok := c["service"]; ok {
+		if service, ok = s.(string); !ok {
 			return errors.New("invalid service format")
 		}
-		serv = ParseService(ss)
+	}
+	if err := configureClient(c); err != nil {
+		return err
+	}
+	var err error
+	if prov, err = newProvider(service, c); err != nil {
+		return err
 	}
 	cleanup = true
 	clean, ok := c["cleanup"]
@@ -133,142 +132,61 @@ func Configure(c map[interface{}]interface{}) error {
 			return errors.New("invalid cleanup format")
 		}
 	}
-	// load the list
+	// load the list and the rules
+	rules = nil
 	if cleanup {
 		if err := loadCleanParams(cleanparamfile); err != nil {
 			return errors.New("error loading cleanup parameters file")
 		}
-	}
-	return nil
-}
-
-func shortenUrl(url string, service service) (string, error) {
-	var req *http.Request
-	var resultKey []string
-	if cleanup {
-		var err error
-		url, err = cleanURL(url)
-		if err != nil {
-			log.Errorf("error cleaning url: %s", err)
+		file := defaultRulesFile
+		if f, ok := c["rules_file"]; ok {
+			if file, ok = f.(string); !ok {
+				return errors.New("invalid rules_file format")
+			}
 		}
-
-	}
-	switch service {
-	case bitly:
-		var err error
-		req, err = bitlyShortUrl(url)
-		if err != nil {
-			return "", err
-		}
-		resultKey = []string{"link"}
-	case tinyurl:
-		var err error
-		req, err = tinyURLShortUrl(url)
-		if err != nil {
-			return "", err
-		}
-		resultKey = []string{"data", "tiny_url"}
-	case cleanuri:
-		var err error
-		req, err = cleanuriShortUrl(url)
-		if err != nil {
-			return "", err
-		}
-		resultKey = []string{"result_url"}
-	case isgd:
-		var err error
-		req, err = isgdShortUrl(url)
-		if err != nil {
-			return "", err
+		if rules, err = loadRules(file); err != nil {
+			return fmt.Errorf("error loading cleanup rules: %w", err)
 		}
-		resultKey = []string{"shorturl"}
-	case shortio:
-		var err error
-		req, err = shortioShortUrl(url)
-		if err != nil {
-			return "", err
-		}
-		resultKey = []string{"shortURL"}
-	default:
-		return "", errors.New("unknown service")
-	}
-	client := http.Client{}
-	if len(apikey) > 0 {
-
-		req.Header.Set("Authorization", authString(service, apikey))
-	}
-	if req.Header.Get("Content-Type") == "" {
-		req.Header.Set("Content-Type", "application/json")
 	}
-	if dout, err := httputil.DumpRequest(req, true); err == nil {
-		log.Debugf("Request: %s", string(dout))
-	} else {
-		log.Debug(err)
-	}
-	res, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer res.Body.Close()
-	var result []byte
-	result, err = io.ReadAll(res.Body)
-	if err != nil {
-		return "", err
+	postCleaned = false
+	if pc, ok := c["post_cleaned"]; ok {
+		if postCleaned, ok = pc.(bool); !ok {
+			return errors.New("invalid post_cleaned format")
+		}
 	}
-	log.Debug(string(result))
-	if res.StatusCode >= 300 {
-		return "", fmt.Errorf("got error reply from upstream: %s, body %q", res.Status, string(result))
+	if err := configureHistory(c); err != nil {
+		return err
 	}
-	return fastjson.GetString(result, resultKey...), nil
+	return configureTitles(c)
 }
 
-func bitlyShortUrl(url string) (*http.Request, error) {
-	cd := `"domain":"bit.ly",`
-	if customDomain != "" {
-		cd = `"domain":"` + customDomain + `",`
+// canonicalURL returns url without tracking parameters if cleanup is on, and as is otherwise
+func canonicalURL(url string) string {
+	if !cleanup {
+		return url
 	}
-	body := fmt.Sprintf(` {`+cd+`
-		"long_url" : %q
-		}`, url)
-	return http.NewRequest("POST", bitlyAPIUrl, bytes.NewBufferString(body))
-}
-
-func shortioShortUrl(url string) (*http.Request, error) {
-	if customDomain == "" {
-		return nil, ErrNoCustomDomain
+	clean, err := cleanURL(url)
+	if err != nil {
+		log.Errorf("error cleaning url: %s", err)
+		return url
 	}
-	cd := `"domain":"` + customDomain + `",`
-	body := fmt.Sprintf(` {`+cd+`
-		"originalURL" : %q
-		}`, url)
-	return http.NewRequest("POST", shortioAPIUrl, bytes.NewBufferString(body))
+	return clean
 }
 
-func tinyURLShortUrl(url string) (*http.Request, error) {
-	var cd string
-	if customDomain != "" {
-		cd = `"domain":"` + customDomain + `",`
+// shortenUrl asks p for a short link for url, which should be cleaned already. Links are cached, so p is only asked
+// once for each URL.
+func shortenUrl(url string, p provider) (string, error) {
+	if link, ok := cachedLink(url); ok {
+		return link, nil
 	}
-	body := fmt.Sprintf(` {`+cd+`
-		"url" : %q
-		}`, url)
-	return http.NewRequest("POST", tinyurlAPIUrl, bytes.NewBufferString(body))
-}
-
-func cleanuriShortUrl(url string) (*http.Request, error) {
-	body := fmt.Sprintf(` { "url" : %q }`, url)
-	return http.NewRequest("POST", cleanuriAPIUrl, bytes.NewBufferString(body))
-}
-
-func isgdShortUrl(url string) (*http.Request, error) {
-	body := fmt.Sprintf("format=json&url=%s", url2.QueryEscape(url))
-	log.Info("yay")
-	req, err := http.NewRequest("POST", isgdAPIUrl, bytes.NewBufferString(body))
+	link, err := p.shorten(url)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	return req, nil
+	if err := cacheLink(url, link); err != nil {
+		log.Errorf("error saving url cache: %s", err)
+	}
+	return link, nil
 }
 
 func loadCleanParams(filename string) error {
@@ -296,19 +214,29 @@ func loadCleanParams(filename string) error {
 	return nil
 }
 
+// cleanURL removes tracking from url, with the ClearURLs rules and the list of tracking parameters
 func cleanURL(url string) (string, error) {
+	url, err := applyRules(url, rules)
+	if err != nil {
+		return "", err
+	}
 	u, err := url2.Parse(url)
 	if err != nil {
 		return "", err
 	}
 
 	v := u.Query()
+	removed := false
 	for k := range v {
 		if cleanlist.Exists(k) {
 			v.Del(k)
+			removed = true
 			log.Infof("removed tracking parameter %q from url", k)
 		}
 	}
+	if !removed {
+		return url, nil
+	}
 	u.RawQuery = v.Encode()
 	return u.String(), nil
 }